@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames — имена файла конфигурации, которые ищутся в рабочей директории.
+// configFileNames are config file names looked up in the working directory.
+var configFileNames = []string{"lamigrate.yaml", "lamigrate.yml"}
+
+// fileSettings — настройки одного уровня lamigrate.yaml (верхний уровень или окружение).
+// Назначение: пустое значение (nil для длительностей) означает «не задано».
+// fileSettings are settings of one lamigrate.yaml level (top level or an environment).
+// Purpose: an empty value (nil for durations) means "not set".
+type fileSettings struct {
+	Dir         string         `yaml:"dir"`
+	Driver      string         `yaml:"driver"`
+	DSN         string         `yaml:"dsn"`
+	Timeout     *time.Duration `yaml:"timeout"`
+	LockTimeout *time.Duration `yaml:"lock_timeout"`
+	TxMode      string         `yaml:"tx_mode"`
+	LogFormat   string         `yaml:"log_format"`
+	Table       string         `yaml:"table"`
+	Schema      string         `yaml:"schema"`
+}
+
+// fileConfig — содержимое lamigrate.yaml.
+// Назначение: общие настройки и переопределения по именованным окружениям.
+// fileConfig is the content of lamigrate.yaml.
+// Purpose: shared settings and overrides per named environment.
+type fileConfig struct {
+	fileSettings `yaml:",inline"`
+	Environments map[string]fileSettings `yaml:"environments"`
+}
+
+// findConfigFile определяет путь к файлу конфигурации.
+// Вход: путь из -config (пустой — LAMIGRATE_CONFIG, затем поиск в рабочей директории).
+// Выход: путь или пустая строка, если файла нет; error, если явно заданный файл не найден.
+// Назначение: файл необязателен, пока его не требуют явно.
+// findConfigFile determines the config file path.
+// Input: path from -config (empty means LAMIGRATE_CONFIG, then a working directory lookup).
+// Output: path or empty string if there is no file; error if an explicit file is missing.
+// Purpose: the file is optional unless explicitly requested.
+func findConfigFile(explicit string) (string, error) {
+	path := pickEnv("LAMIGRATE_CONFIG", explicit)
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("config file: %w", err)
+		}
+		return path, nil
+	}
+
+	for _, name := range configFileNames {
+		if _, err := os.Stat(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// loadConfigFile читает и проверяет lamigrate.yaml.
+// Вход: путь к файлу.
+// Выход: fileConfig или error при ошибке чтения, разбора или неизвестном ключе.
+// Назначение: опечатка в ключе не должна молча игнорироваться.
+// loadConfigFile reads and validates lamigrate.yaml.
+// Input: file path.
+// Output: fileConfig or error on read/parse failure or an unknown key.
+// Purpose: a typo in a key must not be silently ignored.
+func loadConfigFile(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("read config file: %w", err)
+	}
+
+	var parsed fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&parsed); err != nil && !errors.Is(err, io.EOF) {
+		return fileConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return parsed, nil
+}
+
+// applyConfigFile переносит значения из lamigrate.yaml в cfg для флагов, не заданных явно.
+// Вход: cfg после разбора флагов.
+// Выход: error, если файл не читается или окружение не найдено.
+// Назначение: уровень «файл» в порядке приоритета; окружение перекрывает верхний уровень.
+// Относительный dir считается от директории файла, ${VAR} в dsn раскрываются из окружения.
+// applyConfigFile copies values from lamigrate.yaml into cfg for flags not set explicitly.
+// Input: cfg after flag parsing.
+// Output: error if the file cannot be read or the environment is not found.
+// Purpose: the "file" level of the precedence order; an environment overrides the top level.
+// A relative dir is resolved against the file's directory, ${VAR} in dsn is expanded from the environment.
+func applyConfigFile(cfg *config) error {
+	cfg.envName = pickEnv("LAMIGRATE_ENV", cfg.envName)
+
+	explicit := map[string]bool{}
+	if cfg.fs != nil {
+		cfg.fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	}
+	for name := range explicit {
+		cfg.sources[name] = "flag -" + name
+	}
+
+	path, err := findConfigFile(cfg.configPath)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		if cfg.envName != "" {
+			return fmt.Errorf("-env %s requires %s", cfg.envName, configFileNames[0])
+		}
+		return nil
+	}
+	cfg.configFile = path
+
+	parsed, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	levels := []struct {
+		settings fileSettings
+		source   string
+	}{{parsed.fileSettings, path}}
+	if cfg.envName != "" {
+		settings, ok := parsed.Environments[cfg.envName]
+		if !ok {
+			return fmt.Errorf("environment %q not found in %s (available: %s)", cfg.envName, path, environmentNames(parsed))
+		}
+		levels = append(levels, struct {
+			settings fileSettings
+			source   string
+		}{settings, fmt.Sprintf("%s, env %s", path, cfg.envName)})
+	}
+
+	setString := func(name string, target *string, value, source string) {
+		if value == "" || explicit[name] {
+			return
+		}
+		*target = value
+		cfg.sources[name] = source
+	}
+	setDuration := func(name string, target *time.Duration, value *time.Duration, source string) {
+		if value == nil || explicit[name] {
+			return
+		}
+		*target = *value
+		cfg.sources[name] = source
+	}
+
+	baseDir := filepath.Dir(path)
+	for _, level := range levels {
+		dir := level.settings.Dir
+		if dir != "" && !filepath.IsAbs(dir) {
+			dir = filepath.Join(baseDir, dir)
+		}
+		setString("dir", &cfg.migrationsDir, dir, level.source)
+		setString("driver", &cfg.driverName, level.settings.Driver, level.source)
+		setString("dsn", &cfg.dsn, os.ExpandEnv(level.settings.DSN), level.source)
+		setDuration("timeout", &cfg.timeout, level.settings.Timeout, level.source)
+		setDuration("lock-timeout", &cfg.lockTimeout, level.settings.LockTimeout, level.source)
+		setString("tx-mode", &cfg.txMode, level.settings.TxMode, level.source)
+		setString("log-format", &cfg.logFormat, level.settings.LogFormat, level.source)
+		setString("table", &cfg.table, level.settings.Table, level.source)
+		setString("schema", &cfg.schema, level.settings.Schema, level.source)
+	}
+	return nil
+}
+
+// environmentNames возвращает имена окружений файла через запятую.
+// environmentNames returns the file's environment names separated by commas.
+func environmentNames(parsed fileConfig) string {
+	if len(parsed.Environments) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(parsed.Environments))
+	for name := range parsed.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// secretParamPattern находит пароли в DSN вида key=value и в query-параметрах.
+// secretParamPattern matches passwords in key=value DSNs and query parameters.
+var secretParamPattern = regexp.MustCompile(`(?i)\b((?:ssl)?password|pass|pwd)=('[^']*'|[^&\s]+)`)
+
+// maskDSN скрывает пароль в DSN.
+// Вход: DSN в виде URL, MySQL (user:pass@tcp(...)) или key=value.
+// Выход: DSN с паролем, заменённым на ****.
+// Назначение: config show не должен печатать секреты.
+// maskDSN hides the password in a DSN.
+// Input: DSN as URL, MySQL (user:pass@tcp(...)) or key=value.
+// Output: DSN with the password replaced by ****.
+// Purpose: config show must not print secrets.
+func maskDSN(dsn string) string {
+	prefix, rest := "", dsn
+	authority := dsn
+	if scheme, tail, ok := strings.Cut(dsn, "://"); ok {
+		prefix, rest, authority = scheme+"://", tail, tail
+		// Пароль из ${VAR} может содержать "/", поэтому граница — только "?" и "#".
+		// A password from ${VAR} may contain "/", so only "?" and "#" end the authority.
+		if end := strings.IndexAny(tail, "?#"); end >= 0 {
+			authority = tail[:end]
+		}
+	}
+	if at := strings.LastIndex(authority, "@"); at > 0 {
+		if user, _, ok := strings.Cut(rest[:at], ":"); ok {
+			rest = user + ":****" + rest[at:]
+		}
+	}
+	return secretParamPattern.ReplaceAllString(prefix+rest, "${1}=****")
+}
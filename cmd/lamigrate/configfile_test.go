@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testConfigFile — lamigrate.yaml с верхним уровнем и окружением prod.
+// testConfigFile is a lamigrate.yaml with a top level and a prod environment.
+const testConfigFile = `
+dir: migrations
+driver: sqlite
+dsn: file:top.db
+table: top_history
+environments:
+  prod:
+    dir: /srv/prod/migrations
+    dsn: file:${LAMIGRATE_TEST_DB}.db?_pragma=busy_timeout(5000)
+    table: prod_history
+`
+
+// clearConfigEnv сбрасывает переменные LAMIGRATE_*, чтобы окружение машины не влияло на тест.
+// clearConfigEnv clears LAMIGRATE_* variables so the machine environment does not affect the test.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"LAMIGRATE_CONFIG", "LAMIGRATE_ENV", "LAMIGRATE_MIGRATIONS_DIR", "LAMIGRATE_DSN",
+		"LAMIGRATE_DRIVER", "LAMIGRATE_TABLE", "LAMIGRATE_SCHEMA",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+// writeTestConfig пишет testConfigFile во временный каталог и возвращает путь.
+// writeTestConfig writes testConfigFile to a temporary directory and returns its path.
+func writeTestConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "lamigrate.yaml")
+	if err := os.WriteFile(path, []byte(testConfigFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// parseTestFlags разбирает аргументы так же, как команды CLI.
+// parseTestFlags parses arguments the same way CLI commands do.
+func parseTestFlags(t *testing.T, args ...string) *config {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := configFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestBuildConfigPrecedence(t *testing.T) {
+	path := writeTestConfig(t)
+	baseDir := filepath.Dir(path)
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		wantDir    string
+		wantDSN    string
+		wantTable  string
+		wantSource string
+	}{
+		{
+			name:       "file top level over defaults",
+			args:       []string{"-config", path},
+			wantDir:    filepath.Join(baseDir, "migrations"),
+			wantDSN:    "file:top.db",
+			wantTable:  "top_history",
+			wantSource: path,
+		},
+		{
+			name:       "file environment over top level",
+			args:       []string{"-config", path, "-env", "prod"},
+			env:        map[string]string{"LAMIGRATE_TEST_DB": "prod"},
+			wantDir:    "/srv/prod/migrations",
+			wantDSN:    "file:prod.db?_pragma=busy_timeout(5000)",
+			wantTable:  "prod_history",
+			wantSource: path + ", env prod",
+		},
+		{
+			name:       "environment selected by LAMIGRATE_ENV",
+			args:       []string{"-config", path},
+			env:        map[string]string{"LAMIGRATE_ENV": "prod", "LAMIGRATE_TEST_DB": "prod"},
+			wantDir:    "/srv/prod/migrations",
+			wantDSN:    "file:prod.db?_pragma=busy_timeout(5000)",
+			wantTable:  "prod_history",
+			wantSource: path + ", env prod",
+		},
+		{
+			name:       "flags over file environment",
+			args:       []string{"-config", path, "-env", "prod", "-table", "flag_history", "-dir", "flag-dir"},
+			env:        map[string]string{"LAMIGRATE_TEST_DB": "prod"},
+			wantDir:    "flag-dir",
+			wantDSN:    "file:prod.db?_pragma=busy_timeout(5000)",
+			wantTable:  "flag_history",
+			wantSource: "flag -table",
+		},
+		{
+			name:       "env over flags",
+			args:       []string{"-config", path, "-table", "flag_history", "-dsn", "file:flag.db"},
+			env:        map[string]string{"LAMIGRATE_TABLE": "env_history", "LAMIGRATE_DSN": "file:env.db"},
+			wantDir:    filepath.Join(baseDir, "migrations"),
+			wantDSN:    "file:env.db",
+			wantTable:  "env_history",
+			wantSource: "env LAMIGRATE_TABLE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			t.Setenv("LAMIGRATE_TEST_DB", "")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg := parseTestFlags(t, tt.args...)
+			driver, resolved := buildConfig(cfg, true, true)
+			if driver.Name() != "sqlite" {
+				t.Fatalf("driver = %s, want sqlite from the file", driver.Name())
+			}
+			if resolved.cfg.MigrationsDir != tt.wantDir {
+				t.Fatalf("dir = %q, want %q", resolved.cfg.MigrationsDir, tt.wantDir)
+			}
+			if resolved.cfg.DSN != tt.wantDSN {
+				t.Fatalf("dsn = %q, want %q", resolved.cfg.DSN, tt.wantDSN)
+			}
+			if resolved.cfg.Table != tt.wantTable {
+				t.Fatalf("table = %q, want %q", resolved.cfg.Table, tt.wantTable)
+			}
+			if cfg.sources["table"] != tt.wantSource {
+				t.Fatalf("table source = %q, want %q", cfg.sources["table"], tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestApplyConfigFileRelativeDir(t *testing.T) {
+	clearConfigEnv(t)
+	root := t.TempDir()
+	nested := filepath.Join(root, "deploy")
+	if err := os.Mkdir(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(nested, "lamigrate.yaml")
+	if err := os.WriteFile(path, []byte("dir: ../sql\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Файл лежит не в рабочей директории: dir считается от файла.
+	// The file is outside the working directory: dir is resolved against the file.
+	cfg := parseTestFlags(t, "-config", path)
+	if err := applyConfigFile(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "sql"); cfg.migrationsDir != want {
+		t.Fatalf("dir = %q, want %q", cfg.migrationsDir, want)
+	}
+}
+
+func TestApplyConfigFileUnknownEnvironment(t *testing.T) {
+	clearConfigEnv(t)
+	cfg := parseTestFlags(t, "-config", writeTestConfig(t), "-env", "stage")
+	err := applyConfigFile(cfg)
+	if err == nil || err.Error() != `environment "stage" not found in `+cfg.configPath+` (available: prod)` {
+		t.Fatalf("applyConfigFile() = %v, want an unknown environment error", err)
+	}
+}
+
+func TestMaskDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		want string
+	}{
+		{name: "url", dsn: "postgres://app:secret@db:5432/app?sslmode=disable", want: "postgres://app:****@db:5432/app?sslmode=disable"},
+		{name: "url without password", dsn: "postgres://app@db:5432/app", want: "postgres://app@db:5432/app"},
+		{name: "url without user", dsn: "postgres://db:5432/app", want: "postgres://db:5432/app"},
+		{name: "password with slash and at", dsn: "postgres://app:p/a@ss@db/app", want: "postgres://app:****@db/app"},
+		{name: "password in query", dsn: "postgres://db/app?user=app&password=secret", want: "postgres://db/app?user=app&password=****"},
+		{name: "mysql", dsn: "app:secret@tcp(db:3306)/app?parseTime=true", want: "app:****@tcp(db:3306)/app?parseTime=true"},
+		{name: "mysql without password", dsn: "app@tcp(db:3306)/app", want: "app@tcp(db:3306)/app"},
+		{name: "key value", dsn: "host=db user=app password=secret dbname=app", want: "host=db user=app password=**** dbname=app"},
+		{name: "key value quoted", dsn: "host=db password='s e c' sslpassword=x", want: "host=db password=**** sslpassword=****"},
+		{name: "key value without password", dsn: "host=db user=app dbname=app", want: "host=db user=app dbname=app"},
+		{name: "sqlite file", dsn: "file:app.db?_pragma=busy_timeout(5000)", want: "file:app.db?_pragma=busy_timeout(5000)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskDSN(tt.dsn); got != tt.want {
+				t.Fatalf("maskDSN(%q) = %q, want %q", tt.dsn, got, tt.want)
+			}
+		})
+	}
+}
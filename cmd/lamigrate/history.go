@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+// historyTimeLayouts — форматы абсолютного времени для -since/-until.
+// historyTimeLayouts are absolute time formats for -since/-until.
+var historyTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// parseHistoryTime разбирает значение -since/-until.
+// Вход: строка — абсолютное время (RFC3339, "2006-01-02 15:04", дата) или
+// длительность назад от now ("24h", "30m").
+// Выход: время (пустая строка — нулевое) или error.
+// Назначение: удобные фильтры вроде "-since 24h". Время без зоны считается локальным.
+// parseHistoryTime parses a -since/-until value.
+// Input: an absolute time (RFC3339, "2006-01-02 15:04", a date) or a duration
+// back from now ("24h", "30m").
+// Output: time (empty string means zero) or error.
+// Purpose: handy filters like "-since 24h". A time without a zone is local.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		if ago < 0 {
+			return time.Time{}, fmt.Errorf("duration %s must not be negative", value)
+		}
+		return now.Add(-ago), nil
+	}
+	for _, layout := range historyTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (expected RFC3339, YYYY-MM-DD[ HH:MM[:SS]] or a duration like 24h)", value)
+}
+
+// runHistory печатает журнал lamigrate_history по фильтрам.
+// Вход: cfg с флагами/окружением, фильтр и формат вывода.
+// Выход: печать в stdout; код 2 при неверных аргументах.
+// Назначение: выполнить команду history.
+// runHistory prints the lamigrate_history journal by filters.
+// Input: cfg with flags/env, filter and output format.
+// Output: prints to stdout; exit code 2 on invalid arguments.
+// Purpose: execute the history command.
+func runHistory(cfg *config, filter lamigrate.JournalFilter, format string) {
+	driver, config := buildConfig(cfg, false, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	entries, err := lamigrate.ReadJournal(ctx, config.cfg, driver, filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	switch format {
+	case "", "table":
+		printJournalTable(entries)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(newJournalOutput(entries)); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(newJournalOutput(entries)); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		_ = encoder.Close()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown history format: %s (expected table, json or yaml)\n", format)
+		os.Exit(2)
+	}
+}
+
+// journalOutput — запись журнала в выводе history -format json|yaml.
+// journalOutput is a journal entry in history -format json|yaml output.
+type journalOutput struct {
+	ID         int64  `json:"id" yaml:"id"`
+	ExecutedAt string `json:"executed_at" yaml:"executed_at"`
+	Action     string `json:"action" yaml:"action"`
+	Migration  string `json:"migration" yaml:"migration"`
+	Stage      int    `json:"stage" yaml:"stage"`
+	Checksum   string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	DurationMS int64  `json:"duration_ms" yaml:"duration_ms"`
+	OSUser     string `json:"os_user" yaml:"os_user"`
+	Hostname   string `json:"hostname" yaml:"hostname"`
+	Version    string `json:"lamigrate_version" yaml:"lamigrate_version"`
+}
+
+// newJournalOutput переводит записи журнала в формат вывода.
+// Вход: записи журнала.
+// Выход: список (пустой вместо nil).
+// Назначение: стабильная форма JSON/YAML.
+// newJournalOutput converts journal entries into the output format.
+// Input: journal entries.
+// Output: list (empty instead of nil).
+// Purpose: a stable JSON/YAML shape.
+func newJournalOutput(entries []lamigrate.JournalEntry) []journalOutput {
+	out := make([]journalOutput, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, journalOutput{
+			ID:         entry.ID,
+			ExecutedAt: entry.ExecutedAt.Format(time.RFC3339),
+			Action:     string(entry.Action),
+			Migration:  entry.Migration,
+			Stage:      entry.Stage,
+			Checksum:   entry.Checksum,
+			DurationMS: entry.Duration.Milliseconds(),
+			OSUser:     entry.OSUser,
+			Hostname:   entry.Hostname,
+			Version:    entry.Version,
+		})
+	}
+	return out
+}
+
+// printJournalTable печатает журнал таблицей от старых записей к новым.
+// Вход: записи журнала.
+// Выход: печать в stdout.
+// Назначение: формат history по умолчанию для человека.
+// printJournalTable prints the journal as a table from oldest to newest.
+// Input: journal entries.
+// Output: prints to stdout.
+// Purpose: the default human-readable history format.
+func printJournalTable(entries []lamigrate.JournalEntry) {
+	if len(entries) == 0 {
+		printTitleTable("No journal entries", colorYellow)
+		return
+	}
+
+	headers := []string{"executed_at", "action", "migration", "stage", "duration", "user", "host", "version"}
+	rows := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		rows = append(rows, []string{
+			entry.ExecutedAt.Format(time.RFC3339),
+			string(entry.Action),
+			entry.Migration,
+			strconv.Itoa(entry.Stage),
+			entry.Duration.String(),
+			entry.OSUser,
+			entry.Hostname,
+			entry.Version,
+		})
+	}
+
+	colors := make([]string, len(entries))
+	for i, entry := range entries {
+		switch entry.Action {
+		case lamigrate.JournalDown, lamigrate.JournalUnmark:
+			colors[i] = colorRed
+		case lamigrate.JournalBaseline, lamigrate.JournalMark, lamigrate.JournalRepair:
+			colors[i] = colorYellow
+		default:
+			colors[i] = colorGreen
+		}
+	}
+	printGrid(colorGray, headers, rows, colors)
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
 	"lamigrate/pkg/lamigrate"
-	"lamigrate/pkg/lamigrate/drivers/postgres"
+	_ "lamigrate/pkg/lamigrate/drivers/mysql"
+	_ "lamigrate/pkg/lamigrate/drivers/postgres"
+	_ "lamigrate/pkg/lamigrate/drivers/sqlite"
 )
 
 // version содержит текущую версию CLI.
@@ -30,6 +38,8 @@ var version = "0.1.10"
 // Output: process exit code and stdout/stderr messages.
 // Purpose: provide a simple CLI for migration operations.
 func main() {
+	lamigrate.Version = version
+
 	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
 		handleSubcommand(os.Args[1:])
 		return
@@ -61,15 +71,143 @@ func handleSubcommand(args []string) {
 
 	switch args[0] {
 	case "up":
+		dryRun := fs.Bool("dry-run", false, "показать план без выполнения")
+		showSQL := fs.Bool("sql", false, "печатать SQL в плане (с -dry-run)")
+		to := fs.String("to", "", "применить миграции до версии включительно")
 		_ = fs.Parse(args[1:])
-		runUp(cfg)
+		if *dryRun {
+			runPlan(cfg, lamigrate.DirectionUp, *to, lamigrate.DownTarget{}, *showSQL)
+			return
+		}
+		runUp(cfg, *to)
 	case "down":
-		stages := fs.Int("stages", 1, "сколько стадий откатить (только для down)")
+		target := downTargetFlags(fs)
+		dryRun := fs.Bool("dry-run", false, "показать план без выполнения")
+		showSQL := fs.Bool("sql", false, "печатать SQL в плане (с -dry-run)")
+		_ = fs.Parse(args[1:])
+		if *dryRun {
+			runPlan(cfg, lamigrate.DirectionDown, "", target(), *showSQL)
+			return
+		}
+		runDown(cfg, target())
+	case "redo":
+		stages := fs.Int("stages", 1, "сколько последних стадий переприменить")
+		migration := fs.String("migration", "", "миграция по ключу version_name или версии")
+		force := fs.Bool("force", false, "выполнить, даже если после миграции применены другие")
 		_ = fs.Parse(args[1:])
-		runDown(cfg, *stages)
+		if *migration == "" {
+			runRedo(cfg, lamigrate.LastStages(*stages))
+			return
+		}
+		if isFlagSet(fs, "stages") {
+			log.Fatal("redo: -stages and -migration are mutually exclusive")
+		}
+		runRedo(cfg, lamigrate.SingleMigration(*migration, *force))
+	case "reset":
+		_ = fs.Parse(args[1:])
+		runDown(cfg, lamigrate.ToStage(0))
+	case "fresh":
+		allow := fs.Bool("allow-destroy", false, "подтвердить удаление объектов схемы (обязательно)")
+		dropExtensions := fs.Bool("drop-extensions", false, "удалить и расширения Postgres в целевой схеме")
+		_ = fs.Parse(args[1:])
+		if !*allow {
+			fmt.Fprintln(os.Stderr, "fresh drops the objects of the target schema; refusing without -allow-destroy")
+			os.Exit(2)
+		}
+		runFresh(cfg, *dropExtensions)
+	case "config":
+		if len(args) < 2 || args[1] != "show" {
+			log.Fatal("usage: lamigrate config show [-env name]")
+		}
+		_ = fs.Parse(args[2:])
+		runConfigShow(cfg)
+	case "meta":
+		if len(args) < 2 || (args[1] != "status" && args[1] != "upgrade") {
+			log.Fatal("usage: lamigrate meta status [-format table|json|yaml] | lamigrate meta upgrade")
+		}
+		if args[1] == "upgrade" {
+			_ = fs.Parse(args[2:])
+			runMetaUpgrade(cfg)
+			return
+		}
+		format := fs.String("format", "table", "формат вывода: table, json, yaml")
+		_ = fs.Parse(args[2:])
+		runMetaStatus(cfg, *format)
+	case "baseline":
+		to := fs.String("to", "", "версия, до которой (включительно) отметить миграции применёнными")
+		_ = fs.Parse(args[1:])
+		if *to == "" {
+			log.Fatal("baseline requires -to <version>")
+		}
+		runRecord(cfg, "baseline", nil, *to)
+	case "mark-applied", "unmark":
+		keys := parsePositional(fs, args[1:])
+		if len(keys) == 0 {
+			log.Fatalf("%s requires at least one migration key or version", args[0])
+		}
+		runRecord(cfg, args[0], keys, "")
+	case "plan":
+		target := downTargetFlags(fs)
+		showSQL := fs.Bool("sql", false, "печатать SQL каждого файла")
+		direction, rest := splitDirection(args[1:])
+		_ = fs.Parse(rest)
+		if direction == "" && len(fs.Args()) > 0 {
+			direction = lamigrate.Direction(fs.Args()[0])
+		}
+		if direction == "" {
+			direction = lamigrate.DirectionUp
+		}
+		if direction == lamigrate.DirectionUp {
+			runPlan(cfg, direction, fs.Lookup("to").Value.String(), lamigrate.DownTarget{}, *showSQL)
+			return
+		}
+		runPlan(cfg, direction, "", target(), *showSQL)
 	case "status":
+		format := fs.String("format", "table", "формат вывода: table, json, yaml")
+		check := fs.Bool("check", false, "завершиться с кодом 4/5 при неприменённых/пропавших миграциях")
 		_ = fs.Parse(args[1:])
-		runStatus(cfg)
+		runStatus(cfg, *format, *check)
+	case "script":
+		stages := fs.Int("stages", 1, "сколько стадий откатить (для script down)")
+		historyFile := fs.String("history", "", "JSON-файл с экспортом истории lamigrate вместо чтения из БД")
+		output := fs.String("o", "", "файл для скрипта (по умолчанию stdout)")
+		direction, rest := splitDirection(args[1:])
+		_ = fs.Parse(rest)
+		if direction == "" && len(fs.Args()) > 0 {
+			direction = lamigrate.Direction(fs.Args()[0])
+		}
+		if direction == "history" {
+			runScriptHistory(cfg, *output)
+			return
+		}
+		runScript(cfg, direction, *stages, *historyFile, *output)
+	case "history":
+		migration := fs.String("migration", "", "только записи миграции (ключ или версия)")
+		stage := fs.Int("stage", 0, "только записи стадии")
+		since := fs.String("since", "", "записи не раньше времени (RFC3339, дата или длительность назад, например 24h)")
+		until := fs.String("until", "", "записи раньше времени (RFC3339, дата или длительность назад)")
+		limit := fs.Int("limit", 0, "сколько последних записей показать (0 — все)")
+		format := fs.String("format", "table", "формат вывода: table, json, yaml")
+		_ = fs.Parse(args[1:])
+		now := time.Now()
+		filter := lamigrate.JournalFilter{Migration: *migration, Stage: *stage, Limit: *limit}
+		var err error
+		if filter.Since, err = parseHistoryTime(*since, now); err != nil {
+			fmt.Fprintf(os.Stderr, "-since: %v\n", err)
+			os.Exit(2)
+		}
+		if filter.Until, err = parseHistoryTime(*until, now); err != nil {
+			fmt.Fprintf(os.Stderr, "-until: %v\n", err)
+			os.Exit(2)
+		}
+		runHistory(cfg, filter, *format)
+	case "verify":
+		_ = fs.Parse(args[1:])
+		runVerify(cfg)
+	case "repair":
+		yes := fs.Bool("yes", false, "не спрашивать подтверждение (только для repair)")
+		_ = fs.Parse(args[1:])
+		runRepair(cfg, *yes)
 	case "create":
 		nameFlag := fs.String("name", "", "имя миграции (если не указано, берётся первый аргумент)")
 		_ = fs.Parse(args[1:])
@@ -104,11 +242,11 @@ func handleLegacyFlags() {
 
 	switch *command {
 	case "up":
-		runUp(cfg)
+		runUp(cfg, "")
 	case "down":
-		runDown(cfg, *stages)
+		runDown(cfg, lamigrate.LastStages(*stages))
 	case "status":
-		runStatus(cfg)
+		runStatus(cfg, "table", false)
 	case "create":
 		runCreate(cfg, *name)
 	default:
@@ -116,6 +254,66 @@ func handleLegacyFlags() {
 	}
 }
 
+// downTargetFlags регистрирует -stages, -to и -to-stage для down/plan.
+// Вход: FlagSet команды.
+// Выход: функция, возвращающая цель отката после fs.Parse (завершает процесс,
+// если задано больше одного флага).
+// Назначение: единый разбор цели отката.
+// downTargetFlags registers -stages, -to and -to-stage for down/plan.
+// Input: command FlagSet.
+// Output: function returning the rollback target after fs.Parse (exits the
+// process when more than one flag is set).
+// Purpose: shared parsing of the rollback target.
+func downTargetFlags(fs *flag.FlagSet) func() lamigrate.DownTarget {
+	stages := fs.Int("stages", 1, "сколько последних стадий откатить")
+	to := fs.String("to", "", "откатить миграции новее версии (для up — применить до версии)")
+	toStage := fs.Int("to-stage", 0, "откатить стадии выше указанной (0 — все)")
+	migration := fs.String("migration", "", "откатить одну миграцию по ключу version_name или версии")
+	force := fs.Bool("force", false, "откатить -migration, даже если после неё применены другие миграции")
+
+	return func() lamigrate.DownTarget {
+		set := 0
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "stages", "to", "to-stage", "migration":
+				set++
+			}
+		})
+		if set > 1 {
+			log.Fatal("use only one of -stages, -to, -to-stage, -migration")
+		}
+
+		switch {
+		case isFlagSet(fs, "migration"):
+			return lamigrate.SingleMigration(*migration, *force)
+		case isFlagSet(fs, "to"):
+			return lamigrate.ToVersion(*to)
+		case isFlagSet(fs, "to-stage"):
+			return lamigrate.ToStage(*toStage)
+		default:
+			return lamigrate.LastStages(*stages)
+		}
+	}
+}
+
+// isFlagSet сообщает, был ли флаг задан явно.
+// Вход: FlagSet после Parse и имя флага.
+// Выход: true, если флаг был в аргументах.
+// Назначение: отличать "-to-stage 0" от значения по умолчанию.
+// isFlagSet reports whether a flag was set explicitly.
+// Input: FlagSet after Parse and flag name.
+// Output: true if the flag was present in arguments.
+// Purpose: tell "-to-stage 0" apart from the default value.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
 // configFlags регистрирует флаги конфигурации и возвращает структуру.
 // Вход: FlagSet для регистрации флагов.
 // Выход: указатель на config.
@@ -127,9 +325,18 @@ func handleLegacyFlags() {
 func configFlags(fs *flag.FlagSet) *config {
 	cfg := &config{}
 	fs.StringVar(&cfg.migrationsDir, "dir", "./migrations", "directory with migration files")
-	fs.StringVar(&cfg.driverName, "driver", "postgres", "database driver name")
+	fs.StringVar(&cfg.driverName, "driver", "", "database driver name (default: detected from DSN scheme, else postgres)")
 	fs.StringVar(&cfg.dsn, "dsn", "", "database connection string/DSN")
 	fs.DurationVar(&cfg.timeout, "timeout", 5*time.Minute, "overall migration timeout")
+	fs.StringVar(&cfg.txMode, "tx-mode", string(lamigrate.TxModeAll), "transaction mode: all, per-migration, none")
+	fs.DurationVar(&cfg.lockTimeout, "lock-timeout", time.Minute, "how long to wait for the migration lock (0 waits until -timeout)")
+	fs.StringVar(&cfg.logFormat, "log-format", "text", "log format for migration events on stderr: text, json")
+	fs.StringVar(&cfg.table, "table", "", "history table name (default: lamigrate)")
+	fs.StringVar(&cfg.schema, "schema", "", "history table schema, created if missing (default: from DSN)")
+	fs.StringVar(&cfg.configPath, "config", "", "config file (default: lamigrate.yaml in the working directory)")
+	fs.StringVar(&cfg.envName, "env", "", "environment from the config file: dev, staging, prod, ...")
+	cfg.fs = fs
+	cfg.sources = map[string]string{}
 	return cfg
 }
 
@@ -142,25 +349,39 @@ type config struct {
 	driverName    string
 	dsn           string
 	timeout       time.Duration
+	lockTimeout   time.Duration
+	txMode        string
+	logFormat     string
+	table         string
+	schema        string
+	configPath    string
+	envName       string
+
+	fs         *flag.FlagSet
+	configFile string
+	sources    map[string]string
 }
 
 // runUp запускает применение up-миграций.
-// Вход: cfg с флагами/окружением.
+// Вход: cfg с флагами/окружением, to — версия для -to (пустая — все).
 // Выход: завершает процесс при ошибке.
 // Назначение: выполнить команду up.
 // runUp runs applying up migrations.
-// Input: cfg with flags/env.
+// Input: cfg with flags/env, to version for -to (empty means all).
 // Output: exits process on error.
 // Purpose: execute the up command.
-func runUp(cfg *config) {
-	driver, config := buildConfig(cfg, true)
+func runUp(cfg *config, to string) {
+	driver, config := buildConfig(cfg, true, true)
 	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
 	defer cancel()
 
 	start := time.Now()
-	applied, err := lamigrate.ApplyUp(ctx, config.cfg, driver)
+	applied, err := lamigrate.ApplyUpTo(ctx, config.cfg, driver, to)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+		for _, name := range applied {
+			fmt.Printf("committed before failure: %s\n", name)
+		}
+		printError(err)
 		os.Exit(1)
 	}
 
@@ -175,23 +396,57 @@ func runUp(cfg *config) {
 	fmt.Printf("status: applied %d migrations in %s\n", len(applied), time.Since(start).Truncate(time.Millisecond))
 }
 
+// printError печатает ошибку применения или отката в stderr.
+// Вход: ошибка команды.
+// Выход: печать в stderr; для *lamigrate.MigrationError — ещё фрагмент файла с кареткой,
+// detail, hint и constraint.
+// Назначение: сразу показать строку миграции, на которой упал SQL.
+// printError prints an apply or rollback error to stderr.
+// Input: command error.
+// Output: prints to stderr; for *lamigrate.MigrationError also the file fragment with a caret,
+// detail, hint and constraint.
+// Purpose: show the migration line where SQL failed right away.
+func printError(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+
+	var migrationErr *lamigrate.MigrationError
+	if !errors.As(err, &migrationErr) {
+		return
+	}
+	if migrationErr.Snippet != "" {
+		fmt.Fprintln(os.Stderr, migrationErr.Snippet)
+	}
+	for _, field := range []struct{ label, value string }{
+		{"detail", migrationErr.Detail},
+		{"hint", migrationErr.Hint},
+		{"constraint", migrationErr.Constraint},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", field.label, field.value)
+		}
+	}
+}
+
 // runDown запускает откат стадий.
-// Вход: cfg с флагами/окружением, stages количество стадий.
+// Вход: cfg с флагами/окружением, цель отката (-stages, -to, -to-stage).
 // Выход: завершает процесс при ошибке.
 // Назначение: выполнить команду down.
 // runDown runs stage rollback.
-// Input: cfg with flags/env, stages count.
+// Input: cfg with flags/env, rollback target (-stages, -to, -to-stage).
 // Output: exits process on error.
 // Purpose: execute the down command.
-func runDown(cfg *config, stages int) {
-	driver, config := buildConfig(cfg, true)
+func runDown(cfg *config, target lamigrate.DownTarget) {
+	driver, config := buildConfig(cfg, true, true)
 	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
 	defer cancel()
 
 	start := time.Now()
-	result, err := lamigrate.ApplyDown(ctx, config.cfg, driver, stages)
+	result, err := lamigrate.ApplyDownTo(ctx, config.cfg, driver, target)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+		for _, name := range append(result.Executed, result.Skipped...) {
+			fmt.Printf("committed before failure: %s\n", name)
+		}
+		printError(err)
 		os.Exit(1)
 	}
 
@@ -215,132 +470,647 @@ func runDown(cfg *config, stages int) {
 	)
 }
 
-// runStatus выводит список применённых миграций.
-// Вход: cfg с флагами/окружением.
+// runRedo откатывает и заново применяет миграции цели.
+// Вход: cfg с флагами/окружением, цель отката.
 // Выход: печать результата или завершение при ошибке.
-// Назначение: выполнить команду status.
-// runStatus prints applied migrations.
-// Input: cfg with flags/env.
+// Назначение: выполнить команду redo.
+// runRedo rolls back and re-applies the target migrations.
+// Input: cfg with flags/env, rollback target.
 // Output: prints results or exits on error.
-// Purpose: execute the status command.
-func runStatus(cfg *config) {
-	driver, config := buildConfig(cfg, true)
+// Purpose: execute the redo command.
+func runRedo(cfg *config, target lamigrate.DownTarget) {
+	driver, config := buildConfig(cfg, true, true)
 	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
 	defer cancel()
 
-	applied, err := lamigrate.ListApplied(ctx, config.cfg, driver)
+	start := time.Now()
+	result, err := lamigrate.ApplyRedo(ctx, config.cfg, driver, target)
+	for _, name := range append(result.Down.Executed, result.Down.Skipped...) {
+		fmt.Println(name)
+	}
+	for _, name := range result.Up {
+		fmt.Println(name)
+	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+		printError(err)
 		os.Exit(1)
 	}
 
-	migrations, err := lamigrate.ScanMigrations(config.cfg.MigrationsDir)
+	rolledBack := len(result.Down.Executed) + len(result.Down.Skipped)
+	if rolledBack == 0 {
+		fmt.Println("no changes")
+	}
+	fmt.Printf("status: rolled back %d and re-applied %d migrations in %s\n", rolledBack, len(result.Up), time.Since(start).Truncate(time.Millisecond))
+}
+
+// runRecord меняет записи lamigrate без выполнения SQL миграций.
+// Вход: cfg с флагами/окружением, команда (baseline, mark-applied, unmark),
+// ключи миграций, версия для baseline.
+// Выход: печать затронутых миграций или завершение при ошибке.
+// Назначение: выполнить команды baseline, mark-applied и unmark.
+// runRecord changes lamigrate records without running migration SQL.
+// Input: cfg with flags/env, command (baseline, mark-applied, unmark),
+// migration keys, version for baseline.
+// Output: prints affected migrations or exits on error.
+// Purpose: execute the baseline, mark-applied and unmark commands.
+func runRecord(cfg *config, command string, keys []string, version string) {
+	driver, config := buildConfig(cfg, command != "unmark", true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	var (
+		affected []string
+		err      error
+		verb     string
+	)
+	switch command {
+	case "baseline":
+		affected, err = lamigrate.ApplyBaseline(ctx, config.cfg, driver, version)
+		verb = "baselined"
+	case "mark-applied":
+		affected, err = lamigrate.MarkApplied(ctx, config.cfg, driver, keys...)
+		verb = "marked applied"
+	default:
+		affected, err = lamigrate.Unmark(ctx, config.cfg, driver, keys...)
+		verb = "unmarked"
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 
-	appliedSet := make(map[string]struct{}, len(applied))
-	for _, item := range applied {
-		appliedSet[item.Migration] = struct{}{}
+	if len(affected) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, name := range affected {
+		fmt.Println(name)
 	}
+	fmt.Printf("status: %s %d migrations without running SQL\n", verb, len(affected))
+}
 
-	knownUp := make(map[string]struct{})
-	var pending []string
-	for _, migration := range migrations {
-		if migration.Direction != lamigrate.DirectionUp {
+// runConfigShow печатает итоговую конфигурацию и источник каждого значения.
+// Вход: cfg с флагами/окружением.
+// Выход: таблица настроек в stdout; пароль в DSN скрыт.
+// Назначение: выполнить команду config show.
+// runConfigShow prints the resolved configuration and the source of each value.
+// Input: cfg with flags/env.
+// Output: settings table to stdout; the DSN password is masked.
+// Purpose: execute the config show command.
+func runConfigShow(cfg *config) {
+	_, config := buildConfig(cfg, false, false)
+
+	configFile := cfg.configFile
+	if configFile == "" {
+		configFile = "none"
+	}
+	environment := cfg.envName
+	if environment == "" {
+		environment = "none"
+	}
+	fmt.Printf("config file: %s\n", configFile)
+	fmt.Printf("environment: %s\n\n", environment)
+
+	rows := []struct{ name, value string }{
+		{"dir", config.cfg.MigrationsDir},
+		{"driver", config.cfg.DriverName},
+		{"dsn", maskDSN(config.cfg.DSN)},
+		{"timeout", config.timeout.String()},
+		{"lock-timeout", config.cfg.LockTimeout.String()},
+		{"tx-mode", string(config.cfg.TxMode)},
+		{"log-format", cfg.logFormat},
+		{"table", config.cfg.Table},
+		{"schema", config.cfg.Schema},
+	}
+	for i := range rows {
+		if rows[i].value != "" {
 			continue
 		}
-		knownUp[migration.Key()] = struct{}{}
-		if _, exists := appliedSet[migration.Key()]; exists {
-			continue
+		switch rows[i].name {
+		case "table":
+			rows[i].value = "lamigrate"
+		case "schema":
+			rows[i].value = "from dsn"
 		}
-		pending = append(pending, migration.Key())
 	}
+	width := 0
+	for _, row := range rows {
+		if len(row.value) > width {
+			width = len(row.value)
+		}
+	}
+	for _, row := range rows {
+		source, ok := cfg.sources[row.name]
+		if !ok {
+			source = "default"
+		}
+		fmt.Printf("%-13s %-*s  (%s)\n", row.name, width, row.value, source)
+	}
+}
 
-	var missing []string
-	for _, item := range applied {
-		if _, exists := knownUp[item.Migration]; !exists {
-			missing = append(missing, item.Migration)
+// runFresh удаляет целевую схему и применяет все миграции заново.
+// Вход: cfg с флагами/окружением, dropExtensions — удалить и расширения Postgres.
+// Выход: печать выполненных файлов или завершение при ошибке.
+// Назначение: выполнить команду fresh (защита -allow-destroy проверяется до вызова).
+// runFresh drops the target schema and applies all migrations again.
+// Input: cfg with flags/env, dropExtensions to drop Postgres extensions too.
+// Output: prints executed files or exits on error.
+// Purpose: execute the fresh command (the -allow-destroy guard is checked before the call).
+func runFresh(cfg *config, dropExtensions bool) {
+	driver, config := buildConfig(cfg, true, true)
+	config.cfg.DropExtensions = dropExtensions
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	start := time.Now()
+	applied, err := lamigrate.ApplyFresh(ctx, config.cfg, driver)
+	if err != nil {
+		for _, name := range applied {
+			fmt.Printf("committed before failure: %s\n", name)
 		}
+		printError(err)
+		os.Exit(1)
 	}
 
-	const (
-		colorGreen = "\033[32m"
-		colorRed   = "\033[31m"
-		colorGray  = "\033[90m"
-		colorReset = "\033[0m"
+	for _, name := range applied {
+		fmt.Println(name)
+	}
+	fmt.Printf("status: dropped schema and applied %d migrations in %s\n", len(applied), time.Since(start).Truncate(time.Millisecond))
+}
+
+// runPlan печатает план up/down без выполнения миграций.
+// Вход: cfg с флагами/окружением, направление, upTo — версия для up, цель отката для down, showSQL — печатать SQL.
+// Выход: печать плана или завершение при ошибке.
+// Назначение: выполнить команду plan и флаг -dry-run.
+// runPlan prints an up/down plan without running migrations.
+// Input: cfg with flags/env, direction, upTo version for up, rollback target for down, showSQL to print SQL.
+// Output: prints the plan or exits on error.
+// Purpose: execute the plan command and the -dry-run flag.
+func runPlan(cfg *config, direction lamigrate.Direction, upTo string, target lamigrate.DownTarget, showSQL bool) {
+	driver, config := buildConfig(cfg, true, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	var (
+		plan lamigrate.Plan
+		err  error
 	)
+	switch direction {
+	case lamigrate.DirectionUp:
+		plan, err = lamigrate.PlanUpTo(ctx, config.cfg, driver, upTo)
+	case lamigrate.DirectionDown:
+		plan, err = lamigrate.PlanDownTo(ctx, config.cfg, driver, target)
+	default:
+		err = fmt.Errorf("unknown plan direction: %s (want up or down)", direction)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 
-	printTitleTable := func(title string, color string) {
-		width := len(title)
-		fmt.Printf("%s+-%s-+%s\n", color, strings.Repeat("-", width), colorReset)
-		fmt.Printf("%s| %s |%s\n", color, title, colorReset)
-		fmt.Printf("%s+-%s-+%s\n", color, strings.Repeat("-", width), colorReset)
+	if len(plan.Items) == 0 {
+		fmt.Println("no changes")
+		return
 	}
 
-	printAppliedTable := func(rows []lamigrate.AppliedMigration) {
-		headers := []string{"migration", "stage", "executed_at"}
-		colWidths := []int{len(headers[0]), len(headers[1]), len(headers[2])}
-		for _, item := range rows {
-			if len(item.Migration) > colWidths[0] {
-				colWidths[0] = len(item.Migration)
-			}
-			stageStr := strconv.Itoa(item.Stage)
-			if len(stageStr) > colWidths[1] {
-				colWidths[1] = len(stageStr)
-			}
-			executedAt := "unknown"
-			if !item.ExecutedAt.IsZero() {
-				executedAt = item.ExecutedAt.Format(time.RFC3339)
+	printPlanTable(plan)
+
+	if showSQL {
+		for _, item := range plan.Items {
+			fmt.Println()
+			fmt.Printf("-- %s\n", item.Migration.Filename)
+			if item.Migration.Empty() {
+				fmt.Println("-- (empty)")
+				continue
 			}
-			if len(executedAt) > colWidths[2] {
-				colWidths[2] = len(executedAt)
+			if item.Migration.Go {
+				fmt.Println("-- (go function)")
+				continue
 			}
+			fmt.Println(item.Migration.SQL)
 		}
+	}
 
-		border := fmt.Sprintf("+-%s-+-%s-+-%s-+",
-			strings.Repeat("-", colWidths[0]),
-			strings.Repeat("-", colWidths[1]),
-			strings.Repeat("-", colWidths[2]),
-		)
+	verb := "apply"
+	if plan.Direction == lamigrate.DirectionDown {
+		verb = "roll back"
+	}
+	fmt.Printf("plan: would %s %d migrations (tx-mode %s)\n", verb, len(plan.Items), plan.TxMode)
+}
 
-		fmt.Printf("%s%s%s\n", colorGreen, border, colorReset)
-		fmt.Printf("%s| %-*s | %-*s | %-*s |%s\n",
-			colorGreen,
-			colWidths[0], headers[0],
-			colWidths[1], headers[1],
-			colWidths[2], headers[2],
-			colorReset,
-		)
-		fmt.Printf("%s%s%s\n", colorGreen, border, colorReset)
+// runScript печатает офлайн SQL-скрипт для up/down.
+// Вход: cfg с флагами/окружением, направление, stages для down,
+// historyFile — экспорт истории (пусто — читать из БД), output — файл вывода.
+// Выход: скрипт в stdout/файл или завершение при ошибке.
+// Назначение: выполнить команду script для деплоя руками DBA.
+// runScript prints an offline SQL script for up/down.
+// Input: cfg with flags/env, direction, stages for down,
+// historyFile exported history (empty reads the database), output file.
+// Output: script to stdout/file or exits on error.
+// Purpose: execute the script command for DBA-run deployments.
+func runScript(cfg *config, direction lamigrate.Direction, stages int, historyFile, output string) {
+	driver, config := buildConfig(cfg, true, historyFile == "")
+	warnTxMode(driver, config.cfg.TxMode)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
 
-		if len(rows) == 0 {
-			fmt.Printf("%s| %-*s | %-*s | %-*s |%s\n",
-				colorGreen,
-				colWidths[0], "none",
-				colWidths[1], "-",
-				colWidths[2], "-",
-				colorReset,
-			)
-			fmt.Printf("%s%s%s\n", colorGreen, border, colorReset)
-			return
+	migrations, err := lamigrate.ScanMigrations(config.cfg.MigrationsDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Без БД версия служебных таблиц неизвестна: пустая история считается новой БД,
+	// непустая — экспортом из БД с актуальной схемой (script history экспортирует только такие).
+	// Without a database the bookkeeping version is unknown: an empty history means a new
+	// database, a non-empty one an export from an up-to-date schema (script history exports only those).
+	var applied []lamigrate.AppliedMigration
+	metaVersion := 0
+	if historyFile != "" {
+		applied, err = lamigrate.LoadHistoryFile(historyFile)
+		if len(applied) > 0 {
+			metaVersion = len(driver.MetaUpgrades())
+		}
+	} else {
+		applied, err = lamigrate.ReadHistory(ctx, config.cfg, driver)
+		if err == nil {
+			var status lamigrate.MetaStatus
+			status, err = lamigrate.ReadMetaStatus(ctx, config.cfg, driver)
+			metaVersion = status.Version
 		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	var script string
+	switch direction {
+	case lamigrate.DirectionUp:
+		script, err = lamigrate.ScriptUp(migrations, applied, driver, config.cfg.TxMode, metaVersion)
+	case lamigrate.DirectionDown:
+		script, err = lamigrate.ScriptDown(migrations, applied, driver, stages, config.cfg.TxMode)
+	default:
+		err = fmt.Errorf("unknown script direction: %s (want up, down or history)", direction)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if script == "" {
+		fmt.Fprintln(os.Stderr, "no changes")
+		return
+	}
+	writeOutput(output, script)
+}
+
+// runScriptHistory экспортирует историю lamigrate в JSON.
+// Вход: cfg с флагами/окружением, output — файл вывода.
+// Выход: JSON в stdout/файл или завершение при ошибке.
+// Назначение: подготовить файл для "script -history". Экспорт из базы со старыми
+// служебными таблицами отклоняется: "script -history" считает непустой экспорт
+// сделанным с актуальной схемой и не добавил бы шаги её обновления.
+// runScriptHistory exports the lamigrate history as JSON.
+// Input: cfg with flags/env, output file.
+// Output: JSON to stdout/file or exits on error.
+// Purpose: prepare a file for "script -history". An export from a database with older
+// bookkeeping tables is refused: "script -history" treats a non-empty export as taken
+// from an up-to-date schema and would not add the steps upgrading it.
+func runScriptHistory(cfg *config, output string) {
+	driver, config := buildConfig(cfg, false, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	applied, err := lamigrate.ReadHistory(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(applied) > 0 {
+		status, err := lamigrate.ReadMetaStatus(ctx, config.cfg, driver)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if len(status.Pending) > 0 {
+			fmt.Fprintf(os.Stderr, "script history exports only up-to-date metadata: database version %d, this lamigrate needs %d; run `lamigrate meta upgrade` first\n",
+				status.Version, status.Supported)
+			os.Exit(1)
+		}
+	}
+	if applied == nil {
+		applied = []lamigrate.AppliedMigration{}
+	}
+
+	content, err := json.MarshalIndent(applied, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	writeOutput(output, string(content)+"\n")
+}
+
+// writeOutput пишет текст в файл или stdout.
+// Вход: путь (пусто — stdout) и текст.
+// Выход: завершает процесс при ошибке записи.
+// Назначение: общий вывод для команд, генерирующих файлы.
+// writeOutput writes text to a file or stdout.
+// Input: path (empty means stdout) and text.
+// Output: exits the process on write failure.
+// Purpose: shared output for commands producing files.
+func writeOutput(path, text string) {
+	if path == "" {
+		fmt.Print(text)
+		return
+	}
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// splitDirection отделяет необязательное направление up/down перед флагами.
+// Вход: аргументы после имени команды.
+// Выход: направление (или пустое) и оставшиеся аргументы.
+// Назначение: поддержать "lamigrate plan down -stages 3".
+// splitDirection separates an optional up/down direction before flags.
+// Input: arguments after the command name.
+// Output: direction (or empty) and remaining arguments.
+// Purpose: support "lamigrate plan down -stages 3".
+func splitDirection(args []string) (lamigrate.Direction, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return lamigrate.Direction(args[0]), args[1:]
+	}
+	return "", args
+}
+
+// parsePositional разбирает флаги вперемешку с позиционными аргументами.
+// Вход: FlagSet команды и аргументы после имени команды.
+// Выход: позиционные аргументы в исходном порядке.
+// Назначение: поддержать "lamigrate unmark 20240101120000 -dsn ...".
+// parsePositional parses flags interleaved with positional arguments.
+// Input: command FlagSet and arguments after the command name.
+// Output: positional arguments in their original order.
+// Purpose: support "lamigrate unmark 20240101120000 -dsn ...".
+func parsePositional(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
+// runStatus выводит состояние миграций в выбранном формате.
+// Вход: cfg с флагами/окружением, format (table, json, yaml), check — коды выхода для CI.
+// Выход: печать результата; exitDrift при изменённых файлах, с check также
+// exitMissing/exitPending; завершение при ошибке.
+// Назначение: выполнить команду status.
+// runStatus prints migration state in the selected format.
+// Input: cfg with flags/env, format (table, json, yaml), check enables CI exit codes.
+// Output: prints results; exitDrift on edited files, with check also
+// exitMissing/exitPending; exits on error.
+// Purpose: execute the status command.
+func runStatus(cfg *config, format string, check bool) {
+	driver, config := buildConfig(cfg, true, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	report, err := lamigrate.ReadStatus(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	switch format {
+	case "", "table":
+		printStatusTables(report)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(newStatusOutput(report)); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(newStatusOutput(report)); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		_ = encoder.Close()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown status format: %s (expected table, json or yaml)\n", format)
+		os.Exit(2)
+	}
+
+	if code := statusExitCode(report, check); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// statusExitCode выбирает код завершения status.
+// Вход: отчёт и флаг -check.
+// Выход: exitDrift при дрейфе; с check — exitMissing, затем exitPending; иначе 0.
+// Назначение: дать CI различать причины провала.
+// statusExitCode picks the status exit code.
+// Input: report and the -check flag.
+// Output: exitDrift on drift; with check exitMissing, then exitPending; otherwise 0.
+// Purpose: let CI tell failure reasons apart.
+func statusExitCode(report lamigrate.StatusReport, check bool) int {
+	if len(report.Drift) > 0 {
+		return exitDrift
+	}
+	if !check {
+		return 0
+	}
+	if len(report.Missing) > 0 {
+		return exitMissing
+	}
+	if len(report.Pending) > 0 {
+		return exitPending
+	}
+	return 0
+}
+
+// statusOutput — машиночитаемое представление status.
+// Назначение: стабильный формат для -format json|yaml.
+// statusOutput is the machine-readable status representation.
+// Purpose: a stable format for -format json|yaml.
+type statusOutput struct {
+	Applied []statusApplied `json:"applied" yaml:"applied"`
+	Pending []statusPending `json:"pending" yaml:"pending"`
+	Missing []statusApplied `json:"missing" yaml:"missing"`
+	Drifted []statusDrift   `json:"drifted" yaml:"drifted"`
+}
+
+// statusApplied — применённая (или пропавшая) миграция в выводе status.
+// statusApplied is an applied (or missing) migration in status output.
+type statusApplied struct {
+	Migration  string `json:"migration" yaml:"migration"`
+	Stage      int    `json:"stage" yaml:"stage"`
+	ExecutedAt string `json:"executed_at,omitempty" yaml:"executed_at,omitempty"`
+	Checksum   string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	Origin     string `json:"origin" yaml:"origin"`
+	DurationMS int64  `json:"duration_ms,omitempty" yaml:"duration_ms,omitempty"`
+	DBUser     string `json:"db_user,omitempty" yaml:"db_user,omitempty"`
+	Hostname   string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Version    string `json:"lamigrate_version,omitempty" yaml:"lamigrate_version,omitempty"`
+}
+
+// statusPending — неприменённая миграция в выводе status.
+// statusPending is a pending migration in status output.
+type statusPending struct {
+	Migration string `json:"migration" yaml:"migration"`
+	File      string `json:"file" yaml:"file"`
+}
+
+// statusDrift — изменённая после применения миграция в выводе status.
+// statusDrift is a migration edited after it was applied, in status output.
+type statusDrift struct {
+	Migration string `json:"migration" yaml:"migration"`
+	File      string `json:"file" yaml:"file"`
+	Applied   string `json:"applied_checksum" yaml:"applied_checksum"`
+	Current   string `json:"current_checksum" yaml:"current_checksum"`
+}
+
+// newStatusOutput переводит отчёт библиотеки в формат вывода.
+// Вход: отчёт status.
+// Выход: statusOutput с пустыми списками вместо nil.
+// Назначение: одинаковая форма JSON/YAML при любом состоянии.
+// newStatusOutput converts the library report into the output format.
+// Input: status report.
+// Output: statusOutput with empty lists instead of nil.
+// Purpose: the same JSON/YAML shape in any state.
+func newStatusOutput(report lamigrate.StatusReport) statusOutput {
+	out := statusOutput{
+		Applied: make([]statusApplied, 0, len(report.Applied)),
+		Pending: make([]statusPending, 0, len(report.Pending)),
+		Missing: make([]statusApplied, 0, len(report.Missing)),
+		Drifted: make([]statusDrift, 0, len(report.Drift)),
+	}
+	toApplied := func(item lamigrate.AppliedMigration) statusApplied {
+		row := statusApplied{
+			Migration:  item.Migration,
+			Stage:      item.Stage,
+			Checksum:   item.Checksum,
+			Origin:     originLabel(item.Origin),
+			DurationMS: item.Duration.Milliseconds(),
+			DBUser:     item.DBUser,
+			Hostname:   item.Hostname,
+			Version:    item.Version,
+		}
+		if !item.ExecutedAt.IsZero() {
+			row.ExecutedAt = item.ExecutedAt.Format(time.RFC3339)
+		}
+		return row
+	}
+	for _, item := range report.Applied {
+		out.Applied = append(out.Applied, toApplied(item))
+	}
+	for _, migration := range report.Pending {
+		out.Pending = append(out.Pending, statusPending{Migration: migration.Key(), File: migration.Filename})
+	}
+	for _, item := range report.Missing {
+		out.Missing = append(out.Missing, toApplied(item))
+	}
+	for _, item := range report.Drift {
+		out.Drifted = append(out.Drifted, statusDrift{
+			Migration: item.Migration,
+			File:      item.Filename,
+			Applied:   item.Applied,
+			Current:   item.Current,
+		})
+	}
+	return out
+}
+
+// originLabel возвращает происхождение записи для вывода (пустое — apply).
+// originLabel returns the record origin for output (empty means apply).
+func originLabel(origin lamigrate.Origin) string {
+	if origin == "" {
+		return string(lamigrate.OriginApply)
+	}
+	return string(origin)
+}
+
+// durationLabel возвращает длительность применения для вывода.
+// Вход: применённая миграция.
+// Выход: длительность или "-", если SQL не выполнялся или запись сделана старой версией.
+// Назначение: колонка duration в status.
+// durationLabel returns the apply duration for output.
+// Input: applied migration.
+// Output: duration or "-" when no SQL ran or the record was made by an older version.
+// Purpose: the duration column in status.
+func durationLabel(item lamigrate.AppliedMigration) string {
+	if item.Version == "" || originLabel(item.Origin) != string(lamigrate.OriginApply) {
+		return "-"
+	}
+	if item.Duration < time.Millisecond {
+		return "<1ms"
+	}
+	return item.Duration.String()
+}
+
+// valueOrDash возвращает значение или "-" для пустой строки.
+// valueOrDash returns the value or "-" for an empty string.
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// printStatusTables печатает status цветными таблицами.
+// Вход: отчёт status.
+// Выход: таблицы в stdout.
+// Назначение: формат status по умолчанию для человека.
+// printStatusTables prints status as coloured tables.
+// Input: status report.
+// Output: tables to stdout.
+// Purpose: the default human-readable status format.
+func printStatusTables(report lamigrate.StatusReport) {
+	applied := report.Applied
+	drifted := report.Drift
+
+	pending := make([]string, 0, len(report.Pending))
+	for _, migration := range report.Pending {
+		pending = append(pending, migration.Key())
+	}
+
+	missing := make([]string, 0, len(report.Missing))
+	for _, item := range report.Missing {
+		missing = append(missing, item.Migration)
+	}
+
+	printAppliedTable := func(rows []lamigrate.AppliedMigration) {
+		headers := []string{"migration", "stage", "executed_at", "origin", "duration", "db_user", "host", "version"}
+		cells := make([][]string, 0, len(rows))
 		for _, item := range rows {
 			executedAt := "unknown"
 			if !item.ExecutedAt.IsZero() {
 				executedAt = item.ExecutedAt.Format(time.RFC3339)
 			}
-			fmt.Printf("%s| %-*s | %-*d | %-*s |%s\n",
-				colorGreen,
-				colWidths[0], item.Migration,
-				colWidths[1], item.Stage,
-				colWidths[2], executedAt,
-				colorReset,
-			)
+			cells = append(cells, []string{
+				item.Migration,
+				strconv.Itoa(item.Stage),
+				executedAt,
+				originLabel(item.Origin),
+				durationLabel(item),
+				valueOrDash(item.DBUser),
+				valueOrDash(item.Hostname),
+				valueOrDash(item.Version),
+			})
+		}
+		if len(cells) == 0 {
+			cells = append(cells, []string{"none", "-", "-", "-", "-", "-", "-", "-"})
 		}
-		fmt.Printf("%s%s%s\n", colorGreen, border, colorReset)
+		printGrid(colorGreen, headers, cells, nil)
 	}
 
 	printPendingTable := func(rows []string, color string) {
@@ -369,7 +1139,7 @@ func runStatus(cfg *config) {
 		fmt.Printf("%s%s%s\n", color, border, colorReset)
 	}
 
-	if len(applied) == 0 && len(pending) == 0 && len(missing) == 0 {
+	if len(applied) == 0 && len(pending) == 0 && len(missing) == 0 && len(drifted) == 0 {
 		fmt.Println("no migrations applied or pending")
 		return
 	}
@@ -390,6 +1160,103 @@ func runStatus(cfg *config) {
 		printTitleTable("Missing Migrations", colorGray)
 		printPendingTable(missing, colorGray)
 	}
+
+	if len(drifted) > 0 {
+		fmt.Println()
+		printTitleTable("Drifted Migrations", colorYellow)
+		printDriftTable(drifted)
+	}
+}
+
+// runVerify сравнивает применённые миграции с файлами и печатает дрейф.
+// Вход: cfg с флагами/окружением.
+// Выход: печать результата; код exitDrift при расхождениях.
+// Назначение: выполнить команду verify (удобно для CI).
+// runVerify compares applied migrations with files and prints drift.
+// Input: cfg with flags/env.
+// Output: prints results; exitDrift code on mismatches.
+// Purpose: execute the verify command (CI friendly).
+func runVerify(cfg *config) {
+	driver, config := buildConfig(cfg, true, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	drifted, err := lamigrate.Verify(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if len(drifted) == 0 {
+		fmt.Println("ok: applied migrations match their files")
+		return
+	}
+
+	printTitleTable("Drifted Migrations", colorYellow)
+	printDriftTable(drifted)
+	os.Exit(exitDrift)
+}
+
+// runRepair перезаписывает сохранённые checksum после подтверждения.
+// Вход: cfg с флагами/окружением, yes — пропустить интерактивное подтверждение.
+// Выход: печать результата или завершение при ошибке/отказе.
+// Назначение: выполнить команду repair; если расхождений и пустых checksum нет, ничего не спрашивает.
+// runRepair rewrites stored checksums after a confirmation.
+// Input: cfg with flags/env, yes to skip the interactive confirmation.
+// Output: prints results or exits on error/refusal.
+// Purpose: execute the repair command; asks nothing when there is no drift and no empty checksum.
+func runRepair(cfg *config, yes bool) {
+	driver, config := buildConfig(cfg, true, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	drifted, err := lamigrate.Verify(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	applied, err := lamigrate.ListApplied(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	// Verify пропускает записи без checksum, а repair их заполняет.
+	// Verify skips records without a checksum, while repair fills them in.
+	unchecked := 0
+	for _, item := range applied {
+		if item.Checksum == "" {
+			unchecked++
+		}
+	}
+
+	if len(drifted) == 0 && unchecked == 0 {
+		fmt.Println("ok: applied migrations match their files")
+		return
+	}
+	if len(drifted) > 0 {
+		printTitleTable("Drifted Migrations", colorYellow)
+		printDriftTable(drifted)
+	}
+	if unchecked > 0 {
+		fmt.Printf("%d applied migrations have no stored checksum\n", unchecked)
+	}
+
+	if !yes && !confirm("rewrite stored checksums with the current file contents?") {
+		fmt.Fprintln(os.Stderr, "repair cancelled")
+		os.Exit(1)
+	}
+
+	repaired, err := lamigrate.Repair(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	for _, item := range repaired {
+		fmt.Println(item.Filename)
+	}
+	fmt.Printf("status: repaired %d checksums\n", len(repaired))
 }
 
 // runCreate создаёт пару файлов миграции (up/down) с текущей меткой времени.
@@ -401,7 +1268,7 @@ func runStatus(cfg *config) {
 // Output: prints result or exits on error.
 // Purpose: execute the create command.
 func runCreate(cfg *config, name string) {
-	_, config := buildConfig(cfg, true)
+	_, config := buildConfig(cfg, true, true)
 	if strings.TrimSpace(name) == "" {
 		fmt.Fprintln(os.Stderr, "migration name is required")
 		os.Exit(1)
@@ -421,40 +1288,69 @@ type resolvedConfig struct {
 	timeout time.Duration
 }
 
-// buildConfig собирает конфигурацию из env и флагов.
-// Вход: cfg из флагов, requireDir — нужна ли директория миграций.
-// Выход: драйвер и итоговый config.
-// Назначение: применить приоритет env и собрать DSN.
-// buildConfig builds config from env and flags.
-// Input: cfg from flags, requireDir whether migrations dir is required.
-// Output: driver and resolved config.
-// Purpose: apply env priority and build DSN.
-func buildConfig(cfg *config, requireDir bool) (lamigrate.Driver, resolvedConfig) {
-	driverName := pickEnv("LAMIGRATE_DRIVER", cfg.driverName)
+// buildConfig собирает конфигурацию из env, флагов и lamigrate.yaml.
+// Вход: cfg из флагов, requireDir — нужна ли директория миграций, requireDSN — нужен ли DSN.
+// Выход: драйвер и итоговый config; в cfg.sources — откуда взято каждое значение.
+// Назначение: применить приоритет (env > флаги > окружение файла > файл > умолчания) и собрать DSN.
+// buildConfig builds config from env, flags and lamigrate.yaml.
+// Input: cfg from flags, requireDir whether migrations dir is required, requireDSN whether DSN is required.
+// Output: driver and resolved config; cfg.sources records where each value came from.
+// Purpose: apply precedence (env > flags > file environment > file > defaults) and build DSN.
+func buildConfig(cfg *config, requireDir, requireDSN bool) (lamigrate.Driver, resolvedConfig) {
+	if err := applyConfigFile(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	migrationsDir := cfg.pickEnv("dir", "LAMIGRATE_MIGRATIONS_DIR", cfg.migrationsDir)
+	dsn := cfg.pickEnv("dsn", "LAMIGRATE_DSN", cfg.dsn)
+	table := cfg.pickEnv("table", "LAMIGRATE_TABLE", cfg.table)
+	schema := cfg.pickEnv("schema", "LAMIGRATE_SCHEMA", cfg.schema)
+
+	driverName := cfg.pickEnv("driver", "LAMIGRATE_DRIVER", cfg.driverName)
 	if driverName == "" {
-		driverName = "postgres"
+		if detected, ok := lamigrate.DetectDriver(dsn); ok {
+			driverName = detected
+			cfg.sources["driver"] = "detected from dsn"
+		} else {
+			driverName = "postgres"
+		}
 	}
 
-	migrationsDir := pickEnv("LAMIGRATE_MIGRATIONS_DIR", cfg.migrationsDir)
-	dsn := pickEnv("LAMIGRATE_DSN", cfg.dsn)
-	if dsn == "" {
+	if dsn == "" && driverName == "postgres" {
 		dsn = buildPostgresDSNFromEnv()
+		if dsn != "" {
+			cfg.sources["dsn"] = "env POSTGRES_*"
+		}
 	}
 
-	if dsn == "" {
+	if requireDSN && dsn == "" {
 		log.Fatal("dsn is required")
 	}
 	if requireDir && migrationsDir == "" {
 		log.Fatal("migrations dir is required")
 	}
 
-	drivers := map[string]lamigrate.Driver{
-		"postgres": postgres.New(),
+	txMode, err := lamigrate.ParseTxMode(cfg.txMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	driver, err := lamigrate.Lookup(driverName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Скрипты (script up/down) получают драйвер напрямую, поэтому он настраивается здесь.
+	// Scripts (script up/down) receive the driver directly, so it is configured here.
+	if table != "" || schema != "" {
+		driver, err = driver.WithHistoryTable(schema, table)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	driver, ok := drivers[driverName]
-	if !ok {
-		log.Fatalf("unsupported driver: %s", driverName)
+	logger, err := newLogger(cfg.logFormat)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	return driver, resolvedConfig{
@@ -462,11 +1358,53 @@ func buildConfig(cfg *config, requireDir bool) (lamigrate.Driver, resolvedConfig
 			MigrationsDir: migrationsDir,
 			DriverName:    driver.Name(),
 			DSN:           dsn,
+			LockTimeout:   cfg.lockTimeout,
+			TxMode:        txMode,
+			Logger:        logger,
+			Table:         table,
+			Schema:        schema,
 		},
 		timeout: cfg.timeout,
 	}
 }
 
+// newLogger создаёт slog-логгер событий миграций в stderr.
+// Вход: формат text или json.
+// Выход: *slog.Logger или error для неизвестного формата.
+// Назначение: флаг -log-format.
+// newLogger creates a slog logger for migration events on stderr.
+// Input: text or json format.
+// Output: *slog.Logger or error for an unknown format.
+// Purpose: the -log-format flag.
+func newLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s (expected text or json)", format)
+	}
+}
+
+// warnTxMode предупреждает, что режим all недоступен для драйвера.
+// Вход: драйвер и выбранный режим транзакций.
+// Выход: предупреждение в stderr.
+// Назначение: не скрывать, что stage будет применён не атомарно. Команды через Migrator
+// получают это предупреждение из его логгера, здесь оно нужно только script.
+// warnTxMode warns that all mode is unavailable for the driver.
+// Input: driver and selected transaction mode.
+// Output: warning to stderr.
+// Purpose: do not hide that the stage will not be applied atomically. Commands going
+// through a Migrator get this warning from its logger; only script needs it here.
+func warnTxMode(driver lamigrate.Driver, txMode lamigrate.TxMode) {
+	if txMode != lamigrate.TxModeAll || driver.TransactionalDDL() {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%swarning: %s commits DDL implicitly, using tx-mode %s; a failed stage is not rolled back%s\n",
+		colorYellow, driver.Name(), lamigrate.TxModePerMigration, colorReset)
+}
+
 // pickEnv возвращает env значение или fallback.
 // Вход: имя переменной и fallback.
 // Выход: строка.
@@ -482,6 +1420,22 @@ func pickEnv(name, fallback string) string {
 	return fallback
 }
 
+// pickEnv возвращает env значение или fallback и запоминает источник.
+// Вход: имя настройки для config show, имя переменной и fallback.
+// Выход: строка.
+// Назначение: pickEnv с учётом источника значения.
+// pickEnv returns env value or fallback and records the source.
+// Input: setting name for config show, variable name and fallback.
+// Output: string.
+// Purpose: pickEnv that tracks the value source.
+func (c *config) pickEnv(setting, name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		c.sources[setting] = "env " + name
+		return value
+	}
+	return fallback
+}
+
 // buildPostgresDSNFromEnv строит DSN из POSTGRES_*.
 // Вход: env переменные POSTGRES_*.
 // Выход: строка DSN или пустая строка.
@@ -567,6 +1521,239 @@ func createEmptyFile(path string) error {
 	return file.Close()
 }
 
+// Цвета ANSI для таблиц статуса.
+// ANSI colors for status tables.
+const (
+	colorGreen  = "\033[32m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorGray   = "\033[90m"
+	colorReset  = "\033[0m"
+)
+
+// exitDrift — код завершения, если применённые миграции были изменены.
+// exitDrift is the exit code when applied migrations were edited.
+const exitDrift = 3
+
+// exitPending — код status -check, если есть неприменённые миграции.
+// exitPending is the status -check exit code when migrations are pending.
+const exitPending = 4
+
+// exitMissing — код status -check, если применённые миграции пропали из файлов.
+// exitMissing is the status -check exit code when applied migrations have no files.
+const exitMissing = 5
+
+// printTitleTable печатает заголовок таблицы в рамке.
+// Вход: заголовок и цвет.
+// Выход: печать в stdout.
+// Назначение: единый вид заголовков status/verify.
+// printTitleTable prints a boxed table title.
+// Input: title and color.
+// Output: prints to stdout.
+// Purpose: uniform titles for status/verify.
+func printTitleTable(title string, color string) {
+	width := len(title)
+	fmt.Printf("%s+-%s-+%s\n", color, strings.Repeat("-", width), colorReset)
+	fmt.Printf("%s| %s |%s\n", color, title, colorReset)
+	fmt.Printf("%s+-%s-+%s\n", color, strings.Repeat("-", width), colorReset)
+}
+
+// printGrid печатает таблицу с рамкой по ширине самых длинных ячеек.
+// Вход: цвет рамки и заголовка, заголовки, строки и цвет каждой строки (nil — цвет рамки).
+// Выход: печать в stdout.
+// Назначение: общий вывод широких таблиц status и history.
+// printGrid prints a boxed table sized to the longest cells.
+// Input: border and header color, headers, rows and the color of each row (nil means the border color).
+// Output: prints to stdout.
+// Purpose: shared rendering of the wide status and history tables.
+func printGrid(color string, headers []string, rows [][]string, rowColors []string) {
+	widths := make([]int, len(headers))
+	for i, header := range headers {
+		widths[i] = len(header)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	line := func(cells []string) string {
+		parts := make([]string, len(cells))
+		for i, cell := range cells {
+			parts[i] = fmt.Sprintf("%-*s", widths[i], cell)
+		}
+		return "| " + strings.Join(parts, " | ") + " |"
+	}
+	dashes := make([]string, len(widths))
+	for i, width := range widths {
+		dashes[i] = strings.Repeat("-", width)
+	}
+	border := "+-" + strings.Join(dashes, "-+-") + "-+"
+
+	fmt.Printf("%s%s%s\n", color, border, colorReset)
+	fmt.Printf("%s%s%s\n", color, line(headers), colorReset)
+	fmt.Printf("%s%s%s\n", color, border, colorReset)
+	for i, row := range rows {
+		rowColor := color
+		if rowColors != nil {
+			rowColor = rowColors[i]
+		}
+		fmt.Printf("%s%s%s\n", rowColor, line(row), colorReset)
+	}
+	fmt.Printf("%s%s%s\n", color, border, colorReset)
+}
+
+// printDriftTable печатает таблицу миграций с расхождением checksum.
+// Вход: список расхождений.
+// Выход: печать в stdout.
+// Назначение: показать, какие применённые файлы были изменены.
+// printDriftTable prints a table of migrations with checksum drift.
+// Input: list of mismatches.
+// Output: prints to stdout.
+// Purpose: show which applied files were edited.
+func printDriftTable(rows []lamigrate.ChecksumMismatch) {
+	headers := []string{"migration", "applied_checksum", "file_checksum"}
+	colWidths := []int{len(headers[0]), len(headers[1]), len(headers[2])}
+	for _, item := range rows {
+		if len(item.Migration) > colWidths[0] {
+			colWidths[0] = len(item.Migration)
+		}
+		if len(shortChecksum(item.Applied)) > colWidths[1] {
+			colWidths[1] = len(shortChecksum(item.Applied))
+		}
+		if len(shortChecksum(item.Current)) > colWidths[2] {
+			colWidths[2] = len(shortChecksum(item.Current))
+		}
+	}
+
+	border := fmt.Sprintf("+-%s-+-%s-+-%s-+",
+		strings.Repeat("-", colWidths[0]),
+		strings.Repeat("-", colWidths[1]),
+		strings.Repeat("-", colWidths[2]),
+	)
+
+	fmt.Printf("%s%s%s\n", colorYellow, border, colorReset)
+	fmt.Printf("%s| %-*s | %-*s | %-*s |%s\n",
+		colorYellow,
+		colWidths[0], headers[0],
+		colWidths[1], headers[1],
+		colWidths[2], headers[2],
+		colorReset,
+	)
+	fmt.Printf("%s%s%s\n", colorYellow, border, colorReset)
+	for _, item := range rows {
+		fmt.Printf("%s| %-*s | %-*s | %-*s |%s\n",
+			colorYellow,
+			colWidths[0], item.Migration,
+			colWidths[1], shortChecksum(item.Applied),
+			colWidths[2], shortChecksum(item.Current),
+			colorReset,
+		)
+	}
+	fmt.Printf("%s%s%s\n", colorYellow, border, colorReset)
+}
+
+// printPlanTable печатает упорядоченный план в виде таблицы.
+// Вход: план.
+// Выход: печать в stdout.
+// Назначение: показать порядок файлов, stage и транзакции.
+// printPlanTable prints an ordered plan as a table.
+// Input: plan.
+// Output: prints to stdout.
+// Purpose: show file order, stage and transactions.
+func printPlanTable(plan lamigrate.Plan) {
+	headers := []string{"#", "stage", "file", "tx"}
+	rows := make([][]string, 0, len(plan.Items))
+	for i, item := range plan.Items {
+		tx := "none"
+		if item.TxGroup > 0 {
+			tx = "#" + strconv.Itoa(item.TxGroup)
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(i + 1),
+			strconv.Itoa(item.Stage),
+			item.Migration.Filename,
+			tx,
+		})
+	}
+
+	colWidths := make([]int, len(headers))
+	for i, header := range headers {
+		colWidths[i] = len(header)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > colWidths[i] {
+				colWidths[i] = len(cell)
+			}
+		}
+	}
+
+	parts := make([]string, len(colWidths))
+	for i, width := range colWidths {
+		parts[i] = strings.Repeat("-", width)
+	}
+	border := "+-" + strings.Join(parts, "-+-") + "-+"
+
+	printRow := func(cells []string) {
+		padded := make([]string, len(cells))
+		for i, cell := range cells {
+			padded[i] = fmt.Sprintf("%-*s", colWidths[i], cell)
+		}
+		fmt.Printf("| %s |\n", strings.Join(padded, " | "))
+	}
+
+	title := "Plan: up"
+	if plan.Direction == lamigrate.DirectionDown {
+		title = "Plan: down"
+	}
+	printTitleTable(title, colorReset)
+	fmt.Println(border)
+	printRow(headers)
+	fmt.Println(border)
+	for _, row := range rows {
+		printRow(row)
+	}
+	fmt.Println(border)
+}
+
+// shortChecksum сокращает checksum для таблиц.
+// Вход: полный checksum.
+// Выход: первые 12 символов или "-" для пустого значения.
+// Назначение: уместить checksum в ширину терминала.
+// shortChecksum shortens a checksum for tables.
+// Input: full checksum.
+// Output: first 12 characters or "-" for an empty value.
+// Purpose: fit checksums into the terminal width.
+func shortChecksum(checksum string) string {
+	if checksum == "" {
+		return "-"
+	}
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	return checksum
+}
+
+// confirm спрашивает подтверждение в stdin.
+// Вход: текст вопроса.
+// Выход: true, если пользователь ввёл "yes".
+// Назначение: защитить опасные команды от случайного запуска.
+// confirm asks for a confirmation on stdin.
+// Input: question text.
+// Output: true if the user typed "yes".
+// Purpose: protect dangerous commands from accidental runs.
+func confirm(question string) bool {
+	fmt.Printf("%s type \"yes\" to continue: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
+
 // printHelp печатает справку по CLI.
 // Вход: нет.
 // Выход: печатает help в stdout.
@@ -583,37 +1770,105 @@ func printHelp() {
 
 Команды:
   up        применить все новые up-миграции в одной транзакции
-  down      откатить последние стадии (по умолчанию 1)
-  status    показать применённые, неприменённые и пропавшие миграции
+  down      откатить последние стадии (по умолчанию 1) или одну миграцию (-migration)
+  redo      откатить и заново применить последнюю стадию (-stages N) или одну миграцию (-migration)
+  reset     откатить все применённые стадии через down-миграции
+  fresh     очистить целевую схему (с -schema — пересоздать), затем применить все миграции (нужен -allow-destroy)
+  baseline  отметить все миграции до версии применёнными без выполнения SQL (baseline -to <версия>)
+  mark-applied  отметить миграции применёнными без выполнения SQL (mark-applied <ключ>...)
+  unmark    удалить записи о миграциях без выполнения down (unmark <ключ>...)
+  status    показать применённые, неприменённые, пропавшие и изменённые миграции
+  history   показать журнал lamigrate_history: все up/down/baseline/repair, включая откаченные
+  plan      показать план up/down без выполнения (plan down -stages N, -sql)
+  script    сгенерировать SQL-скрипт для DBA (script up|down, script history для экспорта истории)
+  verify    сравнить checksum применённых миграций с файлами (код 3 при расхождениях)
+  repair    перезаписать сохранённые checksum по текущим файлам (с подтверждением)
   create    создать пару файлов миграций (up/down)
+  config show  показать итоговую конфигурацию и источник каждого значения (пароли скрыты)
+  meta status  показать версию служебных таблиц lamigrate и шаги их обновления
+  meta upgrade обновить служебные таблицы lamigrate без применения миграций
   version   показать версию
   help      показать справку
 
 Флаги:
   -dir      путь к директории миграций (по умолчанию ./migrations)
-  -driver   имя драйвера (по умолчанию postgres)
+  -driver   имя драйвера (по умолчанию — по схеме DSN, иначе postgres)
   -dsn      строка подключения к БД (или POSTGRES_* по умолчанию)
-  -stages   сколько стадий откатить (только для down)
+  -stages   сколько стадий откатить (для down и redo)
+  -to       версия: up — применить до неё включительно, down — откатить всё новее неё, baseline — отметить до неё
+  -to-stage откатить стадии выше указанной (для down, 0 — все)
+  -migration  одна миграция по ключу version_name или версии (для down, redo и history)
+  -force    откатить -migration, даже если после неё применены другие
   -name     имя миграции (для create)
+  -dry-run  показать план вместо выполнения (для up/down)
+  -allow-destroy  разрешить fresh удалить объекты схемы
+  -drop-extensions  разрешить fresh удалить расширения Postgres (по умолчанию они остаются)
+  -sql      печатать SQL файлов в плане (для plan и -dry-run)
+  -history  JSON-файл с экспортом истории (для script, вместо чтения БД)
+  -o        файл вывода (для script)
+  -yes      не спрашивать подтверждение (для repair)
+  -format   формат status, history и meta status: table (по умолчанию), json, yaml
+  -stage    только записи стадии (для history)
+  -since    записи не раньше: RFC3339, YYYY-MM-DD[ HH:MM] или длительность назад, например 24h (для history)
+  -until    записи раньше указанного времени (для history)
+  -limit    сколько последних записей показать (для history, 0 — все)
+  -check    коды выхода status для CI: 3 — дрейф, 4 — неприменённые, 5 — пропавшие
   -timeout  общий таймаут выполнения
+  -tx-mode  режим транзакций: all (по умолчанию), per-migration, none
+  -lock-timeout  сколько ждать блокировку миграций (по умолчанию 1m, 0 — до -timeout)
+  -log-format    формат событий миграций в stderr: text (по умолчанию), json
+  -table    имя таблицы истории (по умолчанию lamigrate)
+  -schema   схема таблицы истории, создаётся при отсутствии (по умолчанию — из DSN)
+  -config   файл конфигурации (по умолчанию lamigrate.yaml в рабочей директории)
+  -env      окружение из файла конфигурации (dev, staging, prod, ...)
 
 Переменные окружения:
   LAMIGRATE_DSN
   LAMIGRATE_DRIVER
   LAMIGRATE_MIGRATIONS_DIR
+  LAMIGRATE_TABLE
+  LAMIGRATE_SCHEMA
+  LAMIGRATE_ENV
+  LAMIGRATE_CONFIG
   POSTGRES_HOST
   POSTGRES_PORT
   POSTGRES_USER
   POSTGRES_PASSWORD
   POSTGRES_DB
   
-Если LAMIGRATE_DSN не задан, DSN собирается из POSTGRES_* (по умолчанию postgres).
+Если LAMIGRATE_DSN не задан, DSN собирается из POSTGRES_* (для драйвера postgres).
+Если -driver не задан, драйвер определяется по схеме DSN (postgres://, mysql://, sqlite://).
 Если LAMIGRATE_MIGRATIONS_DIR не задан, используется ./migrations.
+Приоритет: переменные окружения > флаги > environments.<env> в lamigrate.yaml > верхний уровень lamigrate.yaml > умолчания.
 
 Примеры:
   lamigrate up
   lamigrate down -stages 3
+  lamigrate up -to 20240101120000
+  lamigrate down -to 20240101120000
+  lamigrate down -to-stage 5 -dry-run
+  lamigrate down -migration 20240101120000_add_users
+  lamigrate redo -migration 20240101120000
+  lamigrate redo
+  lamigrate reset
+  lamigrate fresh -allow-destroy
+  lamigrate baseline -to 20240101120000
+  lamigrate mark-applied 20240101120000_add_users
+  lamigrate unmark 20240101120000
+  lamigrate up -env prod
+  lamigrate config show -env staging
+  lamigrate up -tx-mode per-migration
+  lamigrate plan down -stages 3 -sql
+  lamigrate up -dry-run
+  lamigrate script up -o deploy.sql
+  lamigrate script down -stages 2 -history history.json
   lamigrate status
+  lamigrate status -format json -check
+  lamigrate history -since 24h
+  lamigrate history -migration 20240101120000 -format json
+  lamigrate verify
+  lamigrate repair -yes
   lamigrate create add_users
 `)
+	fmt.Printf("\nДоступные драйверы: %s\n", strings.Join(lamigrate.Drivers(), ", "))
 }
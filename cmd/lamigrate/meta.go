@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+// runMetaStatus печатает версию служебных таблиц lamigrate и шаги их обновления.
+// Вход: cfg с флагами/окружением и формат вывода.
+// Выход: печать в stdout; код 1, если БД обновлена более новой версией lamigrate.
+// Назначение: выполнить команду meta status.
+// runMetaStatus prints the version of the lamigrate bookkeeping tables and their upgrade steps.
+// Input: cfg with flags/env and output format.
+// Output: prints to stdout; exit code 1 if a newer lamigrate upgraded the database.
+// Purpose: execute the meta status command.
+func runMetaStatus(cfg *config, format string) {
+	driver, config := buildConfig(cfg, false, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	status, err := lamigrate.ReadMetaStatus(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	switch format {
+	case "", "table":
+		printMetaTable(status)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(newMetaOutput(status)); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(newMetaOutput(status)); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		_ = encoder.Close()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown meta status format: %s (expected table, json or yaml)\n", format)
+		os.Exit(2)
+	}
+
+	if status.TooNew() {
+		fmt.Fprintf(os.Stderr, "%s: database metadata version %d, this lamigrate %s supports up to %d; upgrade lamigrate\n",
+			lamigrate.ErrMetaTooNew, status.Version, lamigrate.Version, status.Supported)
+		os.Exit(1)
+	}
+}
+
+// runMetaUpgrade применяет недостающие шаги обновления служебных таблиц.
+// Вход: cfg с флагами/окружением.
+// Выход: печать применённых шагов в stdout; код 1 при ошибке.
+// Назначение: выполнить команду meta upgrade — обновить служебные таблицы, не применяя миграций.
+// runMetaUpgrade applies missing bookkeeping table upgrade steps.
+// Input: cfg with flags/env.
+// Output: prints applied steps to stdout; exit code 1 on error.
+// Purpose: execute the meta upgrade command — upgrade the bookkeeping tables without applying migrations.
+func runMetaUpgrade(cfg *config) {
+	driver, config := buildConfig(cfg, false, true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	applied, err := lamigrate.UpgradeMeta(ctx, config.cfg, driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(applied) == 0 {
+		fmt.Println("lamigrate metadata is up to date")
+		return
+	}
+	for _, upgrade := range applied {
+		fmt.Printf("applied metadata step %d: %s\n", upgrade.Version, upgrade.Description)
+	}
+}
+
+// metaOutput — результат meta status -format json|yaml.
+// metaOutput is the meta status -format json|yaml result.
+type metaOutput struct {
+	Version   int              `json:"version" yaml:"version"`
+	Supported int              `json:"supported" yaml:"supported"`
+	Steps     []metaStepOutput `json:"steps" yaml:"steps"`
+}
+
+// metaStepOutput — шаг обновления в выводе meta status.
+// metaStepOutput is an upgrade step in meta status output.
+type metaStepOutput struct {
+	Version          int    `json:"version" yaml:"version"`
+	Description      string `json:"description" yaml:"description"`
+	State            string `json:"state" yaml:"state"`
+	LamigrateVersion string `json:"lamigrate_version,omitempty" yaml:"lamigrate_version,omitempty"`
+	AppliedAt        string `json:"applied_at,omitempty" yaml:"applied_at,omitempty"`
+}
+
+// newMetaOutput переводит MetaStatus в список шагов со состоянием applied/pending.
+// Вход: состояние служебной схемы.
+// Выход: вывод с применёнными шагами (включая неизвестные этой версии) и ожидающими.
+// Назначение: общая форма для таблицы и JSON/YAML.
+// newMetaOutput turns MetaStatus into a list of steps with an applied/pending state.
+// Input: bookkeeping schema state.
+// Output: output with applied steps (including ones unknown to this version) and pending ones.
+// Purpose: a shared shape for the table and JSON/YAML.
+func newMetaOutput(status lamigrate.MetaStatus) metaOutput {
+	out := metaOutput{Version: status.Version, Supported: status.Supported, Steps: []metaStepOutput{}}
+	for _, record := range status.Applied {
+		out.Steps = append(out.Steps, metaStepOutput{
+			Version:          record.Version,
+			Description:      record.Description,
+			State:            "applied",
+			LamigrateVersion: record.LamigrateVersion,
+			AppliedAt:        record.AppliedAt.Format(time.RFC3339),
+		})
+	}
+	for _, upgrade := range status.Pending {
+		out.Steps = append(out.Steps, metaStepOutput{
+			Version:     upgrade.Version,
+			Description: upgrade.Description,
+			State:       "pending",
+		})
+	}
+	return out
+}
+
+// printMetaTable печатает версию служебной схемы и таблицу шагов.
+// Вход: состояние служебной схемы.
+// Выход: печать в stdout.
+// Назначение: формат meta status по умолчанию для человека.
+// printMetaTable prints the bookkeeping schema version and the table of steps.
+// Input: bookkeeping schema state.
+// Output: prints to stdout.
+// Purpose: the default human-readable meta status format.
+func printMetaTable(status lamigrate.MetaStatus) {
+	title := fmt.Sprintf("Metadata version %d (this lamigrate supports %d)", status.Version, status.Supported)
+	switch {
+	case status.TooNew():
+		printTitleTable(title, colorRed)
+	case len(status.Pending) > 0:
+		printTitleTable(title, colorYellow)
+	default:
+		printTitleTable(title, colorGreen)
+	}
+
+	out := newMetaOutput(status)
+	if len(out.Steps) == 0 {
+		return
+	}
+	headers := []string{"version", "description", "state", "lamigrate_version", "applied_at"}
+	rows := make([][]string, 0, len(out.Steps))
+	colors := make([]string, 0, len(out.Steps))
+	for _, step := range out.Steps {
+		rows = append(rows, []string{
+			strconv.Itoa(step.Version),
+			step.Description,
+			step.State,
+			valueOrDash(step.LamigrateVersion),
+			valueOrDash(step.AppliedAt),
+		})
+		switch {
+		case step.Version > status.Supported:
+			colors = append(colors, colorRed)
+		case step.State == "pending":
+			colors = append(colors, colorYellow)
+		default:
+			colors = append(colors, colorGreen)
+		}
+	}
+	printGrid(colorGray, headers, rows, colors)
+}
@@ -0,0 +1,173 @@
+package lamigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Checksum считает контрольную сумму содержимого файла миграции.
+// Вход: байты файла.
+// Выход: hex-строка SHA-256.
+// Назначение: обнаруживать изменения уже применённых миграций.
+// Checksum computes a checksum of migration file content.
+// Input: file bytes.
+// Output: SHA-256 hex string.
+// Purpose: detect edits of already applied migrations.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChecksumMismatch описывает расхождение checksum применённой миграции и файла.
+// Назначение: показать, какой файл был изменён после применения.
+// ChecksumMismatch describes a difference between an applied checksum and the file.
+// Purpose: show which file was edited after it was applied.
+type ChecksumMismatch struct {
+	Migration string
+	Filename  string
+	Applied   string
+	Current   string
+}
+
+// ChecksumError возвращается, если применённые миграции были изменены.
+// Назначение: громко остановить up/status при изменении истории.
+// ChecksumError is returned when applied migrations were edited.
+// Purpose: loudly stop up/status when history was changed.
+type ChecksumError struct {
+	Mismatches []ChecksumMismatch
+}
+
+// Error формирует текст ошибки со списком изменённых файлов.
+// Вход: нет.
+// Выход: текст ошибки.
+// Назначение: реализовать интерфейс error.
+// Error builds an error message listing edited files.
+// Input: none.
+// Output: error message.
+// Purpose: implement the error interface.
+func (e *ChecksumError) Error() string {
+	parts := make([]string, 0, len(e.Mismatches))
+	for _, item := range e.Mismatches {
+		parts = append(parts, fmt.Sprintf("%s (applied %s, file %s)", item.Filename, shortChecksum(item.Applied), shortChecksum(item.Current)))
+	}
+	return "checksum mismatch for applied migrations: " + strings.Join(parts, ", ")
+}
+
+// FindChecksumMismatches сравнивает checksum применённых миграций с файлами.
+// Вход: просканированные миграции и записи из lamigrate.
+// Выход: список расхождений (пустой, если всё совпадает).
+// Назначение: найти изменённые после применения up-файлы.
+// Записи без сохранённого checksum (применённые старыми версиями) пропускаются.
+// FindChecksumMismatches compares applied checksums with files on disk.
+// Input: scanned migrations and lamigrate records.
+// Output: list of mismatches (empty when everything matches).
+// Purpose: find up files edited after they were applied.
+// Records without a stored checksum (applied by older versions) are skipped.
+func FindChecksumMismatches(migrations []Migration, applied []AppliedMigration) []ChecksumMismatch {
+	upByName := make(map[string]Migration, len(migrations))
+	for _, migration := range migrations {
+		if migration.Direction != DirectionUp {
+			continue
+		}
+		upByName[migration.Key()] = migration
+	}
+
+	var mismatches []ChecksumMismatch
+	for _, item := range applied {
+		if item.Checksum == "" {
+			continue
+		}
+		migration, ok := upByName[item.Migration]
+		if !ok {
+			continue
+		}
+		if migration.Checksum == item.Checksum {
+			continue
+		}
+		mismatches = append(mismatches, ChecksumMismatch{
+			Migration: item.Migration,
+			Filename:  migration.Filename,
+			Applied:   item.Checksum,
+			Current:   migration.Checksum,
+		})
+	}
+	return mismatches
+}
+
+// VerifyChecksums проверяет, что применённые миграции не менялись.
+// Вход: просканированные миграции и записи из lamigrate.
+// Выход: *ChecksumError при расхождениях или nil.
+// Назначение: защитить от правок уже применённых файлов.
+// VerifyChecksums checks that applied migrations were not edited.
+// Input: scanned migrations and lamigrate records.
+// Output: *ChecksumError on mismatches or nil.
+// Purpose: guard against edits of already applied files.
+func VerifyChecksums(migrations []Migration, applied []AppliedMigration) error {
+	mismatches := FindChecksumMismatches(migrations, applied)
+	if len(mismatches) == 0 {
+		return nil
+	}
+	return &ChecksumError{Mismatches: mismatches}
+}
+
+// shortChecksum сокращает checksum для сообщений.
+// Вход: полный checksum.
+// Выход: первые 12 символов.
+// Назначение: сделать ошибки читаемыми.
+// shortChecksum shortens a checksum for messages.
+// Input: full checksum.
+// Output: first 12 characters.
+// Purpose: keep errors readable.
+func shortChecksum(checksum string) string {
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	return checksum
+}
+
+// Verify сравнивает применённые миграции с checksum их up-файлов.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver.
+// Выход: список расхождений (пустой, если дрейфа нет) или error.
+// Назначение: команда verify и проверка в CI.
+// Verify compares applied migrations with checksums of their up files.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation.
+// Output: list of mismatches (empty when there is no drift) or error.
+// Purpose: the verify command and CI checks.
+func Verify(ctx context.Context, cfg Config, driver Driver) ([]ChecksumMismatch, error) {
+	migrations, err := scanConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := ListApplied(ctx, cfg, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindChecksumMismatches(migrations, applied), nil
+}
+
+// Repair перезаписывает сохранённые checksum по текущим up-файлам.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver.
+// Выход: список исправленных записей или error.
+// Назначение: принять правки применённых файлов после явного подтверждения.
+// Записи без checksum (применённые старыми версиями) тоже заполняются;
+// у них поле Applied пустое.
+// Repair rewrites stored checksums from the current up files.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation.
+// Output: list of repaired records or error.
+// Purpose: accept edits of applied files after an explicit confirmation.
+// Records without a checksum (applied by older versions) are filled too;
+// their Applied field is empty.
+func Repair(ctx context.Context, cfg Config, driver Driver) ([]ChecksumMismatch, error) {
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return m.Repair(ctx)
+}
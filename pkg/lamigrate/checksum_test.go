@@ -0,0 +1,147 @@
+package lamigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"lamigrate/pkg/lamigrate"
+	"lamigrate/pkg/lamigrate/drivers/sqlite"
+)
+
+// checksumMigrations — две миграции, которые применяются до правок файлов.
+// checksumMigrations are two migrations applied before the files are edited.
+var checksumMigrations = fstest.MapFS{
+	"20240101000000_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);\n")},
+	"20240101000000_users.down.sql": {Data: []byte("DROP TABLE users;\n")},
+	"20240102000000_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY);\n")},
+	"20240102000000_posts.down.sql": {Data: []byte("DROP TABLE posts;\n")},
+}
+
+// openTestDB открывает пустую БД SQLite во временном каталоге теста.
+// openTestDB opens an empty SQLite database in the test's temporary directory.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// newTestMigrator создаёт Migrator драйвера SQLite над fsys.
+// newTestMigrator creates a SQLite Migrator over fsys.
+func newTestMigrator(t *testing.T, db *sql.DB, fsys fstest.MapFS, opts ...lamigrate.Option) *lamigrate.Migrator {
+	t.Helper()
+	opts = append([]lamigrate.Option{lamigrate.WithMigrationsFS(fsys), lamigrate.WithLockTimeout(time.Second)}, opts...)
+	m, err := lamigrate.NewMigrator(db, sqlite.New(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "empty", content: "", want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{name: "ascii", content: "abc", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{name: "trailing newline counts", content: "abc\n", want: "edeaaff3f1774ad2888673770c6d64097e391bc362d7d6fb34982ddf0efd18cb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lamigrate.Checksum([]byte(tt.content)); got != tt.want {
+				t.Fatalf("Checksum(%q) = %s, want %s", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksumDrift(t *testing.T) {
+	tests := []struct {
+		name string
+		// edit меняет файлы после применения, clear — checksum в БД.
+		// edit changes files after they are applied, clear the checksum in the database.
+		edit      map[string]string
+		clear     string
+		wantDrift []string
+	}{
+		{name: "unchanged"},
+		{
+			name:      "edited applied file",
+			edit:      map[string]string{"20240101000000_users.up.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\n"},
+			wantDrift: []string{"20240101000000_users.up.sql"},
+		},
+		{
+			name: "edited down file",
+			edit: map[string]string{"20240102000000_posts.down.sql": "DROP TABLE IF EXISTS posts;\n"},
+		},
+		{
+			name:  "empty stored checksum is skipped",
+			edit:  map[string]string{"20240101000000_users.up.sql": "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\n"},
+			clear: "20240101000000_users",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openTestDB(t)
+			if _, err := newTestMigrator(t, db, checksumMigrations).Up(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if tt.clear != "" {
+				if _, err := db.Exec(`UPDATE lamigrate SET checksum = NULL WHERE migration = ?`, tt.clear); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			edited := maps.Clone(checksumMigrations)
+			for name, content := range tt.edit {
+				edited[name] = &fstest.MapFile{Data: []byte(content)}
+			}
+			edited["20240103000000_comments.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE comments (id INTEGER PRIMARY KEY);\n")}
+			m := newTestMigrator(t, db, edited)
+
+			report, err := m.Status(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var drift []string
+			for _, item := range report.Drift {
+				drift = append(drift, item.Filename)
+				if item.Applied == item.Current || item.Current != lamigrate.Checksum(edited[item.Filename].Data) {
+					t.Fatalf("drift %s = %+v, want the stored and the current file checksum", item.Filename, item)
+				}
+			}
+			if len(drift) != len(tt.wantDrift) || (len(drift) > 0 && drift[0] != tt.wantDrift[0]) {
+				t.Fatalf("status drift = %v, want %v", drift, tt.wantDrift)
+			}
+
+			_, err = m.Up(ctx)
+			var checksumErr *lamigrate.ChecksumError
+			if len(tt.wantDrift) > 0 {
+				if !errors.As(err, &checksumErr) || len(checksumErr.Mismatches) != len(tt.wantDrift) {
+					t.Fatalf("Up() with drift = %v, want *ChecksumError", err)
+				}
+				if report, _ := m.Status(ctx); len(report.Pending) != 1 {
+					t.Fatalf("Up() with drift applied migrations: %d pending, want 1", len(report.Pending))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Up() without drift = %v", err)
+			}
+		})
+	}
+}
@@ -1,11 +1,126 @@
 package lamigrate
 
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
 // Config хранит настройки для запуска миграций.
 // Назначение: передать DSN и директорию в функции запуска.
+// Migrations — источник файлов (например, embed.FS); если nil, читается
+// os.DirFS(MigrationsDir), иначе MigrationsDir — поддиректория внутри Migrations.
+// LockTimeout — сколько ждать блокировку миграций (0 — пока не отменён ctx).
+// TxMode — стратегия транзакций (пустое значение означает TxModeAll).
+// Logger получает события раннера (nil — без логов).
+// Table и Schema задают таблицу истории (пустые — lamigrate в схеме/базе из DSN);
+// схема создаётся, если её нет.
+// DropExtensions разрешает fresh удалить расширения Postgres (по умолчанию они остаются).
 // Config holds settings for running migrations.
 // Purpose: pass DSN and directory into runner functions.
+// Migrations is the file source (e.g. embed.FS); when nil, os.DirFS(MigrationsDir)
+// is read, otherwise MigrationsDir is a subdirectory inside Migrations.
+// LockTimeout is how long to wait for the migration lock (0 waits until ctx is done).
+// TxMode is the transaction strategy (empty value means TxModeAll).
+// Logger receives runner events (nil means no logging).
+// Table and Schema set the history table (empty means lamigrate in the DSN schema/database);
+// the schema is created when missing.
+// DropExtensions allows fresh to drop Postgres extensions (they are kept by default).
 type Config struct {
-	MigrationsDir string
-	DriverName    string
-	DSN           string
+	Migrations     fs.FS
+	MigrationsDir  string
+	DriverName     string
+	DSN            string
+	LockTimeout    time.Duration
+	TxMode         TxMode
+	Logger         Logger
+	Table          string
+	Schema         string
+	DropExtensions bool
+}
+
+// TxMode задаёт, как миграции группируются в транзакции.
+// TxMode defines how migrations are grouped into transactions.
+type TxMode string
+
+const (
+	// TxModeAll выполняет весь запуск одной транзакцией (по умолчанию).
+	// TxModeAll runs the whole invocation in one transaction (default).
+	TxModeAll TxMode = "all"
+	// TxModePerMigration фиксирует каждую миграцию отдельно, сохраняя прогресс при ошибке.
+	// TxModePerMigration commits each migration separately, keeping progress on failure.
+	TxModePerMigration TxMode = "per-migration"
+	// TxModeNone выполняет миграции без транзакций; транзакция только для записи в lamigrate.
+	// TxModeNone runs migrations without transactions; only bookkeeping is transactional.
+	TxModeNone TxMode = "none"
+)
+
+// ParseTxMode проверяет и нормализует строку режима транзакций.
+// Вход: строка из флага или конфига.
+// Выход: TxMode или error для неизвестного значения.
+// Назначение: валидировать -tx-mode.
+// ParseTxMode validates and normalizes a transaction mode string.
+// Input: string from a flag or config.
+// Output: TxMode or error for an unknown value.
+// Purpose: validate -tx-mode.
+func ParseTxMode(value string) (TxMode, error) {
+	switch mode := TxMode(value); mode {
+	case "":
+		return TxModeAll, nil
+	case TxModeAll, TxModePerMigration, TxModeNone:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown transaction mode %q (want all, per-migration or none)", value)
+	}
+}
+
+// ResolveTxMode возвращает режим транзакций, который реально будет использован.
+// Вход: driver и режим из конфига.
+// Выход: режим или error для неизвестного значения.
+// Назначение: не обещать атомарный stage, если СУБД неявно фиксирует DDL
+// (например, MySQL): режим all в этом случае заменяется на per-migration,
+// чтобы запись в lamigrate фиксировалась вместе с каждой миграцией.
+// ResolveTxMode returns the transaction mode that will actually be used.
+// Input: driver and mode from config.
+// Output: mode or error for an unknown value.
+// Purpose: do not promise an atomic stage when the database commits DDL
+// implicitly (e.g. MySQL): all mode is replaced by per-migration so the
+// lamigrate record is committed together with each migration.
+func ResolveTxMode(driver Driver, mode TxMode) (TxMode, error) {
+	mode, err := ParseTxMode(string(mode))
+	if err != nil {
+		return "", err
+	}
+	if mode == TxModeAll && !driver.TransactionalDDL() {
+		return TxModePerMigration, nil
+	}
+	return mode, nil
+}
+
+// MigrationsFS возвращает файловую систему с миграциями.
+// Вход: нет.
+// Выход: fs.FS или error, если источник не задан.
+// Назначение: единая точка выбора между диском и встроенными файлами.
+// MigrationsFS returns the file system holding migrations.
+// Input: none.
+// Output: fs.FS or error when no source is set.
+// Purpose: single place choosing between disk and embedded files.
+func (c Config) MigrationsFS() (fs.FS, error) {
+	if c.Migrations == nil {
+		if c.MigrationsDir == "" {
+			return nil, fmt.Errorf("migrations dir is empty")
+		}
+		return os.DirFS(c.MigrationsDir), nil
+	}
+	if c.MigrationsDir == "" {
+		return c.Migrations, nil
+	}
+
+	sub, err := fs.Sub(c.Migrations, path.Clean(c.MigrationsDir))
+	if err != nil {
+		return nil, fmt.Errorf("open migrations dir %s: %w", c.MigrationsDir, err)
+	}
+	return sub, nil
 }
@@ -8,27 +8,138 @@ import (
 
 // Driver определяет операции для конкретной БД.
 // Назначение: абстрагировать различия между СУБД.
+// Драйвер отвечает за четыре вещи: подключение и межпроцессную блокировку (Open, Lock,
+// Unlock); служебные таблицы — историю lamigrate, журнал <table>_history и версии
+// <table>_meta, которые он создаёт упорядоченными шагами MetaUpgrades, а затем читает
+// и пишет остальными методами, в том числе в виде SQL для script; выполнение миграций
+// (WithTransaction, TransactionalDDL, DropSchema для fresh); разбор ошибок своего
+// клиента в DBError (DescribeError). Методы чтения не должны ничего создавать: если
+// таблиц ещё нет, SchemaExists возвращает false, а MetaRecords — пустой список.
 // Driver defines database-specific operations.
 // Purpose: abstract differences between database backends.
+// A driver is responsible for four things: connecting and the cross-process lock (Open,
+// Lock, Unlock); the bookkeeping tables — the lamigrate history, the <table>_history
+// journal and the <table>_meta versions, which it creates with the ordered MetaUpgrades
+// steps and then reads and writes with the other methods, including as SQL for script;
+// running migrations (WithTransaction, TransactionalDDL, DropSchema for fresh); and
+// turning its client's errors into DBError (DescribeError). Read methods must not create
+// anything: when the tables are missing, SchemaExists returns false and MetaRecords an empty list.
 type Driver interface {
 	Name() string
+	TransactionalDDL() bool
 	Open(dsn string) (*sql.DB, error)
-	EnsureSchema(ctx context.Context, db *sql.DB) error
+	Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (*sql.Conn, error)
+	Unlock(ctx context.Context, db *sql.DB, conn *sql.Conn) error
+	SchemaExists(ctx context.Context, db *sql.DB) (bool, error)
+	DropSchema(ctx context.Context, db *sql.DB, options DropOptions) error
 	AppliedMigrations(ctx context.Context, db *sql.DB) ([]AppliedMigration, error)
 	MaxStage(ctx context.Context, db *sql.DB) (int, error)
 	StagesDesc(ctx context.Context, db *sql.DB) ([]int, error)
 	MigrationsByStage(ctx context.Context, db *sql.DB, stage int) ([]string, error)
 	WithTransaction(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error
-	InsertMigration(ctx context.Context, tx *sql.Tx, migrationName string, stage int) error
+	InsertMigration(ctx context.Context, tx *sql.Tx, record MigrationRecord) error
 	DeleteMigration(ctx context.Context, tx *sql.Tx, migrationName string) error
+	UpdateChecksum(ctx context.Context, tx *sql.Tx, migrationName string, checksum string) error
+	ScriptInsertMigration(record MigrationRecord) string
+	ScriptDeleteMigration(migrationName string) string
+	WithHistoryTable(schema, table string) (Driver, error)
+	WithMetaVersion(version int) Driver
+	InsertJournal(ctx context.Context, tx *sql.Tx, entry JournalEntry) error
+	JournalEntries(ctx context.Context, db *sql.DB, filter JournalFilter) ([]JournalEntry, error)
+	MetaUpgrades() []MetaUpgrade
+	MetaRecords(ctx context.Context, db *sql.DB) ([]MetaRecord, error)
+	InsertMetaRecord(ctx context.Context, tx *sql.Tx, record MetaRecord) error
+	ScriptInsertMetaRecord(record MetaRecord) string
+	ScriptInsertJournal(entry JournalEntry) string
+	DescribeError(err error) (DBError, bool)
+}
+
+// DropOptions — настройки DropSchema для fresh.
+// Extensions разрешает удалить расширения (Postgres); без него fresh их не трогает,
+// а схему с расширениями не пересоздаёт.
+// DropOptions are DropSchema settings for fresh.
+// Extensions allows dropping extensions (Postgres); without it fresh leaves them alone
+// and does not recreate a schema that has extensions.
+type DropOptions struct {
+	Extensions bool
+}
+
+// BookkeepingTables возвращает служебные таблицы lamigrate для найденных таблиц истории:
+// саму таблицу, <table>_meta, журнал <table>_history и <table>_lock.
+// Вход: имена таблиц истории — таблиц схемы с колонками migration, stage и executed_at.
+// Выход: множество имён, которые DropSchema пропускает.
+// Назначение: fresh в общей схеме не удаляет историю других сервисов (своя таблица
+// истории и <table>_meta удаляются драйвером отдельно, свой журнал остаётся).
+// BookkeepingTables returns the lamigrate bookkeeping tables for the history tables found:
+// the table itself, <table>_meta, the <table>_history journal and <table>_lock.
+// Input: history table names — schema tables with migration, stage and executed_at columns.
+// Output: set of names DropSchema skips.
+// Purpose: fresh in a shared schema keeps other services' history (the driver drops its own
+// history table and <table>_meta separately and keeps its own journal).
+func BookkeepingTables(historyTables []string) map[string]bool {
+	tables := make(map[string]bool, 4*len(historyTables))
+	for _, table := range historyTables {
+		for _, suffix := range []string{"", MetaTableSuffix, JournalTableSuffix, LockTableSuffix} {
+			tables[table+suffix] = true
+		}
+	}
+	return tables
 }
 
 // AppliedMigration — запись о применённой миграции со stage.
 // Назначение: отдавать список применённых миграций для status/планирования.
+// Duration — время выполнения SQL (с точностью до миллисекунды), DBUser — роль БД,
+// Hostname и Version — хост и версия lamigrate, которые её применили; у записей
+// старых версий lamigrate эти поля пустые.
 // AppliedMigration is a stored migration record with stage.
 // Purpose: return applied migrations for status/planning.
+// Duration is the SQL execution time (millisecond precision), DBUser is the database
+// role, Hostname and Version are the host and lamigrate version that applied it;
+// records of older lamigrate versions leave these fields empty.
 type AppliedMigration struct {
-	Migration  string
-	Stage      int
-	ExecutedAt time.Time
+	Migration  string        `json:"migration"`
+	Stage      int           `json:"stage"`
+	ExecutedAt time.Time     `json:"executed_at"`
+	Checksum   string        `json:"checksum,omitempty"`
+	Origin     Origin        `json:"origin,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	DBUser     string        `json:"db_user,omitempty"`
+	Hostname   string        `json:"hostname,omitempty"`
+	Version    string        `json:"lamigrate_version,omitempty"`
+}
+
+// Origin — как запись попала в lamigrate.
+// Назначение: аудит: отличить выполненные миграции от отмеченных вручную.
+// Origin is how a record got into lamigrate.
+// Purpose: audit: tell executed migrations from manually marked ones.
+type Origin string
+
+const (
+	// OriginApply — миграция выполнена up/redo/fresh.
+	// OriginApply means the migration was executed by up/redo/fresh.
+	OriginApply Origin = "apply"
+	// OriginBaseline — записана baseline без выполнения SQL.
+	// OriginBaseline means it was recorded by baseline without running SQL.
+	OriginBaseline Origin = "baseline"
+	// OriginManual — записана mark-applied без выполнения SQL.
+	// OriginManual means it was recorded by mark-applied without running SQL.
+	OriginManual Origin = "manual"
+)
+
+// MigrationRecord — запись о миграции, которую раннер передаёт драйверу.
+// Назначение: одна структура для InsertMigration и ScriptInsertMigration,
+// чтобы новые поля истории не меняли сигнатуры драйверов.
+// Роль БД драйвер берёт из сессии (current_user), а не из записи.
+// MigrationRecord is a migration record the runner hands to the driver.
+// Purpose: one struct for InsertMigration and ScriptInsertMigration so that
+// new history fields do not change driver signatures.
+// The driver takes the database role from the session (current_user), not from the record.
+type MigrationRecord struct {
+	Migration string
+	Stage     int
+	Checksum  string
+	Origin    Origin
+	Duration  time.Duration
+	Hostname  string
+	Version   string
 }
@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+func TestDescribeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want lamigrate.DBError
+		ok   bool
+	}{
+		{
+			name: "mysql error with sqlstate",
+			err:  &gomysql.MySQLError{Number: 1064, SQLState: [5]byte{'4', '2', '0', '0', '0'}, Message: "You have an error in your SQL syntax"},
+			want: lamigrate.DBError{Code: "42000", Message: "You have an error in your SQL syntax"},
+			ok:   true,
+		},
+		{
+			name: "wrapped mysql error without sqlstate",
+			err:  fmt.Errorf("exec: %w", &gomysql.MySQLError{Number: 1146, Message: "Table 't' doesn't exist"}),
+			want: lamigrate.DBError{Message: "Table 't' doesn't exist"},
+			ok:   true,
+		},
+		{
+			name: "not a database error",
+			err:  errors.New("connection refused"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := New().DescribeError(tt.err)
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("DescribeError() = %+v, %v; want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLockSingleConnection(t *testing.T) {
+	// Соединение не открывается: проверка пула идёт до него, поэтому сервер не нужен.
+	// No connection is opened: the pool check comes first, so no server is needed.
+	db, err := sql.Open("mysql", "lamigrate@tcp(127.0.0.1:1)/lamigrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := New().Lock(context.Background(), db, time.Second); !errors.Is(err, lamigrate.ErrSingleConnection) {
+		t.Fatalf("Lock() on a one-connection pool = %v, want ErrSingleConnection", err)
+	}
+}
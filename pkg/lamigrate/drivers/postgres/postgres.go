@@ -3,18 +3,34 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
 
-	// Регистрируем драйвер Postgres.
-	// Register the Postgres driver.
-	_ "github.com/lib/pq"
+	// Импорт pq заодно регистрирует драйвер Postgres.
+	// Importing pq also registers the Postgres driver.
+	"github.com/lib/pq"
 
 	"lamigrate/pkg/lamigrate"
 )
 
 // Driver реализует драйвер миграций для Postgres.
+// schema и table задают таблицу истории (пустые — lamigrate в search_path).
 // Driver implements the Postgres migrations driver.
-type Driver struct{}
+// schema and table set the history table (empty means lamigrate in search_path).
+type Driver struct {
+	schema      string
+	table       string
+	metaVersion int
+	pinned      bool
+}
+
+// maxIdentifierLength — предел длины идентификатора Postgres (NAMEDATALEN - 1).
+// maxIdentifierLength is the Postgres identifier length limit (NAMEDATALEN - 1).
+const maxIdentifierLength = 63
 
 // New создаёт новый экземпляр драйвера Postgres.
 // Вход: нет.
@@ -28,6 +44,15 @@ func New() *Driver {
 	return &Driver{}
 }
 
+// init регистрирует драйвер Postgres в реестре lamigrate.
+// Назначение: подключать драйвер пустым импортом.
+// init registers the Postgres driver in the lamigrate registry.
+// Purpose: enable the driver with a blank import.
+func init() {
+	lamigrate.Register("postgres", func() lamigrate.Driver { return New() })
+	lamigrate.RegisterScheme("postgresql", "postgres")
+}
+
 // Name возвращает имя драйвера.
 // Вход: нет.
 // Выход: строка имени драйвера.
@@ -40,6 +65,126 @@ func (d *Driver) Name() string {
 	return "postgres"
 }
 
+// TransactionalDDL сообщает, что DDL в Postgres выполняется внутри транзакции.
+// Вход: нет.
+// Выход: true.
+// Назначение: разрешить раннеру атомарно применять stage.
+// TransactionalDDL reports that DDL in Postgres runs inside a transaction.
+// Input: none.
+// Output: true.
+// Purpose: allow the runner to apply a stage atomically.
+func (d *Driver) TransactionalDDL() bool {
+	return true
+}
+
+// WithHistoryTable возвращает копию драйвера с другой таблицей истории.
+// Вход: схема (пустая — по search_path) и имя таблицы (пустое — lamigrate).
+// Выход: настроенный драйвер или error для слишком длинного или пустого после trim имени.
+// Назначение: несколько независимых сервисов в одной базе. Имена экранируются
+// двойными кавычками, поэтому регистр и спецсимволы сохраняются как есть.
+// WithHistoryTable returns a copy of the driver with another history table.
+// Input: schema (empty means search_path) and table name (empty means lamigrate).
+// Output: configured driver or error for a name that is too long or blank.
+// Purpose: several independent services in one database. Names are quoted with
+// double quotes, so case and special characters are kept as is.
+func (d *Driver) WithHistoryTable(schema, table string) (lamigrate.Driver, error) {
+	for _, name := range []string{schema, table} {
+		if name != "" && strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("postgres: history table identifier is blank")
+		}
+		if len(name) > maxIdentifierLength {
+			return nil, fmt.Errorf("postgres: identifier %q is longer than %d bytes", name, maxIdentifierLength)
+		}
+	}
+	if len(table+lamigrate.JournalTableSuffix) > maxIdentifierLength {
+		return nil, fmt.Errorf("postgres: table %q leaves no room for the %s journal suffix (%d bytes max)", table, lamigrate.JournalTableSuffix, maxIdentifierLength)
+	}
+	return &Driver{schema: schema, table: table}, nil
+}
+
+// WithMetaVersion возвращает копию драйвера, читающую служебные таблицы версии version.
+// Вход: версия служебных таблиц в БД (0 — таблицы <table>_meta ещё нет).
+// Выход: драйвер, методы чтения которого подставляют значения по умолчанию вместо
+// колонок более поздних шагов, а журнал до шага 3 читают пустым.
+// Назначение: status, plan, history и script на базе, которую ещё не обновили.
+// WithMetaVersion returns a copy of the driver reading bookkeeping tables of version version.
+// Input: bookkeeping table version in the database (0 means no <table>_meta yet).
+// Output: a driver whose read methods substitute defaults for columns of later steps
+// and read the journal as empty before step 3.
+// Purpose: status, plan, history and script on a database that was not upgraded yet.
+func (d *Driver) WithMetaVersion(version int) lamigrate.Driver {
+	reader := *d
+	reader.metaVersion = version
+	reader.pinned = true
+	return &reader
+}
+
+// hasMeta сообщает, есть ли в читаемых таблицах изменения шага version.
+// hasMeta reports whether the tables being read have step version's changes.
+func (d *Driver) hasMeta(version int) bool {
+	return !d.pinned || d.metaVersion >= version
+}
+
+// appliedColumns возвращает колонки AppliedMigrations с константами вместо ещё не добавленных.
+// appliedColumns returns the AppliedMigrations columns with constants for ones not added yet.
+func (d *Driver) appliedColumns() string {
+	columns := []string{"migration", "stage", "executed_at", "NULL", "'apply'", "0", "''", "''", "''"}
+	if d.hasMeta(1) {
+		columns[3] = "checksum"
+	}
+	if d.hasMeta(2) {
+		columns[4] = "origin"
+	}
+	if d.hasMeta(4) {
+		copy(columns[5:], []string{"duration_ms", "db_user", "hostname", "lamigrate_version"})
+	}
+	return strings.Join(columns, ", ")
+}
+
+// tableName возвращает имя таблицы истории без схемы и кавычек.
+// tableName returns the history table name without schema and quotes.
+func (d *Driver) tableName() string {
+	if d.table == "" {
+		return "lamigrate"
+	}
+	return d.table
+}
+
+// historyTable возвращает экранированное имя таблицы истории со схемой.
+// Вход: нет.
+// Выход: "schema"."table" или "table".
+// Назначение: подставлять таблицу истории во все запросы.
+// historyTable returns the quoted history table name with schema.
+// Input: none.
+// Output: "schema"."table" or "table".
+// Purpose: embed the history table into every query.
+func (d *Driver) historyTable() string {
+	if d.schema == "" {
+		return quoteIdent(d.tableName())
+	}
+	return quoteIdent(d.schema) + "." + quoteIdent(d.tableName())
+}
+
+// journalTable возвращает экранированное имя таблицы журнала (<table>_history) со схемой.
+// journalTable returns the quoted journal table name (<table>_history) with schema.
+func (d *Driver) journalTable() string {
+	name := quoteIdent(d.tableName() + lamigrate.JournalTableSuffix)
+	if d.schema == "" {
+		return name
+	}
+	return quoteIdent(d.schema) + "." + name
+}
+
+// metaTable возвращает экранированное имя таблицы версий (<table>_meta) со схемой.
+// metaTable returns the quoted version table name (<table>_meta) with schema.
+func (d *Driver) metaTable() string {
+	name := quoteIdent(d.tableName() + lamigrate.MetaTableSuffix)
+	if d.schema == "" {
+		return name
+	}
+	return quoteIdent(d.schema) + "." + name
+}
+
 // Open открывает подключение к Postgres.
 // Вход: строка DSN.
 // Выход: *sql.DB или error.
@@ -62,50 +207,512 @@ func (d *Driver) Open(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-// EnsureSchema создаёт таблицу lamigrate, если её нет.
-// Вход: ctx для отмены, db соединение.
-// Выход: error при ошибке создания.
-// Назначение: подготовить хранилище стадий.
-// EnsureSchema creates lamigrate table if missing.
-// Input: ctx for cancellation, db connection.
-// Output: error on creation failure.
-// Purpose: prepare storage for stages.
-func (d *Driver) EnsureSchema(ctx context.Context, db *sql.DB) error {
-	query := `
-CREATE TABLE IF NOT EXISTS lamigrate (
-	id BIGSERIAL PRIMARY KEY,
-	migration TEXT NOT NULL UNIQUE,
-	stage INT NOT NULL,
-	executed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);`
-	_, err := db.ExecContext(ctx, query)
+// lockClassID — первая часть ключа advisory-блокировки ("lami").
+// lockClassID is the first half of the advisory lock key ("lami").
+const lockClassID = 0x6c616d69
+
+// lockPollInterval — пауза между попытками взять блокировку.
+// lockPollInterval is the pause between lock attempts.
+const lockPollInterval = 500 * time.Millisecond
+
+// lockObjectID вычисляет вторую часть ключа advisory-блокировки.
+// Вход: нет.
+// Выход: положительный int32 из схемы и имени таблицы истории.
+// Назначение: разделить блокировки разных таблиц истории.
+// lockObjectID computes the second half of the advisory lock key.
+// Input: none.
+// Output: positive int32 derived from the history table schema and name.
+// Purpose: separate locks of different history tables.
+func (d *Driver) lockObjectID() int64 {
+	key := d.tableName()
+	if d.schema != "" {
+		key = d.schema + "." + key
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int64(hash.Sum32() & 0x7fffffff)
+}
+
+// Lock берёт сессионную advisory-блокировку миграций.
+// Вход: ctx для отмены, db соединение, timeout ожидания (0 — пока не отменён ctx).
+// Выход: выделенное соединение, держащее блокировку, или error с описанием
+// блокирующей сессии (оборачивает lamigrate.ErrLocked); lamigrate.ErrSingleConnection,
+// если пул ограничен одним соединением.
+// Назначение: не дать двум процессам одновременно применять миграции. Блокировка живёт
+// в сессии, поэтому соединение занято до Unlock, а миграции идут через другие.
+// Lock takes the session-level advisory migration lock.
+// Input: ctx for cancellation, db connection, wait timeout (0 waits until ctx is done).
+// Output: dedicated connection holding the lock, or error naming the blocking
+// session (wraps lamigrate.ErrLocked); lamigrate.ErrSingleConnection when the pool
+// is limited to one connection.
+// Purpose: prevent two processes from migrating at once. The lock lives in the session,
+// so the connection is taken until Unlock and migrations run on other ones.
+func (d *Driver) Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (*sql.Conn, error) {
+	if db.Stats().MaxOpenConnections == 1 {
+		return nil, fmt.Errorf("%w (SetMaxOpenConns is 1)", lamigrate.ErrSingleConnection)
+	}
+	conn, err := db.Conn(ctx)
 	if err != nil {
-		return fmt.Errorf("create lamigrate table: %w", err)
+		return nil, err
+	}
+
+	start := time.Now()
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, $2)`, lockClassID, d.lockObjectID()).Scan(&locked); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if locked {
+			return conn, nil
+		}
+
+		waited := time.Since(start)
+		if timeout > 0 && waited >= timeout {
+			holder := d.lockHolder(ctx, conn)
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s (waited %s)", lamigrate.ErrLocked, holder, waited.Truncate(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			holder := d.lockHolder(context.WithoutCancel(ctx), conn)
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s: %w", lamigrate.ErrLocked, holder, ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
 	}
-	_, err = db.ExecContext(ctx, `ALTER TABLE lamigrate ADD COLUMN IF NOT EXISTS executed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`)
+}
+
+// Unlock освобождает advisory-блокировку и возвращает соединение в пул.
+// Вход: ctx для отмены, пул (не используется), соединение из Lock.
+// Выход: error при ошибке освобождения.
+// Назначение: завершить критическую секцию миграций.
+// Unlock releases the advisory lock and returns the connection to the pool.
+// Input: ctx for cancellation, pool (unused), connection from Lock.
+// Output: error on release failure.
+// Purpose: finish the migrations critical section.
+func (d *Driver) Unlock(ctx context.Context, _ *sql.DB, conn *sql.Conn) error {
+	defer conn.Close()
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, $2)`, lockClassID, d.lockObjectID())
+	return err
+}
+
+// lockHolder описывает сессию, которая держит блокировку миграций.
+// Вход: ctx для отмены, соединение для запроса.
+// Выход: строка с pid, пользователем, приложением, адресом и временем начала.
+// Назначение: понятная ошибка при конкурентном запуске.
+// lockHolder describes the session holding the migration lock.
+// Input: ctx for cancellation, connection to query with.
+// Output: string with pid, user, application, address and start time.
+// Purpose: a clear error for concurrent runs.
+func (d *Driver) lockHolder(ctx context.Context, conn *sql.Conn) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var (
+		pid          int
+		user         string
+		application  string
+		client       string
+		backendStart time.Time
+		state        string
+	)
+	err := conn.QueryRowContext(ctx, `
+SELECT a.pid, COALESCE(a.usename, ''), COALESCE(a.application_name, ''),
+	COALESCE(host(a.client_addr), 'local'), a.backend_start, COALESCE(a.state, '')
+FROM pg_locks l
+JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.classid = $1 AND l.objid = $2 AND l.objsubid = 2 AND l.granted
+LIMIT 1`, lockClassID, d.lockObjectID()).Scan(&pid, &user, &application, &client, &backendStart, &state)
 	if err != nil {
-		return fmt.Errorf("add lamigrate executed_at column: %w", err)
+		return "blocking session is unknown"
+	}
+
+	parts := []string{fmt.Sprintf("pid %d", pid)}
+	if user != "" {
+		parts = append(parts, "user "+user)
+	}
+	if application != "" {
+		parts = append(parts, "application "+application)
+	}
+	parts = append(parts, "client "+client)
+	if state != "" {
+		parts = append(parts, "state "+state)
+	}
+	parts = append(parts, "connected since "+backendStart.Format(time.RFC3339))
+	return "blocking session " + strings.Join(parts, ", ")
+}
+
+// column — колонка таблицы истории, добавляемая шагом обновления.
+// column is a history table column added by an upgrade step.
+type column struct{ name, definition string }
+
+// MetaUpgrades возвращает шаги создания и обновления служебных таблиц.
+// Вход: нет.
+// Выход: шаги по порядку версий; новые шаги только дописываются в конец.
+// Назначение: схема lamigrate обновляется один раз под блокировкой, а не на каждом запуске.
+// Вся DDL шагов написана с IF NOT EXISTS, поэтому Apply выполняет тот же SQL, что попадает
+// в офлайн-скрипт, а базы старых версий без <table>_meta проходят все шаги.
+// MetaUpgrades returns the steps creating and upgrading the bookkeeping tables.
+// Input: none.
+// Output: steps in version order; new steps are only appended.
+// Purpose: the lamigrate schema is upgraded once under the lock instead of on every run.
+// All step DDL uses IF NOT EXISTS, so Apply runs the same SQL that goes into an offline
+// script, and databases of older versions without <table>_meta go through all steps.
+func (d *Driver) MetaUpgrades() []lamigrate.MetaUpgrade {
+	steps := []lamigrate.MetaUpgrade{
+		{Version: 1, Description: "create history and metadata tables", Script: d.historyTableDDL()},
+		{Version: 2, Description: "add origin column", Script: d.addColumnsDDL(
+			column{"origin", "TEXT NOT NULL DEFAULT 'apply'"},
+		)},
+		{Version: 3, Description: "create journal table", Script: []string{d.journalTableDDL()}},
+		{Version: 4, Description: "add duration_ms, db_user, hostname and lamigrate_version columns", Script: d.addColumnsDDL(
+			column{"duration_ms", "BIGINT NOT NULL DEFAULT 0"},
+			column{"db_user", "TEXT NOT NULL DEFAULT ''"},
+			column{"hostname", "TEXT NOT NULL DEFAULT ''"},
+			column{"lamigrate_version", "TEXT NOT NULL DEFAULT ''"},
+		)},
+	}
+	for i := range steps {
+		steps[i].Apply = execStatements(steps[i].Script)
+	}
+	return steps
+}
+
+// execStatements возвращает шаг, выполняющий SQL-команды по порядку в транзакции шага.
+// execStatements returns a step running SQL statements in order within the step transaction.
+func execStatements(statements []string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// historyTableDDL возвращает SQL шага 1: схема (если задана), таблица истории и <table>_meta.
+// Вход: нет.
+// Выход: SQL-команды по порядку.
+// Назначение: заодно добавляет executed_at и checksum и переносит executed_date в
+// executed_at у таблиц самых старых версий.
+// historyTableDDL returns step 1 SQL: the schema (if set), the history table and <table>_meta.
+// Input: none.
+// Output: SQL statements in order.
+// Purpose: also adds executed_at and checksum and moves executed_date into executed_at
+// for tables of the oldest versions.
+func (d *Driver) historyTableDDL() []string {
+	table := d.historyTable()
+	var statements []string
+	if d.schema != "" {
+		statements = append(statements, "CREATE SCHEMA IF NOT EXISTS "+quoteIdent(d.schema))
+	}
+
+	schema := "current_schema()"
+	if d.schema != "" {
+		schema = quoteLiteral(d.schema)
 	}
-	_, err = db.ExecContext(ctx, `
-DO $$
+	return append(statements,
+		fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	migration TEXT NOT NULL UNIQUE,
+	stage INT NOT NULL,
+	executed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	checksum TEXT
+);`, table),
+		`ALTER TABLE `+table+` ADD COLUMN IF NOT EXISTS executed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+		`ALTER TABLE `+table+` ADD COLUMN IF NOT EXISTS checksum TEXT`,
+		fmt.Sprintf(`
+DO $lamigrate$
 BEGIN
 	IF EXISTS (
 		SELECT 1
 		FROM information_schema.columns
-		WHERE table_name = 'lamigrate' AND column_name = 'executed_date'
+		WHERE table_schema = %s AND table_name = %s AND column_name = 'executed_date'
 	) THEN
-		UPDATE lamigrate
+		UPDATE %s
 		SET executed_at = executed_date
 		WHERE executed_at IS NULL AND executed_date IS NOT NULL;
 	END IF;
-END $$;
-`)
+END $lamigrate$;
+`, schema, quoteLiteral(d.tableName()), table),
+		fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	version INT PRIMARY KEY,
+	description TEXT NOT NULL,
+	lamigrate_version TEXT NOT NULL DEFAULT '',
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`, d.metaTable()),
+	)
+}
+
+// journalTableDDL возвращает SQL создания журнала <table>_history.
+// journalTableDDL returns SQL creating the <table>_history journal.
+func (d *Driver) journalTableDDL() string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	action TEXT NOT NULL,
+	migration TEXT NOT NULL,
+	stage INT NOT NULL,
+	checksum TEXT,
+	duration_ms BIGINT NOT NULL DEFAULT 0,
+	os_user TEXT NOT NULL DEFAULT '',
+	hostname TEXT NOT NULL DEFAULT '',
+	lamigrate_version TEXT NOT NULL DEFAULT '',
+	executed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`, d.journalTable())
+}
+
+// addColumnsDDL возвращает SQL добавления колонок в таблицу истории, если их нет.
+// addColumnsDDL returns SQL adding columns to the history table when missing.
+func (d *Driver) addColumnsDDL(columns ...column) []string {
+	statements := make([]string, 0, len(columns))
+	for _, column := range columns {
+		statements = append(statements, `ALTER TABLE `+d.historyTable()+` ADD COLUMN IF NOT EXISTS `+column.name+` `+column.definition)
+	}
+	return statements
+}
+
+// SchemaExists проверяет, существует ли таблица истории.
+// Вход: ctx для отмены, db соединение.
+// Выход: true, если таблица есть; error при ошибке запроса.
+// Назначение: строить план без создания таблицы.
+// SchemaExists reports whether the history table exists.
+// Input: ctx for cancellation, db connection.
+// Output: true if the table exists; error on query failure.
+// Purpose: build a plan without creating the table.
+func (d *Driver) SchemaExists(ctx context.Context, db *sql.DB) (bool, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, d.historyTable()).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// dropPhase — запрос, который по схеме ($1) возвращает готовые DROP для объектов,
+// которые текущая роль может удалить (владеет ими или входит в роль владельца);
+// запрос с keep получает ещё список таблиц ($2), которые нужно пропустить.
+// dropPhase is a query returning ready DROP statements for objects of a schema ($1)
+// the current role may drop (it owns them or is a member of the owner role); a query
+// with keep also gets a list of tables ($2) to skip.
+type dropPhase struct {
+	query string
+	keep  bool
+}
+
+// dropExtensionsPhase удаляет расширения схемы; выполняется только с DropOptions.Extensions.
+// dropExtensionsPhase drops the schema's extensions; it only runs with DropOptions.Extensions.
+var dropExtensionsPhase = dropPhase{query: `SELECT format('DROP EXTENSION IF EXISTS %I CASCADE', e.extname)
+FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+WHERE n.nspname = $1 AND pg_has_role(e.extowner, 'USAGE')`}
+
+// dropPhases выполняются по очереди, и каждая видит результат предыдущей:
+// последовательности, принадлежащие удалённым таблицам, уже исчезли.
+// Объекты расширений пропускаются: они уходят только вместе со своим расширением.
+// dropPhases run in order and each sees the previous one's result: sequences owned
+// by dropped tables are already gone. Extension members are skipped: they only go
+// away together with their extension.
+var dropPhases = []dropPhase{
+	{query: `SELECT format(CASE c.relkind WHEN 'm' THEN 'DROP MATERIALIZED VIEW IF EXISTS %I.%I CASCADE' ELSE 'DROP VIEW IF EXISTS %I.%I CASCADE' END, n.nspname, c.relname)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relkind IN ('v', 'm') AND pg_has_role(c.relowner, 'USAGE')
+	AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e')`},
+	{query: `SELECT format(CASE c.relkind WHEN 'f' THEN 'DROP FOREIGN TABLE IF EXISTS %I.%I CASCADE' ELSE 'DROP TABLE IF EXISTS %I.%I CASCADE' END, n.nspname, c.relname)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relkind IN ('r', 'p', 'f') AND NOT c.relispartition AND pg_has_role(c.relowner, 'USAGE')
+	AND NOT c.relname::text = ANY($2::text[])
+	AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e')`, keep: true},
+	{query: `SELECT format('DROP SEQUENCE IF EXISTS %I.%I CASCADE', n.nspname, c.relname)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relkind = 'S' AND pg_has_role(c.relowner, 'USAGE')
+	AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype IN ('a', 'i', 'e'))`},
+	{query: `SELECT format('DROP %s IF EXISTS %s CASCADE', CASE p.prokind WHEN 'p' THEN 'PROCEDURE' WHEN 'a' THEN 'AGGREGATE' ELSE 'FUNCTION' END, p.oid::regprocedure)
+FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE n.nspname = $1 AND pg_has_role(p.proowner, 'USAGE')
+	AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_proc'::regclass AND d.objid = p.oid AND d.deptype = 'e')`},
+	{query: `SELECT format(CASE t.typtype WHEN 'd' THEN 'DROP DOMAIN IF EXISTS %I.%I CASCADE' ELSE 'DROP TYPE IF EXISTS %I.%I CASCADE' END, n.nspname, t.typname)
+FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE n.nspname = $1 AND pg_has_role(t.typowner, 'USAGE')
+	AND (t.typtype IN ('e', 'd', 'r') OR (t.typtype = 'c' AND EXISTS (SELECT 1 FROM pg_class c WHERE c.oid = t.typrelid AND c.relkind = 'c')))
+	AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_type'::regclass AND d.objid = t.oid AND d.deptype = 'e')`},
+}
+
+// historyTablesQuery находит в схеме ($1) таблицы истории lamigrate по их колонкам.
+// historyTablesQuery finds lamigrate history tables in a schema ($1) by their columns.
+const historyTablesQuery = `SELECT c.relname
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relkind IN ('r', 'p')
+	AND (SELECT COUNT(*) FROM pg_attribute a WHERE a.attrelid = c.oid AND NOT a.attisdropped
+		AND a.attname IN ('migration', 'stage', 'executed_at')) = 3`
+
+// DropSchema очищает целевую схему для fresh одной транзакцией.
+// Вход: ctx для отмены, db соединение, options (расширения удаляются только с Extensions).
+// Выход: error, если схема не выбрана, отличается от схемы истории или DDL не выполнен;
+// при ошибке не удаляется ничего.
+// Со схемой истории (-schema) она и есть целевая: схема удаляется и создаётся заново
+// с тем же владельцем, журнал <table>_history переносится в новую схему.
+// Без неё удаляются представления, таблицы, последовательности, функции, процедуры и
+// типы current_schema, которые роль может удалить, кроме служебных таблиц lamigrate
+// (см. lamigrate.BookkeepingTables): история других сервисов и свой журнал остаются,
+// своя таблица истории и <table>_meta удаляются, чтобы fresh применил всё заново.
+// Назначение: fresh. Advisory-блокировка живёт в сессии и не затрагивается.
+// DropSchema clears the target schema for fresh in one transaction.
+// Input: ctx for cancellation, db connection, options (extensions are dropped only with Extensions).
+// Output: error if no schema is selected, it differs from the history schema or DDL fails;
+// on error nothing is dropped.
+// With a history schema (-schema) it is the target: the schema is dropped and created again
+// with the same owner, and the <table>_history journal is moved into the new schema.
+// Without one, the views, tables, sequences, functions, procedures and types of
+// current_schema the role may drop are dropped, except lamigrate bookkeeping tables
+// (see lamigrate.BookkeepingTables): other services' history and the own journal stay,
+// the own history table and <table>_meta are dropped so fresh re-applies everything.
+// Purpose: fresh. The advisory lock lives in the session and is not affected.
+func (d *Driver) DropSchema(ctx context.Context, db *sql.DB, options lamigrate.DropOptions) error {
+	return d.WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		var current sql.NullString
+		if err := tx.QueryRowContext(ctx, `SELECT current_schema()`).Scan(&current); err != nil {
+			return fmt.Errorf("read current schema: %w", err)
+		}
+		if d.schema == "" {
+			if !current.Valid || current.String == "" {
+				return fmt.Errorf("no current schema selected (check search_path)")
+			}
+			return d.dropObjects(ctx, tx, current.String, options)
+		}
+
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)`, d.schema).Scan(&exists); err != nil {
+			return fmt.Errorf("check schema %s: %w", d.schema, err)
+		}
+		if !exists {
+			return nil
+		}
+		if current.String != d.schema {
+			return fmt.Errorf("fresh recreates history schema %s, but migrations run in %q (set search_path to %s)", d.schema, current.String, d.schema)
+		}
+		return d.recreateSchema(ctx, tx, options)
+	})
+}
+
+// recreateSchema удаляет схему истории и создаёт её заново, сохраняя журнал.
+// Вход: ctx для отмены, транзакция DropSchema, options.
+// Выход: error, если в схеме есть расширения без options.Extensions, или ошибка DDL.
+// Назначение: схема переименовывается, новая создаётся с прежним владельцем, журнал
+// (вместе со своей последовательностью) переносится в неё, старая удаляется целиком.
+// Права и default privileges на схему не переносятся.
+// recreateSchema drops the history schema and creates it again, keeping the journal.
+// Input: ctx for cancellation, DropSchema transaction, options.
+// Output: error if the schema has extensions without options.Extensions, or a DDL error.
+// Purpose: the schema is renamed, a new one is created with the same owner, the journal
+// (with its sequence) is moved into it and the old one is dropped as a whole.
+// Grants and default privileges on the schema are not carried over.
+func (d *Driver) recreateSchema(ctx context.Context, tx *sql.Tx, options lamigrate.DropOptions) error {
+	if !options.Extensions {
+		extensions, err := queryStrings(ctx, tx, `SELECT e.extname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace WHERE n.nspname = $1 ORDER BY 1`, d.schema)
+		if err != nil {
+			return fmt.Errorf("list extensions of schema %s: %w", d.schema, err)
+		}
+		if len(extensions) > 0 {
+			return fmt.Errorf("schema %s has extensions %s; fresh drops them only when allowed (-drop-extensions)", d.schema, strings.Join(extensions, ", "))
+		}
+	}
+
+	var owner string
+	var journalExists bool
+	if err := tx.QueryRowContext(
+		ctx,
+		`SELECT nspowner::regrole::text, to_regclass($2) IS NOT NULL FROM pg_namespace WHERE nspname = $1`,
+		d.schema,
+		d.journalTable(),
+	).Scan(&owner, &journalExists); err != nil {
+		return fmt.Errorf("read schema %s: %w", d.schema, err)
+	}
+
+	old := quoteIdent(fmt.Sprintf("lamigrate_fresh_%d", time.Now().UnixNano()))
+	statements := []string{
+		"ALTER SCHEMA " + quoteIdent(d.schema) + " RENAME TO " + old,
+		"CREATE SCHEMA " + quoteIdent(d.schema) + " AUTHORIZATION " + owner,
+	}
+	if journalExists {
+		statements = append(statements, "ALTER TABLE "+old+"."+quoteIdent(d.tableName()+lamigrate.JournalTableSuffix)+" SET SCHEMA "+quoteIdent(d.schema))
+	}
+	statements = append(statements, "DROP SCHEMA "+old+" CASCADE")
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	return nil
+}
+
+// dropObjects удаляет объекты схемы по фазам, пропуская служебные таблицы lamigrate.
+// Вход: ctx для отмены, транзакция DropSchema, имя схемы, options.
+// Выход: error с упавшей командой.
+// Назначение: fresh без отдельной схемы истории, когда схема может быть общей.
+// dropObjects drops the schema's objects phase by phase, skipping lamigrate bookkeeping tables.
+// Input: ctx for cancellation, DropSchema transaction, schema name, options.
+// Output: error naming the failed statement.
+// Purpose: fresh without a separate history schema, when the schema may be shared.
+func (d *Driver) dropObjects(ctx context.Context, tx *sql.Tx, schema string, options lamigrate.DropOptions) error {
+	historyTables, err := queryStrings(ctx, tx, historyTablesQuery, schema)
 	if err != nil {
-		return fmt.Errorf("backfill lamigrate executed_at: %w", err)
+		return fmt.Errorf("find lamigrate tables in schema %s: %w", schema, err)
+	}
+	keep := make([]string, 0, 4*len(historyTables))
+	for name := range lamigrate.BookkeepingTables(historyTables) {
+		keep = append(keep, name)
+	}
+
+	phases := dropPhases
+	if options.Extensions {
+		phases = append([]dropPhase{dropExtensionsPhase}, dropPhases...)
+	}
+	for _, phase := range phases {
+		args := []any{schema}
+		if phase.keep {
+			args = append(args, pq.Array(keep))
+		}
+		statements, err := queryStrings(ctx, tx, phase.query, args...)
+		if err != nil {
+			return fmt.Errorf("list objects of schema %s: %w", schema, err)
+		}
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("%s: %w", statement, err)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+d.historyTable()+", "+d.metaTable()); err != nil {
+		return fmt.Errorf("drop %s table: %w", d.tableName(), err)
 	}
 	return nil
 }
 
+// queryStrings выполняет запрос, возвращающий одну текстовую колонку.
+// queryStrings runs a query returning a single text column.
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
 // AppliedMigrations возвращает применённые миграции, отсортированные по stage и id.
 // Вход: ctx для отмены, db соединение.
 // Выход: список AppliedMigration или error.
@@ -115,7 +722,7 @@ END $$;
 // Output: list of AppliedMigration or error.
 // Purpose: show status and detect pending migrations.
 func (d *Driver) AppliedMigrations(ctx context.Context, db *sql.DB) ([]lamigrate.AppliedMigration, error) {
-	rows, err := db.QueryContext(ctx, `SELECT migration, stage, executed_at FROM lamigrate ORDER BY stage ASC, id ASC`)
+	rows, err := db.QueryContext(ctx, `SELECT `+d.appliedColumns()+` FROM `+d.historyTable()+` ORDER BY stage ASC, id ASC`)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +733,11 @@ func (d *Driver) AppliedMigrations(ctx context.Context, db *sql.DB) ([]lamigrate
 		var migration string
 		var stage int
 		var executedAt sql.NullTime
-		if err := rows.Scan(&migration, &stage, &executedAt); err != nil {
+		var checksum sql.NullString
+		var origin sql.NullString
+		var durationMS int64
+		var dbUser, hostname, version string
+		if err := rows.Scan(&migration, &stage, &executedAt, &checksum, &origin, &durationMS, &dbUser, &hostname, &version); err != nil {
 			return nil, err
 		}
 
@@ -134,6 +745,12 @@ func (d *Driver) AppliedMigrations(ctx context.Context, db *sql.DB) ([]lamigrate
 			Migration:  migration,
 			Stage:      stage,
 			ExecutedAt: executedAt.Time,
+			Checksum:   checksum.String,
+			Origin:     lamigrate.Origin(origin.String),
+			Duration:   time.Duration(durationMS) * time.Millisecond,
+			DBUser:     dbUser,
+			Hostname:   hostname,
+			Version:    version,
 		})
 	}
 
@@ -154,7 +771,7 @@ func (d *Driver) AppliedMigrations(ctx context.Context, db *sql.DB) ([]lamigrate
 // Purpose: compute next stage for batch apply.
 func (d *Driver) MaxStage(ctx context.Context, db *sql.DB) (int, error) {
 	var maxStage sql.NullInt64
-	if err := db.QueryRowContext(ctx, `SELECT MAX(stage) FROM lamigrate`).Scan(&maxStage); err != nil {
+	if err := db.QueryRowContext(ctx, `SELECT MAX(stage) FROM `+d.historyTable()).Scan(&maxStage); err != nil {
 		return 0, err
 	}
 	if !maxStage.Valid {
@@ -172,7 +789,7 @@ func (d *Driver) MaxStage(ctx context.Context, db *sql.DB) (int, error) {
 // Output: list of stages (desc) or error.
 // Purpose: determine down rollback order.
 func (d *Driver) StagesDesc(ctx context.Context, db *sql.DB) ([]int, error) {
-	rows, err := db.QueryContext(ctx, `SELECT DISTINCT stage FROM lamigrate ORDER BY stage DESC`)
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT stage FROM `+d.historyTable()+` ORDER BY stage DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -201,7 +818,7 @@ func (d *Driver) StagesDesc(ctx context.Context, db *sql.DB) ([]int, error) {
 // Output: list of migration names or error.
 // Purpose: rollback a stage in reverse apply order.
 func (d *Driver) MigrationsByStage(ctx context.Context, db *sql.DB, stage int) ([]string, error) {
-	rows, err := db.QueryContext(ctx, `SELECT migration FROM lamigrate WHERE stage = $1 ORDER BY id DESC`, stage)
+	rows, err := db.QueryContext(ctx, `SELECT migration FROM `+d.historyTable()+` WHERE stage = $1 ORDER BY id DESC`, stage)
 	if err != nil {
 		return nil, err
 	}
@@ -244,19 +861,27 @@ func (d *Driver) WithTransaction(ctx context.Context, db *sql.DB, fn func(*sql.T
 }
 
 // InsertMigration записывает факт применения миграции.
-// Вход: ctx для отмены, tx транзакция, имя миграции, номер stage.
+// Вход: ctx для отмены, tx транзакция, запись (имя, stage, checksum up-файла, происхождение,
+// длительность, хост, версия; роль БД берётся из сессии).
 // Выход: error при ошибке вставки.
 // Назначение: сохранить информацию о применённой миграции.
 // InsertMigration records an applied migration.
-// Input: ctx for cancellation, tx transaction, migration name, stage number.
+// Input: ctx for cancellation, tx transaction, record (name, stage, up file checksum, origin,
+// duration, host, version; the database role comes from the session).
 // Output: error on insert failure.
 // Purpose: persist applied migration info.
-func (d *Driver) InsertMigration(ctx context.Context, tx *sql.Tx, migrationName string, stage int) error {
+func (d *Driver) InsertMigration(ctx context.Context, tx *sql.Tx, record lamigrate.MigrationRecord) error {
 	_, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO lamigrate (migration, stage, executed_at) VALUES ($1, $2, NOW())`,
-		migrationName,
-		stage,
+		`INSERT INTO `+d.historyTable()+` (migration, stage, executed_at, checksum, origin, duration_ms, db_user, hostname, lamigrate_version)
+VALUES ($1, $2, NOW(), NULLIF($3, ''), $4, $5, current_user, $6, $7)`,
+		record.Migration,
+		record.Stage,
+		record.Checksum,
+		string(record.Origin),
+		record.Duration.Milliseconds(),
+		record.Hostname,
+		record.Version,
 	)
 	return err
 }
@@ -272,8 +897,290 @@ func (d *Driver) InsertMigration(ctx context.Context, tx *sql.Tx, migrationName
 func (d *Driver) DeleteMigration(ctx context.Context, tx *sql.Tx, migrationName string) error {
 	_, err := tx.ExecContext(
 		ctx,
-		`DELETE FROM lamigrate WHERE migration = $1`,
+		`DELETE FROM `+d.historyTable()+` WHERE migration = $1`,
+		migrationName,
+	)
+	return err
+}
+
+// UpdateChecksum перезаписывает сохранённый checksum миграции.
+// Вход: ctx для отмены, tx транзакция, имя миграции, новый checksum.
+// Выход: error при ошибке обновления.
+// Назначение: принять изменённый файл как новую эталонную версию (repair).
+// UpdateChecksum rewrites the stored checksum of a migration.
+// Input: ctx for cancellation, tx transaction, migration name, new checksum.
+// Output: error on update failure.
+// Purpose: accept an edited file as the new reference version (repair).
+func (d *Driver) UpdateChecksum(ctx context.Context, tx *sql.Tx, migrationName string, checksum string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE `+d.historyTable()+` SET checksum = NULLIF($2, '') WHERE migration = $1`,
 		migrationName,
+		checksum,
+	)
+	return err
+}
+
+// InsertJournal добавляет запись в журнал.
+// Вход: ctx для отмены, tx транзакция изменения истории, запись журнала.
+// Выход: error при ошибке вставки.
+// Назначение: аудит, который переживает откат миграции.
+// InsertJournal appends a journal entry.
+// Input: ctx for cancellation, tx of the history change, journal entry.
+// Output: error on insert failure.
+// Purpose: an audit trail that survives migration rollbacks.
+func (d *Driver) InsertJournal(ctx context.Context, tx *sql.Tx, entry lamigrate.JournalEntry) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO `+d.journalTable()+` (action, migration, stage, checksum, duration_ms, os_user, hostname, lamigrate_version, executed_at)
+VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NOW())`,
+		string(entry.Action),
+		entry.Migration,
+		entry.Stage,
+		entry.Checksum,
+		entry.Duration.Milliseconds(),
+		entry.OSUser,
+		entry.Hostname,
+		entry.Version,
+	)
+	return err
+}
+
+// JournalEntries читает журнал по фильтру.
+// Вход: ctx для отмены, db соединение, фильтр.
+// Выход: записи от старых к новым (с Limit — последние Limit записей) или error.
+// Назначение: команда history.
+// JournalEntries reads the journal by a filter.
+// Input: ctx for cancellation, db connection, filter.
+// Output: entries from oldest to newest (with Limit, the latest Limit entries) or error.
+// Purpose: the history command.
+func (d *Driver) JournalEntries(ctx context.Context, db *sql.DB, filter lamigrate.JournalFilter) ([]lamigrate.JournalEntry, error) {
+	if !d.hasMeta(3) {
+		return nil, nil
+	}
+	where, args := filter.Where(
+		func(n int) string { return fmt.Sprintf("$%d", n) },
+		func(t time.Time) any { return t },
+	)
+	query := `SELECT id, action, migration, stage, checksum, duration_ms, os_user, hostname, lamigrate_version, executed_at FROM ` + d.journalTable()
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT * FROM (`+query+`) AS recent ORDER BY id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []lamigrate.JournalEntry
+	for rows.Next() {
+		var entry lamigrate.JournalEntry
+		var action string
+		var checksum sql.NullString
+		var durationMS int64
+		if err := rows.Scan(&entry.ID, &action, &entry.Migration, &entry.Stage, &checksum, &durationMS,
+			&entry.OSUser, &entry.Hostname, &entry.Version, &entry.ExecutedAt); err != nil {
+			return nil, err
+		}
+		entry.Action = lamigrate.JournalAction(action)
+		entry.Checksum = checksum.String
+		entry.Duration = time.Duration(durationMS) * time.Millisecond
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// MetaRecords читает применённые шаги обновления служебных таблиц.
+// Вход: ctx для отмены, db соединение.
+// Выход: записи по возрастанию версии (пусто, если <table>_meta нет) или error.
+// Назначение: узнать версию служебной схемы.
+// MetaRecords reads the applied upgrade steps of the bookkeeping tables.
+// Input: ctx for cancellation, db connection.
+// Output: records by ascending version (empty if <table>_meta is missing) or error.
+// Purpose: find out the bookkeeping schema version.
+func (d *Driver) MetaRecords(ctx context.Context, db *sql.DB) ([]lamigrate.MetaRecord, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, d.metaTable()).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, description, lamigrate_version, applied_at FROM `+d.metaTable()+` ORDER BY version ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []lamigrate.MetaRecord
+	for rows.Next() {
+		var record lamigrate.MetaRecord
+		if err := rows.Scan(&record.Version, &record.Description, &record.LamigrateVersion, &record.AppliedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// InsertMetaRecord записывает применённый шаг обновления.
+// Вход: ctx для отмены, tx транзакция шага, запись.
+// Выход: error при ошибке вставки.
+// Назначение: шаг и его запись фиксируются вместе.
+// InsertMetaRecord records an applied upgrade step.
+// Input: ctx for cancellation, tx of the step, record.
+// Output: error on insert failure.
+// Purpose: the step and its record commit together.
+func (d *Driver) InsertMetaRecord(ctx context.Context, tx *sql.Tx, record lamigrate.MetaRecord) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO `+d.metaTable()+` (version, description, lamigrate_version, applied_at) VALUES ($1, $2, $3, NOW())`,
+		record.Version,
+		record.Description,
+		record.LamigrateVersion,
 	)
 	return err
 }
+
+// DescribeError достаёт сведения об ошибке из *pq.Error.
+// Вход: ошибка выполнения SQL.
+// Выход: SQLSTATE, текст, detail, hint, constraint и позиция (номер символа с 1) или false.
+// Назначение: lamigrate переводит позицию в строку и колонку файла миграции.
+// DescribeError extracts error information from *pq.Error.
+// Input: SQL execution error.
+// Output: SQLSTATE, message, detail, hint, constraint and position (character number from 1) or false.
+// Purpose: lamigrate translates the position into a line and column of the migration file.
+func (d *Driver) DescribeError(err error) (lamigrate.DBError, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return lamigrate.DBError{}, false
+	}
+	position, _ := strconv.Atoi(pqErr.Position)
+	return lamigrate.DBError{
+		Code:       string(pqErr.Code),
+		Message:    pqErr.Message,
+		Detail:     pqErr.Detail,
+		Hint:       pqErr.Hint,
+		Constraint: pqErr.Constraint,
+		Position:   position,
+	}, true
+}
+
+// ScriptInsertMigration возвращает SQL записи миграции для офлайн-скрипта.
+// Вход: запись (имя, stage, checksum up-файла, происхождение).
+// Выход: SQL-команда INSERT с литералами.
+// Назначение: повторить InsertMigration в скрипте для DBA.
+// ScriptInsertMigration returns SQL recording a migration for an offline script.
+// Input: record (name, stage, up file checksum, origin).
+// Output: INSERT statement with literals.
+// Purpose: mirror InsertMigration in a script for DBAs.
+func (d *Driver) ScriptInsertMigration(record lamigrate.MigrationRecord) string {
+	checksumValue := "NULL"
+	if record.Checksum != "" {
+		checksumValue = quoteLiteral(record.Checksum)
+	}
+	return fmt.Sprintf(
+		"INSERT INTO %s (migration, stage, executed_at, checksum, origin, duration_ms, db_user, hostname, lamigrate_version) VALUES (%s, %d, NOW(), %s, %s, %d, current_user, %s, %s);",
+		d.historyTable(),
+		quoteLiteral(record.Migration),
+		record.Stage,
+		checksumValue,
+		quoteLiteral(string(record.Origin)),
+		record.Duration.Milliseconds(),
+		quoteLiteral(record.Hostname),
+		quoteLiteral(record.Version),
+	)
+}
+
+// ScriptDeleteMigration возвращает SQL удаления записи миграции для офлайн-скрипта.
+// Вход: имя миграции.
+// Выход: SQL-команда DELETE с литералом.
+// Назначение: повторить DeleteMigration в скрипте для DBA.
+// ScriptDeleteMigration returns SQL removing a migration record for an offline script.
+// Input: migration name.
+// Output: DELETE statement with a literal.
+// Purpose: mirror DeleteMigration in a script for DBAs.
+func (d *Driver) ScriptDeleteMigration(migrationName string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE migration = %s;", d.historyTable(), quoteLiteral(migrationName))
+}
+
+// ScriptInsertJournal возвращает SQL записи в журнал для офлайн-скрипта.
+// Вход: запись журнала.
+// Выход: SQL-команда INSERT с литералами.
+// Назначение: повторить InsertJournal в скрипте для DBA.
+// ScriptInsertJournal returns SQL appending a journal entry for an offline script.
+// Input: journal entry.
+// Output: INSERT statement with literals.
+// Purpose: mirror InsertJournal in a script for DBAs.
+func (d *Driver) ScriptInsertJournal(entry lamigrate.JournalEntry) string {
+	checksumValue := "NULL"
+	if entry.Checksum != "" {
+		checksumValue = quoteLiteral(entry.Checksum)
+	}
+	return fmt.Sprintf(
+		"INSERT INTO %s (action, migration, stage, checksum, duration_ms, os_user, hostname, lamigrate_version, executed_at) VALUES (%s, %s, %d, %s, %d, %s, %s, %s, NOW());",
+		d.journalTable(),
+		quoteLiteral(string(entry.Action)),
+		quoteLiteral(entry.Migration),
+		entry.Stage,
+		checksumValue,
+		entry.Duration.Milliseconds(),
+		quoteLiteral(entry.OSUser),
+		quoteLiteral(entry.Hostname),
+		quoteLiteral(entry.Version),
+	)
+}
+
+// ScriptInsertMetaRecord возвращает SQL записи шага обновления для офлайн-скрипта.
+// Вход: запись шага.
+// Выход: SQL-команда INSERT с литералами.
+// Назначение: повторить InsertMetaRecord в скрипте для DBA.
+// ScriptInsertMetaRecord returns SQL recording an upgrade step for an offline script.
+// Input: step record.
+// Output: INSERT statement with literals.
+// Purpose: mirror InsertMetaRecord in a script for DBAs.
+func (d *Driver) ScriptInsertMetaRecord(record lamigrate.MetaRecord) string {
+	return fmt.Sprintf(
+		"INSERT INTO %s (version, description, lamigrate_version, applied_at) VALUES (%d, %s, %s, NOW());",
+		d.metaTable(),
+		record.Version,
+		quoteLiteral(record.Description),
+		quoteLiteral(record.LamigrateVersion),
+	)
+}
+
+// quoteLiteral экранирует строку как SQL-литерал Postgres.
+// Вход: строка.
+// Выход: литерал в одинарных кавычках.
+// Назначение: безопасно подставлять значения в скрипт.
+// quoteLiteral escapes a string as a Postgres SQL literal.
+// Input: string.
+// Output: single-quoted literal.
+// Purpose: safely embed values into a script.
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// quoteIdent экранирует идентификатор Postgres двойными кавычками.
+// Вход: имя объекта.
+// Выход: идентификатор в двойных кавычках.
+// Назначение: безопасно подставлять имена схем и таблиц в SQL.
+// quoteIdent escapes a Postgres identifier with double quotes.
+// Input: object name.
+// Output: double-quoted identifier.
+// Purpose: safely embed schema and table names into SQL.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+func TestDescribeError(t *testing.T) {
+	pqErr := &pq.Error{
+		Code:       "23505",
+		Message:    `duplicate key value violates unique constraint "users_email_key"`,
+		Detail:     "Key (email)=(a@b.c) already exists.",
+		Hint:       "use another email",
+		Constraint: "users_email_key",
+		Position:   "17",
+	}
+	tests := []struct {
+		name string
+		err  error
+		want lamigrate.DBError
+		ok   bool
+	}{
+		{
+			name: "pq error",
+			err:  pqErr,
+			want: lamigrate.DBError{
+				Code:       "23505",
+				Message:    pqErr.Message,
+				Detail:     pqErr.Detail,
+				Hint:       pqErr.Hint,
+				Constraint: pqErr.Constraint,
+				Position:   17,
+			},
+			ok: true,
+		},
+		{
+			name: "wrapped pq error",
+			err:  fmt.Errorf("exec: %w", &pq.Error{Code: "42601", Message: "syntax error", Position: "5"}),
+			want: lamigrate.DBError{Code: "42601", Message: "syntax error", Position: 5},
+			ok:   true,
+		},
+		{
+			name: "no position",
+			err:  &pq.Error{Code: "42P01", Message: `relation "t" does not exist`},
+			want: lamigrate.DBError{Code: "42P01", Message: `relation "t" does not exist`},
+			ok:   true,
+		},
+		{
+			name: "not a database error",
+			err:  errors.New("connection refused"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := New().DescribeError(tt.err)
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("DescribeError() = %+v, %v; want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLockSingleConnection(t *testing.T) {
+	// Соединение не открывается: проверка пула идёт до него, поэтому сервер не нужен.
+	// No connection is opened: the pool check comes first, so no server is needed.
+	db, err := sql.Open("postgres", "postgres://lamigrate@127.0.0.1:1/lamigrate?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := New().Lock(context.Background(), db, time.Second); !errors.Is(err, lamigrate.ErrSingleConnection) {
+		t.Fatalf("Lock() on a one-connection pool = %v, want ErrSingleConnection", err)
+	}
+}
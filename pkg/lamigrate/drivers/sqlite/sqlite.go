@@ -0,0 +1,1173 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	// Регистрируем драйвер SQLite (чистый Go, без CGO).
+	// Register the SQLite driver (pure Go, no CGO).
+	_ "modernc.org/sqlite"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+// timeLayout — формат хранения executed_at в SQLite.
+// timeLayout is the storage format of executed_at in SQLite.
+const timeLayout = "2006-01-02 15:04:05"
+
+// lockPollInterval — пауза между попытками взять блокировку.
+// lockPollInterval is the pause between lock attempts.
+const lockPollInterval = 200 * time.Millisecond
+
+// Driver реализует драйвер миграций для SQLite.
+// Driver implements the SQLite migrations driver.
+type Driver struct {
+	table       string
+	metaVersion int
+	pinned      bool
+}
+
+// New создаёт новый экземпляр драйвера SQLite.
+// Вход: нет.
+// Выход: указатель на Driver.
+// Назначение: конструктор для регистрации в CLI.
+// New creates a new SQLite driver instance.
+// Input: none.
+// Output: pointer to Driver.
+// Purpose: constructor for CLI registration.
+func New() *Driver {
+	return &Driver{}
+}
+
+// init регистрирует драйвер SQLite в реестре lamigrate.
+// Назначение: подключать драйвер пустым импортом.
+// init registers the SQLite driver in the lamigrate registry.
+// Purpose: enable the driver with a blank import.
+func init() {
+	lamigrate.Register("sqlite", func() lamigrate.Driver { return New() })
+	lamigrate.RegisterScheme("sqlite3", "sqlite")
+}
+
+// Name возвращает имя драйвера.
+// Вход: нет.
+// Выход: строка имени драйвера.
+// Назначение: идентификация драйвера в CLI и конфигах.
+// Name returns the driver name.
+// Input: none.
+// Output: driver name string.
+// Purpose: identify the driver in CLI and configs.
+func (d *Driver) Name() string {
+	return "sqlite"
+}
+
+// TransactionalDDL сообщает, что DDL в SQLite выполняется внутри транзакции.
+// Вход: нет.
+// Выход: true.
+// Назначение: разрешить раннеру атомарно применять stage.
+// TransactionalDDL reports that DDL in SQLite runs inside a transaction.
+// Input: none.
+// Output: true.
+// Purpose: allow the runner to apply a stage atomically.
+func (d *Driver) TransactionalDDL() bool {
+	return true
+}
+
+// Open открывает файл базы SQLite.
+// Вход: DSN — путь к файлу, "file:..." или "sqlite://path".
+// Выход: *sql.DB или error.
+// Назначение: создать подключение для выполнения миграций.
+// Open opens an SQLite database file.
+// Input: DSN as a file path, "file:..." or "sqlite://path".
+// Output: *sql.DB or error.
+// Purpose: create a connection for running migrations.
+func (d *Driver) Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", normalizeDSN(dsn))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// normalizeDSN убирает схему sqlite:// из DSN и добавляет busy_timeout.
+// Вход: DSN из CLI или конфига.
+// Выход: строка, понятная modernc.org/sqlite.
+// Назначение: принимать DSN в виде URL наравне с путём к файлу и ждать
+// занятую БД вместо мгновенной ошибки SQLITE_BUSY на каждом соединении пула.
+// normalizeDSN strips the sqlite:// scheme from a DSN and adds busy_timeout.
+// Input: DSN from CLI or config.
+// Output: string understood by modernc.org/sqlite.
+// Purpose: accept URL-style DSNs as well as file paths and wait for a busy
+// database instead of failing with SQLITE_BUSY on every pool connection.
+func normalizeDSN(dsn string) string {
+	for _, prefix := range []string{"sqlite://", "sqlite3://"} {
+		if strings.HasPrefix(dsn, prefix) {
+			dsn = strings.TrimPrefix(dsn, prefix)
+			break
+		}
+	}
+	if strings.Contains(dsn, "busy_timeout") {
+		return dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "_pragma=busy_timeout(5000)"
+}
+
+// WithHistoryTable возвращает копию драйвера с другой таблицей истории.
+// Вход: схема (в SQLite только пустая или main) и имя таблицы (пустое — lamigrate).
+// Выход: настроенный драйвер или error для другой схемы или пустого после trim имени.
+// Назначение: несколько независимых сервисов в одном файле базы;
+// таблица блокировки получает имя <table>_lock.
+// WithHistoryTable returns a copy of the driver with another history table.
+// Input: schema (only empty or main in SQLite) and table name (empty means lamigrate).
+// Output: configured driver or error for another schema or a blank name.
+// Purpose: several independent services in one database file;
+// the lock table is named <table>_lock.
+func (d *Driver) WithHistoryTable(schema, table string) (lamigrate.Driver, error) {
+	if schema != "" && schema != "main" {
+		return nil, fmt.Errorf("sqlite: history table schema %q is not supported (use main or leave it empty)", schema)
+	}
+	if table != "" && strings.TrimSpace(table) == "" {
+		return nil, fmt.Errorf("sqlite: history table identifier is blank")
+	}
+	return &Driver{table: table}, nil
+}
+
+// WithMetaVersion возвращает копию драйвера, читающую служебные таблицы версии version.
+// Вход: версия служебных таблиц в БД (0 — таблицы <table>_meta ещё нет).
+// Выход: драйвер, методы чтения которого подставляют значения по умолчанию вместо
+// колонок более поздних шагов, а журнал до шага 3 читают пустым.
+// Назначение: status, plan, history и script на базе, которую ещё не обновили.
+// WithMetaVersion returns a copy of the driver reading bookkeeping tables of version version.
+// Input: bookkeeping table version in the database (0 means no <table>_meta yet).
+// Output: a driver whose read methods substitute defaults for columns of later steps
+// and read the journal as empty before step 3.
+// Purpose: status, plan, history and script on a database that was not upgraded yet.
+func (d *Driver) WithMetaVersion(version int) lamigrate.Driver {
+	reader := *d
+	reader.metaVersion = version
+	reader.pinned = true
+	return &reader
+}
+
+// hasMeta сообщает, есть ли в читаемых таблицах изменения шага version.
+// hasMeta reports whether the tables being read have step version's changes.
+func (d *Driver) hasMeta(version int) bool {
+	return !d.pinned || d.metaVersion >= version
+}
+
+// appliedColumns возвращает колонки AppliedMigrations с константами вместо ещё не добавленных.
+// appliedColumns returns the AppliedMigrations columns with constants for ones not added yet.
+func (d *Driver) appliedColumns() string {
+	columns := []string{"migration", "stage", "executed_at", "NULL", "'apply'", "0", "''", "''", "''"}
+	if d.hasMeta(1) {
+		columns[3] = "checksum"
+	}
+	if d.hasMeta(2) {
+		columns[4] = "origin"
+	}
+	if d.hasMeta(4) {
+		copy(columns[5:], []string{"duration_ms", "db_user", "hostname", "lamigrate_version"})
+	}
+	return strings.Join(columns, ", ")
+}
+
+// tableName возвращает имя таблицы истории без кавычек.
+// tableName returns the history table name without quotes.
+func (d *Driver) tableName() string {
+	if d.table == "" {
+		return "lamigrate"
+	}
+	return d.table
+}
+
+// journalTable возвращает экранированное имя таблицы журнала (<table>_history).
+// journalTable returns the quoted journal table name (<table>_history).
+func (d *Driver) journalTable() string {
+	return quoteIdent(d.tableName() + lamigrate.JournalTableSuffix)
+}
+
+// metaTable возвращает экранированное имя таблицы версий (<table>_meta).
+// metaTable returns the quoted version table name (<table>_meta).
+func (d *Driver) metaTable() string {
+	return quoteIdent(d.tableName() + lamigrate.MetaTableSuffix)
+}
+
+// lockTableName возвращает имя таблицы блокировки без кавычек.
+// lockTableName returns the lock table name without quotes.
+func (d *Driver) lockTableName() string {
+	return d.tableName() + lamigrate.LockTableSuffix
+}
+
+// Lock берёт блокировку миграций через строку в таблице <table>_lock.
+// Вход: ctx для отмены, db соединение, timeout ожидания (0 — пока не отменён ctx).
+// Выход: nil-соединение (блокировка живёт в таблице, а не в сессии) или error с владельцем
+// блокировки (оборачивает lamigrate.ErrLocked).
+// Назначение: не дать двум процессам одновременно применять миграции. Соединение
+// возвращается в пул сразу после вставки строки, поэтому хватает и SetMaxOpenConns(1).
+// В SQLite нет advisory-блокировок: строку упавшего процесса с этого же хоста
+// (pid больше не существует) Lock удаляет сам, с другого хоста — только вручную.
+// Lock takes the migration lock via a row in the <table>_lock table.
+// Input: ctx for cancellation, db connection, wait timeout (0 waits until ctx is done).
+// Output: a nil connection (the lock lives in a table, not in the session) or error naming
+// the lock owner (wraps lamigrate.ErrLocked).
+// Purpose: prevent two processes from migrating at once. The connection goes back to the
+// pool right after the row is inserted, so SetMaxOpenConns(1) is enough.
+// SQLite has no advisory locks: Lock removes the row of a crashed process on this host
+// (its pid no longer exists) by itself; a row from another host must be deleted by hand.
+func (d *Driver) Lock(ctx context.Context, db *sql.DB, timeout time.Duration) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	owner TEXT NOT NULL,
+	acquired_at TEXT NOT NULL
+);`, quoteIdent(d.lockTableName()))); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("create %s table: %w", d.lockTableName(), err)
+	}
+
+	owner := lockOwner()
+	start := time.Now()
+	for {
+		result, err := conn.ExecContext(
+			ctx,
+			`INSERT OR IGNORE INTO `+quoteIdent(d.lockTableName())+` (id, owner, acquired_at) VALUES (1, ?, ?)`,
+			owner,
+			time.Now().UTC().Format(timeLayout),
+		)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if inserted, err := result.RowsAffected(); err == nil && inserted == 1 {
+			return nil, conn.Close()
+		}
+		released, err := d.releaseStaleLock(ctx, conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if released {
+			continue
+		}
+
+		waited := time.Since(start)
+		if timeout > 0 && waited >= timeout {
+			holder := d.lockHolder(ctx, conn)
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s (waited %s)", lamigrate.ErrLocked, holder, waited.Truncate(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			holder := d.lockHolder(context.WithoutCancel(ctx), conn)
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s: %w", lamigrate.ErrLocked, holder, ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// Unlock удаляет строку блокировки.
+// Вход: ctx для отмены, пул, соединение из Lock (nil, не используется).
+// Выход: error при ошибке удаления.
+// Назначение: завершить критическую секцию миграций.
+// Unlock deletes the lock row.
+// Input: ctx for cancellation, pool, connection from Lock (nil, unused).
+// Output: error on delete failure.
+// Purpose: finish the migrations critical section.
+func (d *Driver) Unlock(ctx context.Context, db *sql.DB, _ *sql.Conn) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM `+quoteIdent(d.lockTableName())+` WHERE id = 1`)
+	return err
+}
+
+// lockHolder описывает владельца строки блокировки.
+// Вход: ctx для отмены, соединение для запроса.
+// Выход: строка с владельцем и временем захвата.
+// Назначение: понятная ошибка при конкурентном запуске.
+// lockHolder describes the owner of the lock row.
+// Input: ctx for cancellation, connection to query with.
+// Output: string with owner and acquisition time.
+// Purpose: a clear error for concurrent runs.
+func (d *Driver) lockHolder(ctx context.Context, conn *sql.Conn) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var owner, acquiredAt string
+	err := conn.QueryRowContext(ctx, `SELECT owner, acquired_at FROM `+quoteIdent(d.lockTableName())+` WHERE id = 1`).Scan(&owner, &acquiredAt)
+	if err != nil {
+		return "lock owner is unknown"
+	}
+	return fmt.Sprintf("lock owner %s, acquired at %s UTC (delete the %s row if that process is gone)", owner, acquiredAt, d.lockTableName())
+}
+
+// releaseStaleLock удаляет строку блокировки, если её владелец — завершившийся процесс этого хоста.
+// Вход: ctx для отмены, соединение для запросов.
+// Выход: true, если строка удалена и можно сразу повторить захват; error при ошибке запроса.
+// Назначение: упавший процесс не оставляет блокировку навсегда. Живость проверяется
+// только по pid на своём хосте; удаление условно по owner и acquired_at, поэтому
+// строку, которую успел перезахватить другой процесс, оно не тронет.
+// releaseStaleLock deletes the lock row if its owner is a finished process on this host.
+// Input: ctx for cancellation, connection to query with.
+// Output: true if the row was deleted and the lock can be retried at once; error on query failure.
+// Purpose: a crashed process does not keep the lock forever. Liveness is checked
+// only by pid on the same host; the delete is conditional on owner and acquired_at, so
+// it leaves alone a row another process has just re-acquired.
+func (d *Driver) releaseStaleLock(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var owner, acquiredAt string
+	err := conn.QueryRowContext(ctx, `SELECT owner, acquired_at FROM `+quoteIdent(d.lockTableName())+` WHERE id = 1`).Scan(&owner, &acquiredAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	hostname, pid, ok := parseLockOwner(owner)
+	if !ok || hostname != currentHostname() || pid == os.Getpid() || processAlive(pid) {
+		return false, nil
+	}
+	result, err := conn.ExecContext(
+		ctx,
+		`DELETE FROM `+quoteIdent(d.lockTableName())+` WHERE id = 1 AND owner = ? AND acquired_at = ?`,
+		owner,
+		acquiredAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("release stale %s row: %w", d.lockTableName(), err)
+	}
+	deleted, err := result.RowsAffected()
+	return err == nil && deleted == 1, nil
+}
+
+// historyTable возвращает экранированное имя таблицы истории.
+// historyTable returns the quoted history table name.
+func (d *Driver) historyTable() string {
+	return quoteIdent(d.tableName())
+}
+
+// lockOwner возвращает идентификатор текущего процесса.
+// Вход: нет.
+// Выход: строка "hostname pid N".
+// Назначение: показать владельца блокировки другим процессам.
+// lockOwner returns an identifier of the current process.
+// Input: none.
+// Output: "hostname pid N" string.
+// Purpose: show the lock owner to other processes.
+func lockOwner() string {
+	return fmt.Sprintf("%s pid %d", currentHostname(), os.Getpid())
+}
+
+// currentHostname возвращает имя хоста или "unknown", если его не удалось узнать.
+// currentHostname returns the host name or "unknown" if it cannot be determined.
+func currentHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// parseLockOwner разбирает строку владельца из lockOwner.
+// Вход: строка "hostname pid N".
+// Выход: имя хоста, pid и false, если строка в другом формате.
+// Назначение: понять, можно ли проверить живость владельца блокировки.
+// parseLockOwner parses an owner string produced by lockOwner.
+// Input: "hostname pid N" string.
+// Output: host name, pid and false if the string has another format.
+// Purpose: tell whether the lock owner's liveness can be checked.
+func parseLockOwner(owner string) (string, int, bool) {
+	i := strings.LastIndex(owner, " pid ")
+	if i < 0 {
+		return "", 0, false
+	}
+	pid, err := strconv.Atoi(owner[i+len(" pid "):])
+	if err != nil || pid <= 0 {
+		return "", 0, false
+	}
+	return owner[:i], pid, true
+}
+
+// processAlive сообщает, существует ли процесс с таким pid на этом хосте.
+// Вход: pid.
+// Выход: false, только если процесс точно завершился.
+// Назначение: отличить упавшего владельца блокировки от работающего. Сигнал 0 ничего
+// не доставляет; чужой живой процесс даёт EPERM и считается живым. Там, где сигнал 0
+// не поддерживается (Windows), процесс считается живым, если FindProcess его нашёл.
+// processAlive reports whether a process with this pid exists on this host.
+// Input: pid.
+// Output: false only if the process has definitely finished.
+// Purpose: tell a crashed lock owner from a running one. Signal 0 delivers nothing;
+// another user's live process yields EPERM and counts as alive. Where signal 0
+// is unsupported (Windows), a process counts as alive if FindProcess found it.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return !errors.Is(process.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// column — колонка таблицы истории, добавляемая шагом обновления.
+// column is a history table column added by an upgrade step.
+type column struct{ name, definition string }
+
+// MetaUpgrades возвращает шаги создания и обновления служебных таблиц.
+// Вход: нет.
+// Выход: шаги по порядку версий; новые шаги только дописываются в конец.
+// Назначение: схема lamigrate обновляется один раз под блокировкой, а не на каждом запуске.
+// Шаги идемпотентны: базы старых версий без <table>_meta проходят их все.
+// MetaUpgrades returns the steps creating and upgrading the bookkeeping tables.
+// Input: none.
+// Output: steps in version order; new steps are only appended.
+// Purpose: the lamigrate schema is upgraded once under the lock instead of on every run.
+// Steps are idempotent: databases of older versions without <table>_meta go through all of them.
+func (d *Driver) MetaUpgrades() []lamigrate.MetaUpgrade {
+	origin := []column{
+		{"origin", "TEXT NOT NULL DEFAULT 'apply'"},
+	}
+	details := []column{
+		{"duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+		{"db_user", "TEXT NOT NULL DEFAULT ''"},
+		{"hostname", "TEXT NOT NULL DEFAULT ''"},
+		{"lamigrate_version", "TEXT NOT NULL DEFAULT ''"},
+	}
+	history := d.historyTableDDL()
+	journal := []string{d.journalTableDDL()}
+	return []lamigrate.MetaUpgrade{
+		{Version: 1, Description: "create history and metadata tables", Apply: execStatements(history), Script: history},
+		{Version: 2, Description: "add origin column", Apply: d.addColumns(origin...), Script: d.addColumnsDDL(origin...)},
+		{Version: 3, Description: "create journal table", Apply: execStatements(journal), Script: journal},
+		{Version: 4, Description: "add duration_ms, db_user, hostname and lamigrate_version columns", Apply: d.addColumns(details...), Script: d.addColumnsDDL(details...)},
+	}
+}
+
+// historyTableDDL возвращает SQL шага 1: таблица истории и <table>_meta.
+// historyTableDDL returns step 1 SQL: the history table and <table>_meta.
+func (d *Driver) historyTableDDL() []string {
+	return []string{
+		fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	migration TEXT NOT NULL UNIQUE,
+	stage INTEGER NOT NULL,
+	executed_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	checksum TEXT
+);`, d.historyTable()),
+		fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	lamigrate_version TEXT NOT NULL DEFAULT '',
+	applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`, d.metaTable()),
+	}
+}
+
+// journalTableDDL возвращает SQL создания журнала <table>_history.
+// journalTableDDL returns SQL creating the <table>_history journal.
+func (d *Driver) journalTableDDL() string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	action TEXT NOT NULL,
+	migration TEXT NOT NULL,
+	stage INTEGER NOT NULL,
+	checksum TEXT,
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	os_user TEXT NOT NULL DEFAULT '',
+	hostname TEXT NOT NULL DEFAULT '',
+	lamigrate_version TEXT NOT NULL DEFAULT '',
+	executed_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`, d.journalTable())
+}
+
+// execStatements возвращает шаг, выполняющий SQL-команды по порядку.
+// execStatements returns a step running SQL statements in order.
+func execStatements(statements []string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns возвращает шаг, добавляющий колонки в таблицу истории, если их нет.
+// В SQLite нет ADD COLUMN IF NOT EXISTS, поэтому колонка ищется в pragma_table_info;
+// офлайн-скрипт получает те же ALTER TABLE без проверки (addColumnsDDL).
+// addColumns returns a step adding columns to the history table when missing.
+// SQLite has no ADD COLUMN IF NOT EXISTS, so the column is looked up in pragma_table_info;
+// an offline script gets the same ALTER TABLE without the check (addColumnsDDL).
+func (d *Driver) addColumns(columns ...column) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, column := range columns {
+			var count int
+			if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, d.tableName(), column.name).Scan(&count); err != nil {
+				return fmt.Errorf("check %s %s column: %w", d.tableName(), column.name, err)
+			}
+			if count > 0 {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, d.addColumnDDL(column)); err != nil {
+				return fmt.Errorf("add %s %s column: %w", d.tableName(), column.name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// addColumnsDDL возвращает ALTER TABLE для каждой колонки (база ровно предыдущей версии).
+// addColumnsDDL returns ALTER TABLE for each column (for a database exactly at the previous version).
+func (d *Driver) addColumnsDDL(columns ...column) []string {
+	statements := make([]string, 0, len(columns))
+	for _, column := range columns {
+		statements = append(statements, d.addColumnDDL(column))
+	}
+	return statements
+}
+
+// addColumnDDL возвращает ALTER TABLE добавления одной колонки в таблицу истории.
+// addColumnDDL returns ALTER TABLE adding one column to the history table.
+func (d *Driver) addColumnDDL(column column) string {
+	return `ALTER TABLE ` + d.historyTable() + ` ADD COLUMN ` + column.name + ` ` + column.definition
+}
+
+// SchemaExists проверяет, существует ли таблица истории.
+// Вход: ctx для отмены, db соединение.
+// Выход: true, если таблица есть; error при ошибке запроса.
+// Назначение: строить план без создания таблицы.
+// SchemaExists reports whether the history table exists.
+// Input: ctx for cancellation, db connection.
+// Output: true if the table exists; error on query failure.
+// Purpose: build a plan without creating the table.
+func (d *Driver) SchemaExists(ctx context.Context, db *sql.DB) (bool, error) {
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, d.tableName()).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// historyTablesQuery находит таблицы истории lamigrate по их колонкам.
+// historyTablesQuery finds lamigrate history tables by their columns.
+const historyTablesQuery = `SELECT m.name FROM sqlite_master m
+WHERE m.type = 'table' AND (SELECT COUNT(*) FROM pragma_table_info(m.name) p
+	WHERE p.name IN ('migration', 'stage', 'executed_at')) = 3`
+
+// DropSchema удаляет таблицы и представления базы одной транзакцией, кроме служебных таблиц lamigrate.
+// Вход: ctx для отмены, db соединение, options (у SQLite нет расширений, Extensions не влияет).
+// Выход: error при ошибке DDL; при ошибке не удаляется ничего.
+// Назначение: fresh. Отдельной схемы в SQLite нет, поэтому база не пересоздаётся.
+// Таблицы истории других сервисов с их <table>_meta, журналом и блокировкой остаются
+// (см. lamigrate.BookkeepingTables); своя <table>_lock остаётся, потому что fresh держит
+// в ней блокировку, и журнал <table>_history — чтобы аудит пережил fresh; своя таблица
+// истории и <table>_meta удаляются. Индексы и триггеры уходят вместе со своими таблицами.
+// DropSchema drops the database's tables and views in one transaction, except lamigrate bookkeeping tables.
+// Input: ctx for cancellation, db connection, options (SQLite has no extensions, Extensions has no effect).
+// Output: error on DDL failure; on error nothing is dropped.
+// Purpose: fresh. SQLite has no separate schema, so the database is not recreated.
+// Other services' history tables with their <table>_meta, journal and lock stay
+// (see lamigrate.BookkeepingTables); the own <table>_lock is kept because fresh holds
+// its lock there, and the <table>_history journal so the audit trail survives fresh; the
+// own history table and <table>_meta are dropped. Indexes and triggers go away together
+// with their tables.
+func (d *Driver) DropSchema(ctx context.Context, db *sql.DB, options lamigrate.DropOptions) (err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	historyTables, err := queryStrings(ctx, conn, historyTablesQuery)
+	if err != nil {
+		return fmt.Errorf("find lamigrate tables: %w", err)
+	}
+	keep := lamigrate.BookkeepingTables(historyTables)
+	keep[d.lockTableName()] = true
+	keep[d.tableName()+lamigrate.JournalTableSuffix] = true
+	delete(keep, d.tableName())
+	delete(keep, d.tableName()+lamigrate.MetaTableSuffix)
+
+	rows, err := conn.QueryContext(ctx, `
+SELECT name, type FROM sqlite_master
+WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%'
+ORDER BY CASE type WHEN 'view' THEN 0 ELSE 1 END, name`)
+	if err != nil {
+		return fmt.Errorf("list tables: %w", err)
+	}
+	var statements []string
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if kind == "view" {
+			statements = append(statements, "DROP VIEW IF EXISTS "+quoteIdent(name))
+		} else if !keep[name] {
+			statements = append(statements, "DROP TABLE IF EXISTS "+quoteIdent(name))
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// PRAGMA foreign_keys внутри транзакции не действует, поэтому выключается до BEGIN.
+	// PRAGMA foreign_keys has no effect inside a transaction, so it is turned off before BEGIN.
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return fmt.Errorf("disable foreign keys: %w", err)
+	}
+	defer func() {
+		if restoreErr := restoreSession(context.WithoutCancel(ctx), conn, `PRAGMA foreign_keys = ON`); restoreErr != nil && err == nil {
+			err = restoreErr
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// restoreSession возвращает настройку сессии после DropSchema.
+// Вход: ctx для отмены, соединение DropSchema, команда настройки.
+// Выход: error, если команда не выполнилась; соединение тогда выбрасывается из пула,
+// чтобы следующий запрос не получил его с выключенными проверками внешних ключей.
+// Назначение: отложенный вызов в DropSchema.
+// restoreSession restores a session setting after DropSchema.
+// Input: ctx for cancellation, DropSchema connection, setting statement.
+// Output: error if the statement fails; the connection is then discarded from the pool
+// so the next query does not get it with foreign key checks turned off.
+// Purpose: the deferred call in DropSchema.
+func restoreSession(ctx context.Context, conn *sql.Conn, statement string) error {
+	if _, err := conn.ExecContext(ctx, statement); err != nil {
+		_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		return fmt.Errorf("%s: %w", statement, err)
+	}
+	return nil
+}
+
+// queryStrings выполняет запрос, возвращающий одну текстовую колонку.
+// queryStrings runs a query returning a single text column.
+func queryStrings(ctx context.Context, conn *sql.Conn, query string, args ...any) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// AppliedMigrations возвращает применённые миграции, отсортированные по stage и id.
+// Вход: ctx для отмены, db соединение.
+// Выход: список AppliedMigration или error.
+// Назначение: показать статус и проверить, что ещё не выполнено.
+// AppliedMigrations returns applied migrations ordered by stage and id.
+// Input: ctx for cancellation, db connection.
+// Output: list of AppliedMigration or error.
+// Purpose: show status and detect pending migrations.
+func (d *Driver) AppliedMigrations(ctx context.Context, db *sql.DB) ([]lamigrate.AppliedMigration, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+d.appliedColumns()+` FROM `+d.historyTable()+` ORDER BY stage ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []lamigrate.AppliedMigration
+	for rows.Next() {
+		var migration string
+		var stage int
+		var executedAt sql.NullString
+		var checksum sql.NullString
+		var origin sql.NullString
+		var durationMS int64
+		var dbUser, hostname, version string
+		if err := rows.Scan(&migration, &stage, &executedAt, &checksum, &origin, &durationMS, &dbUser, &hostname, &version); err != nil {
+			return nil, err
+		}
+
+		applied = append(applied, lamigrate.AppliedMigration{
+			Migration:  migration,
+			Stage:      stage,
+			ExecutedAt: parseTime(executedAt.String),
+			Checksum:   checksum.String,
+			Origin:     lamigrate.Origin(origin.String),
+			Duration:   time.Duration(durationMS) * time.Millisecond,
+			DBUser:     dbUser,
+			Hostname:   hostname,
+			Version:    version,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// parseTime разбирает executed_at, сохранённый SQLite.
+// Вход: строка времени в UTC.
+// Выход: time.Time (нулевое значение, если формат неизвестен).
+// Назначение: вернуть время применения в status.
+// parseTime parses executed_at stored by SQLite.
+// Input: time string in UTC.
+// Output: time.Time (zero value if the format is unknown).
+// Purpose: return the apply time for status.
+func parseTime(value string) time.Time {
+	for _, layout := range []string{timeLayout, time.RFC3339Nano} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// MaxStage возвращает максимальный stage.
+// Вход: ctx для отмены, db соединение.
+// Выход: максимальный stage или 0 если записей нет; error при ошибке.
+// Назначение: вычислить следующий stage для batch apply.
+// MaxStage returns the maximum stage.
+// Input: ctx for cancellation, db connection.
+// Output: max stage or 0 if none; error on failure.
+// Purpose: compute next stage for batch apply.
+func (d *Driver) MaxStage(ctx context.Context, db *sql.DB) (int, error) {
+	var maxStage sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(stage) FROM `+d.historyTable()).Scan(&maxStage); err != nil {
+		return 0, err
+	}
+	if !maxStage.Valid {
+		return 0, nil
+	}
+	return int(maxStage.Int64), nil
+}
+
+// StagesDesc возвращает список стадий по убыванию.
+// Вход: ctx для отмены, db соединение.
+// Выход: список стадий по убыванию или error.
+// Назначение: определить порядок отката down-миграций.
+// StagesDesc returns stages in descending order.
+// Input: ctx for cancellation, db connection.
+// Output: list of stages (desc) or error.
+// Purpose: determine down rollback order.
+func (d *Driver) StagesDesc(ctx context.Context, db *sql.DB) ([]int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT stage FROM `+d.historyTable()+` ORDER BY stage DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stages []int
+	for rows.Next() {
+		var stage int
+		if err := rows.Scan(&stage); err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+// MigrationsByStage возвращает миграции для stage в обратном порядке.
+// Вход: ctx для отмены, db соединение, номер stage.
+// Выход: список имён миграций или error.
+// Назначение: откатывать stage в порядке, обратном применению.
+// MigrationsByStage returns migrations for a stage in reverse order.
+// Input: ctx for cancellation, db connection, stage number.
+// Output: list of migration names or error.
+// Purpose: rollback a stage in reverse apply order.
+func (d *Driver) MigrationsByStage(ctx context.Context, db *sql.DB, stage int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT migration FROM `+d.historyTable()+` WHERE stage = ? ORDER BY id DESC`, stage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var migrations []string
+	for rows.Next() {
+		var migration string
+		if err := rows.Scan(&migration); err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return migrations, nil
+}
+
+// WithTransaction выполняет функцию в транзакции.
+// Вход: ctx для отмены, db соединение, функция.
+// Выход: error при ошибке транзакции или функции.
+// Назначение: объединить несколько операций в одну атомарную.
+// WithTransaction runs a function inside a transaction.
+// Input: ctx for cancellation, db connection, function.
+// Output: error if transaction or function fails.
+// Purpose: group multiple operations into a single atomic unit.
+func (d *Driver) WithTransaction(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertMigration записывает факт применения миграции.
+// Вход: ctx для отмены, tx транзакция, запись (имя, stage, checksum up-файла, происхождение,
+// длительность, хост, версия; ролей в SQLite нет, db_user пустой).
+// Выход: error при ошибке вставки.
+// Назначение: сохранить информацию о применённой миграции.
+// InsertMigration records an applied migration.
+// Input: ctx for cancellation, tx transaction, record (name, stage, up file checksum, origin,
+// duration, host, version; SQLite has no roles, db_user is empty).
+// Output: error on insert failure.
+// Purpose: persist applied migration info.
+func (d *Driver) InsertMigration(ctx context.Context, tx *sql.Tx, record lamigrate.MigrationRecord) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO `+d.historyTable()+` (migration, stage, executed_at, checksum, origin, duration_ms, db_user, hostname, lamigrate_version)
+VALUES (?, ?, CURRENT_TIMESTAMP, NULLIF(?, ''), ?, ?, '', ?, ?)`,
+		record.Migration,
+		record.Stage,
+		record.Checksum,
+		string(record.Origin),
+		record.Duration.Milliseconds(),
+		record.Hostname,
+		record.Version,
+	)
+	return err
+}
+
+// DeleteMigration удаляет запись о миграции.
+// Вход: ctx для отмены, tx транзакция, имя миграции.
+// Выход: error при ошибке удаления.
+// Назначение: убрать отметку о применении при откате.
+// DeleteMigration removes a migration record.
+// Input: ctx for cancellation, tx transaction, migration name.
+// Output: error on delete failure.
+// Purpose: remove applied marker during rollback.
+func (d *Driver) DeleteMigration(ctx context.Context, tx *sql.Tx, migrationName string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM `+d.historyTable()+` WHERE migration = ?`, migrationName)
+	return err
+}
+
+// UpdateChecksum перезаписывает сохранённый checksum миграции.
+// Вход: ctx для отмены, tx транзакция, имя миграции, новый checksum.
+// Выход: error при ошибке обновления.
+// Назначение: принять изменённый файл как новую эталонную версию (repair).
+// UpdateChecksum rewrites the stored checksum of a migration.
+// Input: ctx for cancellation, tx transaction, migration name, new checksum.
+// Output: error on update failure.
+// Purpose: accept an edited file as the new reference version (repair).
+func (d *Driver) UpdateChecksum(ctx context.Context, tx *sql.Tx, migrationName string, checksum string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE `+d.historyTable()+` SET checksum = NULLIF(?, '') WHERE migration = ?`, checksum, migrationName)
+	return err
+}
+
+// InsertJournal добавляет запись в журнал.
+// Вход: ctx для отмены, tx транзакция изменения истории, запись журнала.
+// Выход: error при ошибке вставки.
+// Назначение: аудит, который переживает откат миграции.
+// InsertJournal appends a journal entry.
+// Input: ctx for cancellation, tx of the history change, journal entry.
+// Output: error on insert failure.
+// Purpose: an audit trail that survives migration rollbacks.
+func (d *Driver) InsertJournal(ctx context.Context, tx *sql.Tx, entry lamigrate.JournalEntry) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO `+d.journalTable()+` (action, migration, stage, checksum, duration_ms, os_user, hostname, lamigrate_version, executed_at)
+VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		string(entry.Action),
+		entry.Migration,
+		entry.Stage,
+		entry.Checksum,
+		entry.Duration.Milliseconds(),
+		entry.OSUser,
+		entry.Hostname,
+		entry.Version,
+	)
+	return err
+}
+
+// JournalEntries читает журнал по фильтру.
+// Вход: ctx для отмены, db соединение, фильтр.
+// Выход: записи от старых к новым (с Limit — последние Limit записей) или error.
+// Назначение: команда history. Время сравнивается строками в формате timeLayout (UTC).
+// JournalEntries reads the journal by a filter.
+// Input: ctx for cancellation, db connection, filter.
+// Output: entries from oldest to newest (with Limit, the latest Limit entries) or error.
+// Purpose: the history command. Times are compared as timeLayout strings (UTC).
+func (d *Driver) JournalEntries(ctx context.Context, db *sql.DB, filter lamigrate.JournalFilter) ([]lamigrate.JournalEntry, error) {
+	if !d.hasMeta(3) {
+		return nil, nil
+	}
+	where, args := filter.Where(
+		func(int) string { return "?" },
+		func(t time.Time) any { return t.Format(timeLayout) },
+	)
+	query := `SELECT id, action, migration, stage, checksum, duration_ms, os_user, hostname, lamigrate_version, executed_at FROM ` + d.journalTable()
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT * FROM (`+query+`) AS recent ORDER BY id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []lamigrate.JournalEntry
+	for rows.Next() {
+		var entry lamigrate.JournalEntry
+		var action, executedAt string
+		var checksum sql.NullString
+		var durationMS int64
+		if err := rows.Scan(&entry.ID, &action, &entry.Migration, &entry.Stage, &checksum, &durationMS,
+			&entry.OSUser, &entry.Hostname, &entry.Version, &executedAt); err != nil {
+			return nil, err
+		}
+		entry.Action = lamigrate.JournalAction(action)
+		entry.Checksum = checksum.String
+		entry.Duration = time.Duration(durationMS) * time.Millisecond
+		entry.ExecutedAt = parseTime(executedAt)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// MetaRecords читает применённые шаги обновления служебных таблиц.
+// Вход: ctx для отмены, db соединение.
+// Выход: записи по возрастанию версии (пусто, если <table>_meta нет) или error.
+// Назначение: узнать версию служебной схемы.
+// MetaRecords reads the applied upgrade steps of the bookkeeping tables.
+// Input: ctx for cancellation, db connection.
+// Output: records by ascending version (empty if <table>_meta is missing) or error.
+// Purpose: find out the bookkeeping schema version.
+func (d *Driver) MetaRecords(ctx context.Context, db *sql.DB) ([]lamigrate.MetaRecord, error) {
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, d.tableName()+lamigrate.MetaTableSuffix).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, description, lamigrate_version, applied_at FROM `+d.metaTable()+` ORDER BY version ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []lamigrate.MetaRecord
+	for rows.Next() {
+		var record lamigrate.MetaRecord
+		var appliedAt string
+		if err := rows.Scan(&record.Version, &record.Description, &record.LamigrateVersion, &appliedAt); err != nil {
+			return nil, err
+		}
+		record.AppliedAt = parseTime(appliedAt)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// InsertMetaRecord записывает применённый шаг обновления.
+// Вход: ctx для отмены, tx транзакция шага, запись.
+// Выход: error при ошибке вставки.
+// Назначение: шаг и его запись фиксируются вместе.
+// InsertMetaRecord records an applied upgrade step.
+// Input: ctx for cancellation, tx of the step, record.
+// Output: error on insert failure.
+// Purpose: the step and its record commit together.
+func (d *Driver) InsertMetaRecord(ctx context.Context, tx *sql.Tx, record lamigrate.MetaRecord) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO `+d.metaTable()+` (version, description, lamigrate_version, applied_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)`,
+		record.Version,
+		record.Description,
+		record.LamigrateVersion,
+	)
+	return err
+}
+
+// DescribeError сообщает, что SQLite не даёт SQLSTATE и позиции ошибки.
+// Вход: ошибка выполнения SQL.
+// Выход: всегда false; ошибка миграции содержит только имя файла и текст SQLite.
+// Назначение: реализация интерфейса Driver.
+// DescribeError reports that SQLite gives no SQLSTATE or error position.
+// Input: SQL execution error.
+// Output: always false; the migration error holds only the file name and the SQLite text.
+// Purpose: Driver interface implementation.
+func (d *Driver) DescribeError(err error) (lamigrate.DBError, bool) {
+	return lamigrate.DBError{}, false
+}
+
+// ScriptInsertMigration возвращает SQL записи миграции для офлайн-скрипта.
+// Вход: запись (имя, stage, checksum up-файла, происхождение).
+// Выход: SQL-команда INSERT с литералами.
+// Назначение: повторить InsertMigration в скрипте.
+// ScriptInsertMigration returns SQL recording a migration for an offline script.
+// Input: record (name, stage, up file checksum, origin).
+// Output: INSERT statement with literals.
+// Purpose: mirror InsertMigration in a script.
+func (d *Driver) ScriptInsertMigration(record lamigrate.MigrationRecord) string {
+	checksumValue := "NULL"
+	if record.Checksum != "" {
+		checksumValue = quoteLiteral(record.Checksum)
+	}
+	return fmt.Sprintf(
+		"INSERT INTO %s (migration, stage, executed_at, checksum, origin, duration_ms, db_user, hostname, lamigrate_version) VALUES (%s, %d, CURRENT_TIMESTAMP, %s, %s, %d, '', %s, %s);",
+		d.historyTable(),
+		quoteLiteral(record.Migration),
+		record.Stage,
+		checksumValue,
+		quoteLiteral(string(record.Origin)),
+		record.Duration.Milliseconds(),
+		quoteLiteral(record.Hostname),
+		quoteLiteral(record.Version),
+	)
+}
+
+// ScriptDeleteMigration возвращает SQL удаления записи миграции для офлайн-скрипта.
+// Вход: имя миграции.
+// Выход: SQL-команда DELETE с литералом.
+// Назначение: повторить DeleteMigration в скрипте.
+// ScriptDeleteMigration returns SQL removing a migration record for an offline script.
+// Input: migration name.
+// Output: DELETE statement with a literal.
+// Purpose: mirror DeleteMigration in a script.
+func (d *Driver) ScriptDeleteMigration(migrationName string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE migration = %s;", d.historyTable(), quoteLiteral(migrationName))
+}
+
+// ScriptInsertJournal возвращает SQL записи в журнал для офлайн-скрипта.
+// Вход: запись журнала.
+// Выход: SQL-команда INSERT с литералами.
+// Назначение: повторить InsertJournal в скрипте.
+// ScriptInsertJournal returns SQL appending a journal entry for an offline script.
+// Input: journal entry.
+// Output: INSERT statement with literals.
+// Purpose: mirror InsertJournal in a script.
+func (d *Driver) ScriptInsertJournal(entry lamigrate.JournalEntry) string {
+	checksumValue := "NULL"
+	if entry.Checksum != "" {
+		checksumValue = quoteLiteral(entry.Checksum)
+	}
+	return fmt.Sprintf(
+		"INSERT INTO %s (action, migration, stage, checksum, duration_ms, os_user, hostname, lamigrate_version, executed_at) VALUES (%s, %s, %d, %s, %d, %s, %s, %s, CURRENT_TIMESTAMP);",
+		d.journalTable(),
+		quoteLiteral(string(entry.Action)),
+		quoteLiteral(entry.Migration),
+		entry.Stage,
+		checksumValue,
+		entry.Duration.Milliseconds(),
+		quoteLiteral(entry.OSUser),
+		quoteLiteral(entry.Hostname),
+		quoteLiteral(entry.Version),
+	)
+}
+
+// ScriptInsertMetaRecord возвращает SQL записи шага обновления для офлайн-скрипта.
+// Вход: запись шага.
+// Выход: SQL-команда INSERT с литералами.
+// Назначение: повторить InsertMetaRecord в скрипте.
+// ScriptInsertMetaRecord returns SQL recording an upgrade step for an offline script.
+// Input: step record.
+// Output: INSERT statement with literals.
+// Purpose: mirror InsertMetaRecord in a script.
+func (d *Driver) ScriptInsertMetaRecord(record lamigrate.MetaRecord) string {
+	return fmt.Sprintf(
+		"INSERT INTO %s (version, description, lamigrate_version, applied_at) VALUES (%d, %s, %s, CURRENT_TIMESTAMP);",
+		d.metaTable(),
+		record.Version,
+		quoteLiteral(record.Description),
+		quoteLiteral(record.LamigrateVersion),
+	)
+}
+
+// quoteLiteral экранирует строку как SQL-литерал SQLite.
+// Вход: строка.
+// Выход: литерал в одинарных кавычках.
+// Назначение: безопасно подставлять значения в скрипт.
+// quoteLiteral escapes a string as an SQLite SQL literal.
+// Input: string.
+// Output: single-quoted literal.
+// Purpose: safely embed values into a script.
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// quoteIdent экранирует идентификатор SQLite двойными кавычками.
+// Вход: имя объекта.
+// Выход: идентификатор в двойных кавычках.
+// Назначение: безопасно подставлять имена таблиц в DDL.
+// quoteIdent escapes a SQLite identifier with double quotes.
+// Input: object name.
+// Output: double-quoted identifier.
+// Purpose: safely embed table names into DDL.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,563 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+// testMigrations — три миграции с down-файлами для сквозных тестов.
+// testMigrations are three migrations with down files for end-to-end tests.
+var testMigrations = fstest.MapFS{
+	"20240101000000_users.up.sql":      {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);\n")},
+	"20240101000000_users.down.sql":    {Data: []byte("DROP TABLE users;\n")},
+	"20240102000000_posts.up.sql":      {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY);\n")},
+	"20240102000000_posts.down.sql":    {Data: []byte("DROP TABLE posts;\n")},
+	"20240103000000_comments.up.sql":   {Data: []byte("CREATE TABLE comments (id INTEGER PRIMARY KEY);\n")},
+	"20240103000000_comments.down.sql": {Data: []byte("DROP TABLE comments;\n")},
+}
+
+// openTestDB открывает пустую БД SQLite во временном каталоге теста.
+// openTestDB opens an empty SQLite database in the test's temporary directory.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// newTestMigrator создаёт Migrator над testMigrations.
+// newTestMigrator creates a Migrator over testMigrations.
+func newTestMigrator(t *testing.T, db *sql.DB) *lamigrate.Migrator {
+	t.Helper()
+	m, err := lamigrate.NewMigrator(db, New(), lamigrate.WithMigrationsFS(testMigrations), lamigrate.WithLockTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// appliedKeys возвращает ключи применённых миграций по порядку.
+// appliedKeys returns the applied migration keys in order.
+func appliedKeys(t *testing.T, m *lamigrate.Migrator) []string {
+	t.Helper()
+	applied, err := m.Applied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, migration := range applied {
+		keys = append(keys, migration.Migration)
+	}
+	return keys
+}
+
+// tableExists сообщает, есть ли в БД таблица с таким именем.
+// tableExists reports whether the database has a table with this name.
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count == 1
+}
+
+func TestDescribeError(t *testing.T) {
+	if info, ok := New().DescribeError(errors.New(`near "foo": syntax error`)); ok {
+		t.Fatalf("DescribeError() = %+v, true; want false", info)
+	}
+}
+
+func TestUpDownRedo(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db)
+
+	if _, err := m.UpTo(ctx, "20240102000000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"20240101000000_users", "20240102000000_posts", "20240103000000_comments"}
+	if got := appliedKeys(t, m); !slices.Equal(got, want) {
+		t.Fatalf("applied = %v, want %v", got, want)
+	}
+
+	down, err := m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(down.Executed, []string{"20240103000000_comments.down.sql"}) {
+		t.Fatalf("down executed %v, want the last stage only", down.Executed)
+	}
+	if tableExists(t, db, "comments") || !tableExists(t, db, "posts") {
+		t.Fatal("down must drop comments and keep posts")
+	}
+
+	// UpTo и Up дали две стадии: users и posts в первой, comments во второй.
+	// UpTo and Up made two stages: users and posts in the first, comments in the second.
+	redo, err := m.Redo(ctx, lamigrate.LastStages(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDown := []string{"20240102000000_posts.down.sql", "20240101000000_users.down.sql"}
+	wantUp := []string{"20240101000000_users.up.sql", "20240102000000_posts.up.sql"}
+	if !slices.Equal(redo.Down.Executed, wantDown) || !slices.Equal(redo.Up, wantUp) {
+		t.Fatalf("redo = %+v, want the first stage down and up", redo)
+	}
+	if got := appliedKeys(t, m); !slices.Equal(got, want[:2]) {
+		t.Fatalf("applied after redo = %v, want %v", got, want[:2])
+	}
+
+	if _, err := m.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedKeys(t, m); len(got) != 0 {
+		t.Fatalf("applied after reset = %v, want none", got)
+	}
+	if tableExists(t, db, "users") {
+		t.Fatal("reset must drop users")
+	}
+}
+
+func TestSingleMigration(t *testing.T) {
+	all := []string{"20240101000000_users", "20240102000000_posts", "20240103000000_comments"}
+
+	tests := []struct {
+		name string
+		redo bool
+		key  string
+		// force снимает проверку порядка применения; зависимостей между этими миграциями нет.
+		// force lifts the application order check; these migrations have no dependencies.
+		force       bool
+		wantErr     bool
+		wantApplied []string
+		wantDropped string
+	}{
+		{name: "down earlier refused", key: "20240101000000", wantErr: true, wantApplied: all},
+		{name: "down latest", key: "20240103000000_comments", wantApplied: all[:2], wantDropped: "comments"},
+		{name: "down earlier forced", key: "20240102000000", force: true, wantApplied: []string{all[0], all[2]}, wantDropped: "posts"},
+		{name: "redo earlier refused", redo: true, key: "20240102000000_posts", wantErr: true, wantApplied: all},
+		{name: "redo latest", redo: true, key: "20240103000000", wantApplied: all},
+		{name: "redo earlier forced", redo: true, key: "20240101000000_users", force: true, wantApplied: []string{all[1], all[2], all[0]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openTestDB(t)
+			m := newTestMigrator(t, db)
+			if _, err := m.Up(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			target := lamigrate.SingleMigration(tt.key, tt.force)
+			var err error
+			if tt.redo {
+				var redo lamigrate.RedoResult
+				redo, err = m.Redo(ctx, target)
+				if err == nil && (len(redo.Down.Executed) != 1 || len(redo.Up) != 1) {
+					t.Fatalf("redo = %+v, want one migration down and up", redo)
+				}
+			} else {
+				_, err = m.DownTo(ctx, target)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := appliedKeys(t, m); !slices.Equal(got, tt.wantApplied) {
+				t.Fatalf("applied = %v, want %v", got, tt.wantApplied)
+			}
+			for _, table := range []string{"users", "posts", "comments"} {
+				if exists := tableExists(t, db, table); exists == (table == tt.wantDropped) {
+					t.Errorf("table %s exists = %v", table, exists)
+				}
+			}
+			if stages, err := m.Applied(ctx); err != nil || stages[0].Stage != 1 || stages[len(stages)-1].Stage != 1 {
+				t.Fatalf("applied = %+v, %v; want redo to keep stage 1", stages, err)
+			}
+		})
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db)
+
+	recorded, err := m.Baseline(ctx, "20240102000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(recorded, []string{"20240101000000_users", "20240102000000_posts"}) {
+		t.Fatalf("baseline recorded %v", recorded)
+	}
+	if tableExists(t, db, "users") {
+		t.Fatal("baseline must not run migration SQL")
+	}
+
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range applied {
+		if migration.Origin != lamigrate.OriginBaseline {
+			t.Fatalf("%s origin = %q, want %q", migration.Migration, migration.Origin, lamigrate.OriginBaseline)
+		}
+	}
+
+	executed, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(executed, []string{"20240103000000_comments.up.sql"}) {
+		t.Fatalf("up after baseline executed %v, want comments only", executed)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Redo(ctx, lamigrate.LastStages(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := m.Journal(ctx, lamigrate.JournalFilter{Migration: "20240103000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []lamigrate.JournalAction
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		if entry.Checksum != entries[0].Checksum {
+			t.Fatalf("%s row checksum %q differs from the up checksum %q", entry.Action, entry.Checksum, entries[0].Checksum)
+		}
+	}
+	want := []lamigrate.JournalAction{lamigrate.JournalUp, lamigrate.JournalDown, lamigrate.JournalUp}
+	if !slices.Equal(actions, want) {
+		t.Fatalf("journal actions = %v, want %v", actions, want)
+	}
+
+	if _, err := m.Fresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	all, err := m.Journal(ctx, lamigrate.JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) <= len(entries) {
+		t.Fatalf("journal has %d rows after fresh, want the old rows kept and new ones added", len(all))
+	}
+}
+
+func TestFreshKeepsOtherHistory(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db)
+	other, err := lamigrate.NewMigrator(
+		db,
+		New(),
+		lamigrate.WithMigrationsFS(fstest.MapFS{"20240101000000_orders.up.sql": {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY);\n")}}),
+		lamigrate.WithHistoryTable("", "billing"),
+		lamigrate.WithLockTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := other.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (id) VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Fresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"billing", "billing_meta", "billing_history", "lamigrate_history"} {
+		if !tableExists(t, db, table) {
+			t.Errorf("fresh dropped %s", table)
+		}
+	}
+	if keys := appliedKeys(t, other); !slices.Equal(keys, []string{"20240101000000_orders"}) {
+		t.Fatalf("other service's history after fresh = %v", keys)
+	}
+	var users int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&users); err != nil {
+		t.Fatal(err)
+	}
+	if users != 0 {
+		t.Fatalf("users has %d rows after fresh, want a recreated empty table", users)
+	}
+	if keys := appliedKeys(t, m); len(keys) != 3 {
+		t.Fatalf("applied after fresh = %v, want all three migrations", keys)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db)
+	supported := len(New().MetaUpgrades())
+
+	status, err := m.MetaStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 0 || len(status.Pending) != supported {
+		t.Fatalf("empty database meta = version %d, %d pending; want 0, %d", status.Version, len(status.Pending), supported)
+	}
+	if applied, err := m.Applied(ctx); err != nil || applied != nil {
+		t.Fatalf("Applied() on an empty database = %v, %v; want nil, nil", applied, err)
+	}
+	if tableExists(t, db, "lamigrate") {
+		t.Fatal("read-only calls must not create the history table")
+	}
+
+	upgraded, err := m.UpgradeMeta(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upgraded) != supported {
+		t.Fatalf("UpgradeMeta applied %d steps, want %d", len(upgraded), supported)
+	}
+	status, err = m.MetaStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != supported || len(status.Pending) != 0 {
+		t.Fatalf("meta after upgrade = version %d, %d pending; want %d, 0", status.Version, len(status.Pending), supported)
+	}
+
+	if _, err := db.Exec(`DELETE FROM lamigrate_meta WHERE version = ?`, supported); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Status(ctx); err != nil {
+		t.Fatalf("Status() on an older metadata version = %v, want it read as is", err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := m.MetaStatus(ctx); err != nil || status.Version != supported {
+		t.Fatalf("meta after up = %+v, %v; want version %d", status, err, supported)
+	}
+}
+
+func TestReadOlderMeta(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	driver := New()
+
+	// База версии 1: только история с checksum и <table>_meta, без origin, журнала и деталей.
+	// A version 1 database: only history with checksum and <table>_meta, no origin, journal or details.
+	step := driver.MetaUpgrades()[0]
+	err := driver.WithTransaction(ctx, db, func(tx *sql.Tx) error {
+		if err := step.Apply(ctx, tx); err != nil {
+			return err
+		}
+		return driver.InsertMetaRecord(ctx, tx, lamigrate.MetaRecord{Version: step.Version, Description: step.Description})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO lamigrate (migration, stage, checksum) VALUES ('20240101000000_users', 1, NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestMigrator(t, db)
+	report, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Applied) != 1 || report.Applied[0].Origin != lamigrate.OriginApply || len(report.Pending) != 2 {
+		t.Fatalf("status on version 1 = %d applied (%+v), %d pending; want 1 apply row, 2 pending", len(report.Applied), report.Applied, len(report.Pending))
+	}
+	if entries, err := m.Journal(ctx, lamigrate.JournalFilter{}); err != nil || len(entries) != 0 {
+		t.Fatalf("Journal() before the journal step = %v, %v; want nil, nil", entries, err)
+	}
+	if plan, err := m.PlanUpTo(ctx, ""); err != nil || len(plan.Items) != 2 {
+		t.Fatalf("PlanUpTo() on version 1 = %d items, %v; want 2, nil", len(plan.Items), err)
+	}
+	if status, err := m.MetaStatus(ctx); err != nil || status.Version != 1 {
+		t.Fatalf("read-only calls changed meta: %+v, %v", status, err)
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := appliedKeys(t, m); len(keys) != 3 {
+		t.Fatalf("applied after up = %v, want all three migrations", keys)
+	}
+}
+
+func TestLockReleasesStaleRow(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	driver := New()
+
+	conn, err := driver.Lock(ctx, db, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.Lock(ctx, db, 300*time.Millisecond); !errors.Is(err, lamigrate.ErrLocked) {
+		t.Fatalf("second Lock() = %v, want ErrLocked while the holder is alive", err)
+	}
+	if err := driver.Unlock(ctx, db, conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// Процесс завершился, его pid свободен: строка от него — как после падения.
+	// The process has exited and its pid is free: its row looks like one left by a crash.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	staleOwner := fmt.Sprintf("%s pid %d", currentHostname(), cmd.Process.Pid)
+	if _, err := db.Exec(`INSERT INTO lamigrate_lock (id, owner, acquired_at) VALUES (1, ?, '2024-01-01 00:00:00')`, staleOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err = driver.Lock(ctx, db, time.Second)
+	if err != nil {
+		t.Fatalf("Lock() over a stale row = %v", err)
+	}
+	var owner string
+	if err := db.QueryRowContext(ctx, `SELECT owner FROM lamigrate_lock WHERE id = 1`).Scan(&owner); err != nil {
+		t.Fatal(err)
+	}
+	if owner != lockOwner() {
+		t.Fatalf("lock owner = %q, want %q", owner, lockOwner())
+	}
+	if err := driver.Unlock(ctx, db, conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO lamigrate_lock (id, owner, acquired_at) VALUES (1, ?, '2024-01-01 00:00:00')`, "other-host pid 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.Lock(ctx, db, 300*time.Millisecond); !errors.Is(err, lamigrate.ErrLocked) {
+		t.Fatalf("Lock() over another host's row = %v, want ErrLocked", err)
+	}
+}
+
+func TestSingleConnectionPool(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	db.SetMaxOpenConns(1)
+	m := newTestMigrator(t, db)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Fresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if keys := appliedKeys(t, m); len(keys) != 3 {
+		t.Fatalf("applied = %v, want all three migrations", keys)
+	}
+	var locks int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM lamigrate_lock`).Scan(&locks); err != nil {
+		t.Fatal(err)
+	}
+	if locks != 0 {
+		t.Fatalf("lamigrate_lock has %d rows after the commands, want the lock released", locks)
+	}
+}
+
+func TestParseLockOwner(t *testing.T) {
+	tests := []struct {
+		owner    string
+		hostname string
+		pid      int
+		ok       bool
+	}{
+		{owner: "db-1 pid 42", hostname: "db-1", pid: 42, ok: true},
+		{owner: "host with spaces pid 7", hostname: "host with spaces", pid: 7, ok: true},
+		{owner: "host pid x", ok: false},
+		{owner: "host pid 0", ok: false},
+		{owner: "manual lock", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner, func(t *testing.T) {
+			hostname, pid, ok := parseLockOwner(tt.owner)
+			if hostname != tt.hostname || pid != tt.pid || ok != tt.ok {
+				t.Fatalf("parseLockOwner(%q) = %q, %d, %v; want %q, %d, %v", tt.owner, hostname, pid, ok, tt.hostname, tt.pid, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRestoreSessionDiscardsConn(t *testing.T) {
+	db := openTestDB(t)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreSession(context.Background(), conn, `PRAGMA foreign_keys = ON`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreSession(context.Background(), conn, `PRAGMA no_such_syntax(`); err == nil {
+		t.Fatal("restoreSession() with a failing statement succeeded")
+	}
+	_ = conn.Close()
+	if open := db.Stats().OpenConnections; open != 0 {
+		t.Fatalf("%d connections open after a failed restore, want the connection discarded", open)
+	}
+}
+
+func TestUnmarkWithoutFiles(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	m := newTestMigrator(t, db)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := lamigrate.Config{DSN: path, MigrationsDir: filepath.Join(t.TempDir(), "missing"), LockTimeout: time.Second}
+	removed, err := lamigrate.Unmark(ctx, cfg, New(), "20240103000000")
+	if err != nil {
+		t.Fatalf("Unmark() without migration files = %v", err)
+	}
+	if !slices.Equal(removed, []string{"20240103000000_comments"}) {
+		t.Fatalf("unmarked %v, want comments", removed)
+	}
+	if got := appliedKeys(t, m); !slices.Equal(got, []string{"20240101000000_users", "20240102000000_posts"}) {
+		t.Fatalf("applied after unmark = %v", got)
+	}
+}
@@ -0,0 +1,170 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// execer — общий интерфейс *sql.DB и *sql.Tx для выполнения SQL.
+// execer is the common interface of *sql.DB and *sql.Tx for running SQL.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// txUnit — группа миграций, которые фиксируются вместе.
+// Назначение: описать границы транзакций при применении/откате.
+// txUnit is a group of migrations committed together.
+// Purpose: describe transaction boundaries for apply/rollback.
+type txUnit struct {
+	migrations    []Migration
+	transactional bool
+}
+
+// splitUnits разбивает миграции на транзакционные группы по режиму.
+// Вход: упорядоченный список миграций и режим транзакций.
+// Выход: группы; в режиме all подряд идущие транзакционные миграции объединяются,
+// в per-migration каждая миграция в своей транзакции, в none — без транзакций.
+// Миграция с no-transaction всегда выполняется отдельно без транзакции;
+// Go-миграция в режиме none получает отдельную транзакцию.
+// Назначение: сохранить «всё в одной транзакции» везде, где это возможно.
+// splitUnits splits migrations into transactional groups by mode.
+// Input: ordered list of migrations and transaction mode.
+// Output: groups; in all mode consecutive transactional migrations are merged,
+// in per-migration each migration has its own transaction, in none there are none.
+// A no-transaction migration always runs on its own without a transaction;
+// a Go migration gets its own transaction in none mode.
+// Purpose: keep "everything in one transaction" wherever possible.
+func splitUnits(migrations []Migration, mode TxMode) []txUnit {
+	var units []txUnit
+	for _, migration := range migrations {
+		if !migration.Go && (migration.NoTransaction || mode == TxModeNone) {
+			units = append(units, txUnit{migrations: []Migration{migration}})
+			continue
+		}
+		if n := len(units); n > 0 && units[n-1].transactional && mode == TxModeAll {
+			units[n-1].migrations = append(units[n-1].migrations, migration)
+			continue
+		}
+		units = append(units, txUnit{migrations: []Migration{migration}, transactional: true})
+	}
+	return units
+}
+
+// runUnits выполняет группы миграций и записывает их в lamigrate.
+// Вход: ctx для отмены, db соединение, driver, logger для событий, группы,
+// record — запись в lamigrate и журнал внутри транзакции (с длительностью SQL), done — вызывается для каждой
+// миграции после фиксации группы.
+// Выход: error на первой неудачной группе (предыдущие группы уже зафиксированы).
+// Назначение: общий цикл выполнения для up и down.
+// Для миграции без транзакции SQL выполняется напрямую, а запись в lamigrate —
+// отдельной короткой транзакцией.
+// runUnits executes migration groups and records them in lamigrate.
+// Input: ctx for cancellation, db connection, driver, logger for events, groups,
+// record writes to lamigrate and the journal inside a transaction (with the SQL duration), done is called per migration
+// after its group commits.
+// Output: error on the first failing group (earlier groups are already committed).
+// Purpose: shared execution loop for up and down.
+// For a non-transactional migration the SQL runs directly and the lamigrate
+// bookkeeping is written in a separate short transaction.
+func runUnits(
+	ctx context.Context,
+	db *sql.DB,
+	driver Driver,
+	logger Logger,
+	units []txUnit,
+	record func(tx *sql.Tx, migration Migration, duration time.Duration) error,
+	done func(migration Migration),
+) error {
+	for _, unit := range units {
+		if unit.transactional {
+			if err := driver.WithTransaction(ctx, db, func(tx *sql.Tx) error {
+				for _, migration := range unit.migrations {
+					duration, err := execLogged(ctx, tx, driver, logger, migration)
+					if err != nil {
+						return err
+					}
+					if err := record(tx, migration, duration); err != nil {
+						return err
+					}
+				}
+				return nil
+			}); err != nil {
+				logger.ErrorContext(ctx, "transaction rolled back", "migrations", len(unit.migrations), "error", err)
+				return err
+			}
+			logger.InfoContext(ctx, "transaction committed", "migrations", len(unit.migrations))
+		} else {
+			migration := unit.migrations[0]
+			duration, err := execLogged(ctx, db, driver, logger, migration)
+			if err != nil {
+				return err
+			}
+			if err := driver.WithTransaction(ctx, db, func(tx *sql.Tx) error {
+				return record(tx, migration, duration)
+			}); err != nil {
+				logger.ErrorContext(ctx, "migration not recorded", "migration", migration.Filename, "error", err)
+				return fmt.Errorf("migration %s was executed without a transaction but not recorded: %w", migration.Filename, err)
+			}
+		}
+
+		for _, migration := range unit.migrations {
+			done(migration)
+		}
+	}
+	return nil
+}
+
+// execLogged выполняет миграцию и пишет события начала/конца с длительностью.
+// Вход: ctx для отмены, транзакция или соединение, driver, logger, миграция.
+// Выход: длительность выполнения (0 для пустой миграции) или error.
+// Назначение: единая точка логирования и замера выполнения миграций.
+// execLogged runs a migration and logs start/finish events with duration.
+// Input: ctx for cancellation, transaction or connection, driver, logger, migration.
+// Output: execution duration (0 for an empty migration) or error.
+// Purpose: single place logging and timing migration execution.
+func execLogged(ctx context.Context, ex execer, driver Driver, logger Logger, migration Migration) (time.Duration, error) {
+	if migration.Empty() {
+		logger.InfoContext(ctx, "migration skipped, empty", "migration", migration.Filename, "direction", migration.Direction)
+		return 0, nil
+	}
+
+	logger.DebugContext(ctx, "migration started", "migration", migration.Filename, "direction", migration.Direction)
+	start := time.Now()
+	if err := execMigration(ctx, ex, driver, migration); err != nil {
+		logger.ErrorContext(ctx, "migration failed", "migration", migration.Filename, "duration", time.Since(start), "error", err)
+		return 0, err
+	}
+	duration := time.Since(start)
+	logger.InfoContext(ctx, "migration finished", "migration", migration.Filename, "direction", migration.Direction, "duration", duration)
+	return duration, nil
+}
+
+// execMigration выполняет SQL или Go-функцию миграции, если она не пустая.
+// Вход: ctx для отмены, транзакция или соединение (Go-миграциям нужна транзакция), driver, миграция.
+// Выход: error при ошибке выполнения; ошибка SQL — *MigrationError с местом в файле.
+// Назначение: единое выполнение SQL для всех режимов.
+// execMigration runs migration SQL or Go function unless it is empty.
+// Input: ctx for cancellation, transaction or connection (Go migrations need a transaction), driver, migration.
+// Output: error on execution failure; an SQL failure is a *MigrationError with its place in the file.
+// Purpose: single SQL execution path for all modes.
+func execMigration(ctx context.Context, ex execer, driver Driver, migration Migration) error {
+	if migration.Empty() {
+		return nil
+	}
+	if migration.Func != nil {
+		tx, ok := ex.(*sql.Tx)
+		if !ok {
+			return fmt.Errorf("exec migration %s: go migrations need a transaction", migration.Filename)
+		}
+		if err := migration.Func(ctx, tx); err != nil {
+			return fmt.Errorf("exec migration %s: %w", migration.Filename, err)
+		}
+		return nil
+	}
+	if _, err := ex.ExecContext(ctx, migration.SQL); err != nil {
+		return newMigrationError(driver, migration, err)
+	}
+	return nil
+}
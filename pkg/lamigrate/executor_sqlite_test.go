@@ -0,0 +1,117 @@
+package lamigrate_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+// failingMigrations — users и comments применяются, posts падает посередине файла.
+// failingMigrations apply users and comments, while posts fails in the middle of the file.
+var failingMigrations = fstest.MapFS{
+	"20240101000000_users.up.sql":    {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);\n")},
+	"20240102000000_posts.up.sql":    {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY);\nINSERT INTO missing VALUES (1);\n")},
+	"20240103000000_comments.up.sql": {Data: []byte("CREATE TABLE comments (id INTEGER PRIMARY KEY);\n")},
+}
+
+// appliedNames возвращает ключи применённых миграций по порядку.
+// appliedNames returns the applied migration keys in order.
+func appliedNames(t *testing.T, m *lamigrate.Migrator) []string {
+	t.Helper()
+	applied, err := m.Applied(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, item := range applied {
+		names = append(names, item.Migration)
+	}
+	return names
+}
+
+func TestRunUnitsFailure(t *testing.T) {
+	tests := []struct {
+		mode        lamigrate.TxMode
+		wantApplied []string
+		wantTables  map[string]bool
+	}{
+		{
+			mode:        lamigrate.TxModeAll,
+			wantApplied: []string{},
+			wantTables:  map[string]bool{"users": false, "posts": false, "comments": false},
+		},
+		{
+			mode:        lamigrate.TxModePerMigration,
+			wantApplied: []string{"20240101000000_users"},
+			wantTables:  map[string]bool{"users": true, "posts": false, "comments": false},
+		},
+		{
+			// Без транзакции выполненная часть упавшего файла остаётся, но в историю он не попадает.
+			// Without a transaction the executed part of the failed file stays, but it is not recorded.
+			mode:        lamigrate.TxModeNone,
+			wantApplied: []string{"20240101000000_users"},
+			wantTables:  map[string]bool{"users": true, "posts": true, "comments": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			ctx := context.Background()
+			db := openTestDB(t)
+			m := newTestMigrator(t, db, failingMigrations, lamigrate.WithTxMode(tt.mode))
+
+			executed, err := m.Up(ctx)
+			if err == nil {
+				t.Fatal("Up() with a failing migration succeeded")
+			}
+			if len(executed) != len(tt.wantApplied) {
+				t.Fatalf("Up() reported %v as committed, want %d files", executed, len(tt.wantApplied))
+			}
+			if got := appliedNames(t, m); !slices.Equal(got, tt.wantApplied) {
+				t.Fatalf("applied after failure = %v, want %v", got, tt.wantApplied)
+			}
+			for table, want := range tt.wantTables {
+				var count int
+				if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count); err != nil {
+					t.Fatal(err)
+				}
+				if (count == 1) != want {
+					t.Errorf("table %s exists = %v, want %v", table, count == 1, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunUnitsNoneRecordsBookkeeping(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	m := newTestMigrator(t, db, checksumMigrations, lamigrate.WithTxMode(lamigrate.TxModeNone))
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("applied = %+v, want both migrations", applied)
+	}
+	for _, item := range applied {
+		if item.Checksum == "" || item.Stage != 1 || item.Origin != lamigrate.OriginApply {
+			t.Fatalf("bookkeeping row %+v, want checksum, stage 1 and origin apply", item)
+		}
+	}
+	entries, err := m.Journal(ctx, lamigrate.JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != lamigrate.JournalUp {
+		t.Fatalf("journal = %+v, want an up row per migration", entries)
+	}
+}
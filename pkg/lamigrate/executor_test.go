@@ -0,0 +1,124 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// txCountingDriver нумерует транзакции WithTransaction, остальные методы Driver не вызываются.
+// txCountingDriver numbers WithTransaction transactions; other Driver methods are never called.
+type txCountingDriver struct {
+	Driver
+	transactions *int
+}
+
+func (d txCountingDriver) WithTransaction(_ context.Context, _ *sql.DB, fn func(*sql.Tx) error) error {
+	*d.transactions++
+	return fn(nil)
+}
+
+// unitMigrations — a, b, затем c с no-transaction, затем d, e; SQL пустой, поэтому выполняется только учёт.
+// unitMigrations are a, b, then c with no-transaction, then d, e; SQL is empty so only bookkeeping runs.
+func unitMigrations() []Migration {
+	var migrations []Migration
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		migrations = append(migrations, Migration{
+			Version:       fmt.Sprintf("2024010100000%d", i),
+			Name:          name,
+			Direction:     DirectionUp,
+			NoTransaction: name == "c",
+		})
+	}
+	return migrations
+}
+
+// unitNames описывает группы как "a+b", а группу без транзакции — как "!c".
+// unitNames describes groups as "a+b" and a group without a transaction as "!c".
+func unitNames(units []txUnit) []string {
+	var names []string
+	for _, unit := range units {
+		var group []string
+		for _, migration := range unit.migrations {
+			group = append(group, migration.Name)
+		}
+		name := strings.Join(group, "+")
+		if !unit.transactional {
+			name = "!" + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestSplitUnits(t *testing.T) {
+	goMigration := Migration{Version: "20240101000009", Name: "go", Direction: DirectionUp, Go: true}
+
+	tests := []struct {
+		name       string
+		migrations []Migration
+		mode       TxMode
+		want       []string
+	}{
+		{name: "all merges around no-transaction", migrations: unitMigrations(), mode: TxModeAll, want: []string{"a+b", "!c", "d+e"}},
+		{name: "per-migration", migrations: unitMigrations(), mode: TxModePerMigration, want: []string{"a", "b", "!c", "d", "e"}},
+		{name: "none", migrations: unitMigrations(), mode: TxModeNone, want: []string{"!a", "!b", "!c", "!d", "!e"}},
+		{name: "none keeps a transaction for go", migrations: append(unitMigrations()[:2], goMigration), mode: TxModeNone, want: []string{"!a", "!b", "go"}},
+		{name: "all with go", migrations: append(unitMigrations()[:2], goMigration), mode: TxModeAll, want: []string{"a+b+go"}},
+		{name: "empty", mode: TxModeAll},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unitNames(splitUnits(tt.migrations, tt.mode)); !slices.Equal(got, tt.want) {
+				t.Fatalf("splitUnits(%s) = %v, want %v", tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunUnitsRecordsPerTransaction(t *testing.T) {
+	tests := []struct {
+		mode TxMode
+		// want — номер транзакции, в которой записана каждая из a..e.
+		// want is the transaction number each of a..e is recorded in.
+		want []int
+	}{
+		{mode: TxModeAll, want: []int{1, 1, 2, 3, 3}},
+		{mode: TxModePerMigration, want: []int{1, 2, 3, 4, 5}},
+		{mode: TxModeNone, want: []int{1, 2, 3, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			transactions := 0
+			driver := txCountingDriver{transactions: &transactions}
+			var recorded, done []int
+			err := runUnits(
+				context.Background(),
+				nil,
+				driver,
+				nopLogger{},
+				splitUnits(unitMigrations(), tt.mode),
+				func(_ *sql.Tx, _ Migration, _ time.Duration) error {
+					recorded = append(recorded, transactions)
+					return nil
+				},
+				func(Migration) { done = append(done, transactions) },
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(recorded, tt.want) {
+				t.Fatalf("bookkeeping transactions = %v, want %v", recorded, tt.want)
+			}
+			if !slices.Equal(done, tt.want) {
+				t.Fatalf("done after transactions = %v, want %v", done, tt.want)
+			}
+		})
+	}
+}
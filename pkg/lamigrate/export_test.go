@@ -0,0 +1,9 @@
+package lamigrate
+
+// UnregisterGoMigration снимает регистрацию RegisterGoMigration, чтобы тест не влиял на соседние.
+// UnregisterGoMigration undoes RegisterGoMigration so a test does not affect its neighbours.
+func UnregisterGoMigration(version, name string) {
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+	delete(goMigrations, version+"_"+name)
+}
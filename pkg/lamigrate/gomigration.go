@@ -0,0 +1,109 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GoMigrationFunc — шаг Go-миграции, выполняемый внутри транзакции.
+// GoMigrationFunc is a Go migration step run inside a transaction.
+type GoMigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+var versionPattern = regexp.MustCompile(`^\d{14}$`)
+
+var (
+	goMigrationsMu sync.RWMutex
+	goMigrations   = map[string]goMigration{}
+)
+
+// goMigration хранит зарегистрированную пару up/down.
+// goMigration holds a registered up/down pair.
+type goMigration struct {
+	version string
+	name    string
+	up      GoMigrationFunc
+	down    GoMigrationFunc
+}
+
+// RegisterGoMigration регистрирует миграцию, написанную на Go.
+// Вход: version (14 цифр, как в именах файлов), name, функции up и down
+// (nil down — пустой откат, как пустой .down.sql).
+// Выход: нет; паникует при неверной версии, пустом имени, nil up или повторной регистрации.
+// Назначение: миграции, которым нужен код (пересчёт данных, парсеры).
+// Go-миграции сливаются с SQL-файлами по Version, получают stage и пишутся
+// в lamigrate так же, как SQL; они всегда выполняются в транзакции (даже при
+// tx-mode none) и не попадают в SQL-скрипты.
+// RegisterGoMigration registers a migration written in Go.
+// Input: version (14 digits, as in file names), name, up and down functions
+// (nil down means an empty rollback, like an empty .down.sql).
+// Output: none; panics on a bad version, empty name, nil up or duplicate registration.
+// Purpose: migrations that need code (data rewrites, parsers).
+// Go migrations are merged with SQL files by Version, get a stage and are stored
+// in lamigrate just like SQL ones; they always run inside a transaction (even
+// with tx-mode none) and cannot be rendered into SQL scripts.
+func RegisterGoMigration(version, name string, up, down GoMigrationFunc) {
+	name = strings.TrimSpace(name)
+	if !versionPattern.MatchString(version) {
+		panic(fmt.Sprintf("lamigrate: RegisterGoMigration version must be 14 digits, got %q", version))
+	}
+	if name == "" {
+		panic("lamigrate: RegisterGoMigration name is empty for version " + version)
+	}
+	if up == nil {
+		panic("lamigrate: RegisterGoMigration up is nil for " + version + "_" + name)
+	}
+
+	key := version + "_" + name
+
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+
+	if _, dup := goMigrations[key]; dup {
+		panic("lamigrate: RegisterGoMigration called twice for " + key)
+	}
+	goMigrations[key] = goMigration{version: version, name: name, up: up, down: down}
+}
+
+// registeredGoMigrations возвращает зарегистрированные Go-миграции как Migration.
+// Вход: нет.
+// Выход: up и down для каждой регистрации, упорядоченные по ключу.
+// Назначение: слить Go-миграции с просканированными файлами.
+// registeredGoMigrations returns registered Go migrations as Migration values.
+// Input: none.
+// Output: up and down for each registration, ordered by key.
+// Purpose: merge Go migrations with scanned files.
+func registeredGoMigrations() []Migration {
+	goMigrationsMu.RLock()
+	defer goMigrationsMu.RUnlock()
+
+	keys := make([]string, 0, len(goMigrations))
+	for key := range goMigrations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	migrations := make([]Migration, 0, 2*len(keys))
+	for _, key := range keys {
+		item := goMigrations[key]
+		for _, direction := range []Direction{DirectionUp, DirectionDown} {
+			fn := item.up
+			if direction == DirectionDown {
+				fn = item.down
+			}
+			migrations = append(migrations, Migration{
+				Version:   item.version,
+				Name:      item.name,
+				Direction: direction,
+				Filename:  fmt.Sprintf("%s.%s.go", key, direction),
+				Func:      fn,
+				Go:        true,
+			})
+		}
+	}
+	return migrations
+}
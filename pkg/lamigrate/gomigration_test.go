@@ -0,0 +1,157 @@
+package lamigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"lamigrate/pkg/lamigrate"
+)
+
+// goMergeMigrations — SQL-файлы вокруг Go-миграции 20240102000000_seed.
+// goMergeMigrations are the SQL files around the 20240102000000_seed Go migration.
+var goMergeMigrations = fstest.MapFS{
+	"20240101000000_users.up.sql":      {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);\n")},
+	"20240101000000_users.down.sql":    {Data: []byte("DROP TABLE users;\n")},
+	"20240103000000_comments.up.sql":   {Data: []byte("CREATE TABLE comments (id INTEGER PRIMARY KEY);\n")},
+	"20240103000000_comments.down.sql": {Data: []byte("DROP TABLE comments;\n")},
+}
+
+// registerSeed регистрирует Go-миграцию, которая добавляет пользователя в users, на время теста.
+// registerSeed registers a Go migration adding a user to users for the duration of the test.
+func registerSeed(t *testing.T) {
+	t.Helper()
+	lamigrate.RegisterGoMigration("20240102000000", "seed",
+		func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO users (id) VALUES (1)`)
+			return err
+		},
+		func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = 1`)
+			return err
+		},
+	)
+	t.Cleanup(func() { lamigrate.UnregisterGoMigration("20240102000000", "seed") })
+}
+
+// countUsers возвращает число строк в users.
+// countUsers returns the number of rows in users.
+func countUsers(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestGoMigrationMerge(t *testing.T) {
+	registerSeed(t)
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	m := newTestMigrator(t, db, goMergeMigrations)
+	executed, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"20240101000000_users.up.sql", "20240102000000_seed.up.go", "20240103000000_comments.up.sql"}
+	if !slices.Equal(executed, want) {
+		t.Fatalf("up executed %v, want %v", executed, want)
+	}
+	if got := countUsers(t, db); got != 1 {
+		t.Fatalf("users has %d rows after up, want the Go migration's row", got)
+	}
+
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, item := range applied {
+		keys = append(keys, item.Migration)
+		if item.Stage != 1 {
+			t.Fatalf("%s stage = %d, want 1", item.Migration, item.Stage)
+		}
+	}
+	if want := []string{"20240101000000_users", "20240102000000_seed", "20240103000000_comments"}; !slices.Equal(keys, want) {
+		t.Fatalf("applied = %v, want %v", keys, want)
+	}
+}
+
+func TestGoMigrationStagesAndDown(t *testing.T) {
+	registerSeed(t)
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// Первая стадия: users и Go-миграция; comments появляется позже и уходит во вторую.
+	// First stage: users and the Go migration; comments shows up later and goes to the second.
+	first := maps.Clone(goMergeMigrations)
+	delete(first, "20240103000000_comments.up.sql")
+	delete(first, "20240103000000_comments.down.sql")
+	if _, err := newTestMigrator(t, db, first).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestMigrator(t, db, goMergeMigrations)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := map[string]int{}
+	for _, item := range applied {
+		stages[item.Migration] = item.Stage
+	}
+	wantStages := map[string]int{"20240101000000_users": 1, "20240102000000_seed": 1, "20240103000000_comments": 2}
+	if !maps.Equal(stages, wantStages) {
+		t.Fatalf("stages = %v, want %v", stages, wantStages)
+	}
+
+	result, err := m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Executed, []string{"20240103000000_comments.down.sql"}) {
+		t.Fatalf("down of stage 2 executed %v, want comments only", result.Executed)
+	}
+
+	// Откат до users проверяет, что down Go-миграции выполнился до удаления таблицы.
+	// Rolling back to users checks that the Go down ran before the table is dropped.
+	result, err = m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"20240102000000_seed.down.go", "20240101000000_users.down.sql"}
+	if !slices.Equal(result.Executed, want) {
+		t.Fatalf("down of stage 1 executed %v, want %v", result.Executed, want)
+	}
+	if got := appliedNames(t, m); len(got) != 0 {
+		t.Fatalf("applied after down = %v, want none", got)
+	}
+}
+
+func TestGoMigrationDownRemovesRow(t *testing.T) {
+	registerSeed(t)
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	m := newTestMigrator(t, db, goMergeMigrations)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.DownTo(ctx, lamigrate.ToVersion("20240101000000")); err != nil {
+		t.Fatal(err)
+	}
+	if got := countUsers(t, db); got != 0 {
+		t.Fatalf("users has %d rows after the Go down, want 0", got)
+	}
+	if got := appliedNames(t, m); !slices.Equal(got, []string{"20240101000000_users"}) {
+		t.Fatalf("applied after down = %v, want users only", got)
+	}
+}
@@ -0,0 +1,252 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Version — версия lamigrate, которая пишется в журнал.
+// Назначение: понять, каким релизом выполнена запись; CLI подставляет свою версию.
+// Version is the lamigrate version written to the journal.
+// Purpose: tell which release made a record; the CLI sets its own version.
+var Version = "0.1.10"
+
+// JournalTableSuffix — суффикс таблицы журнала к имени таблицы истории (lamigrate_history).
+// JournalTableSuffix is the journal table suffix to the history table name (lamigrate_history).
+const JournalTableSuffix = "_history"
+
+// JournalAction — действие, записанное в журнал.
+// JournalAction is an action recorded in the journal.
+type JournalAction string
+
+const (
+	// JournalUp — выполнена up-миграция (up, redo, fresh).
+	// JournalUp means an up migration was executed (up, redo, fresh).
+	JournalUp JournalAction = "up"
+	// JournalDown — выполнена down-миграция (down, reset, redo).
+	// JournalDown means a down migration was executed (down, reset, redo).
+	JournalDown JournalAction = "down"
+	// JournalBaseline — миграция записана baseline без выполнения SQL.
+	// JournalBaseline means baseline recorded the migration without running SQL.
+	JournalBaseline JournalAction = "baseline"
+	// JournalMark — миграция записана mark-applied без выполнения SQL.
+	// JournalMark means mark-applied recorded the migration without running SQL.
+	JournalMark JournalAction = "mark"
+	// JournalUnmark — запись удалена unmark без выполнения down-SQL.
+	// JournalUnmark means unmark removed the record without running down SQL.
+	JournalUnmark JournalAction = "unmark"
+	// JournalRepair — repair заменил checksum на checksum текущего файла.
+	// JournalRepair means repair replaced the checksum with the current file's one.
+	JournalRepair JournalAction = "repair"
+)
+
+// JournalEntry — строка append-only журнала lamigrate_history.
+// Назначение: аудит всех изменений истории; строки не удаляются при откате.
+// Duration — время выполнения SQL (0 для действий без SQL).
+// Checksum — checksum up-файла миграции и у строк down, чтобы их можно было сопоставить с up.
+// JournalEntry is a row of the append-only lamigrate_history journal.
+// Purpose: audit every history change; rows survive rollbacks.
+// Duration is the SQL execution time (0 for actions without SQL).
+// Checksum is the migration's up file checksum, for down rows too, so they match their up rows.
+type JournalEntry struct {
+	ID         int64         `json:"id"`
+	Action     JournalAction `json:"action"`
+	Migration  string        `json:"migration"`
+	Stage      int           `json:"stage"`
+	Checksum   string        `json:"checksum,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	OSUser     string        `json:"os_user"`
+	Hostname   string        `json:"hostname"`
+	Version    string        `json:"lamigrate_version"`
+	ExecutedAt time.Time     `json:"executed_at"`
+}
+
+// JournalFilter ограничивает выборку журнала; нулевые поля не фильтруют.
+// Migration — ключ "version_name" или версия (14 цифр).
+// Since/Until — полуинтервал [Since, Until) по executed_at.
+// Limit — сколько последних записей вернуть (0 — все).
+// JournalFilter narrows a journal query; zero fields do not filter.
+// Migration is a "version_name" key or a version (14 digits).
+// Since/Until is the half-open range [Since, Until) over executed_at.
+// Limit is how many latest entries to return (0 means all).
+type JournalFilter struct {
+	Migration string
+	Stage     int
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// Where собирает условие WHERE для драйвера.
+// Вход: placeholder — плейсхолдер n-го аргумента ($n или ?), timeValue — значение
+// времени в формате колонки executed_at драйвера.
+// Выход: условие без слова WHERE (пустое, если фильтров нет) и аргументы.
+// Назначение: одинаковая семантика фильтров во всех драйверах. Версия сравнивается
+// через LIKE 'version_%': версия всегда 14 цифр, поэтому "_" совпадает только с разделителем.
+// Where builds the WHERE condition for a driver.
+// Input: placeholder of the n-th argument ($n or ?), timeValue converts a time into
+// the driver's executed_at column format.
+// Output: condition without the WHERE keyword (empty when there are no filters) and arguments.
+// Purpose: the same filter semantics in every driver. A version is compared with
+// LIKE 'version_%': a version is always 14 digits, so "_" only matches the separator.
+func (f JournalFilter) Where(placeholder func(n int) string, timeValue func(time.Time) any) (string, []any) {
+	var conditions []string
+	var args []any
+	add := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, placeholder(len(args))))
+	}
+
+	switch {
+	case f.Migration == "":
+	case versionPattern.MatchString(f.Migration):
+		add("migration LIKE %s", f.Migration+"_%")
+	default:
+		add("migration = %s", f.Migration)
+	}
+	if f.Stage > 0 {
+		add("stage = %s", f.Stage)
+	}
+	if !f.Since.IsZero() {
+		add("executed_at >= %s", timeValue(f.Since.UTC()))
+	}
+	if !f.Until.IsZero() {
+		add("executed_at < %s", timeValue(f.Until.UTC()))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Journal читает журнал по фильтру без изменения истории.
+// Вход: ctx для отмены, фильтр.
+// Выход: записи от старых к новым (пусто, если таблиц lamigrate ещё нет) или error.
+// Назначение: команда history и аудит из кода приложения.
+// Journal reads the journal by a filter without changing history.
+// Input: ctx for cancellation, filter.
+// Output: entries from oldest to newest (empty if lamigrate tables do not exist yet) or error.
+// Purpose: the history command and auditing from application code.
+func (m *Migrator) Journal(ctx context.Context, filter JournalFilter) ([]JournalEntry, error) {
+	if filter.Stage < 0 || filter.Limit < 0 {
+		return nil, fmt.Errorf("journal stage and limit must not be negative")
+	}
+
+	reader, exists, err := existingSchema(ctx, m.db, m.driver)
+	if err != nil {
+		return nil, fmt.Errorf("check lamigrate schema: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	entries, err := reader.JournalEntries(ctx, m.db, filter)
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	return entries, nil
+}
+
+// ReadJournal открывает БД по cfg.DSN и читает журнал по фильтру.
+// Вход: ctx для отмены, cfg с DSN, реализация driver, фильтр.
+// Выход: записи от старых к новым или error.
+// Назначение: тонкая обёртка над Migrator.Journal для CLI.
+// ReadJournal opens the database from cfg.DSN and reads the journal by a filter.
+// Input: ctx for cancellation, cfg with DSN, driver implementation, filter.
+// Output: entries from oldest to newest or error.
+// Purpose: a thin wrapper over Migrator.Journal for the CLI.
+func ReadJournal(ctx context.Context, cfg Config, driver Driver, filter JournalFilter) ([]JournalEntry, error) {
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("dsn is empty")
+	}
+
+	db, err := driver.Open(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	m, err := NewMigrator(db, driver, withConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return m.Journal(ctx, filter)
+}
+
+// journal пишет запись журнала в транзакции изменения истории.
+// Вход: ctx для отмены, транзакция, действие, ключ миграции, stage, checksum, длительность.
+// Выход: error при ошибке вставки (откатывает всю транзакцию).
+// Назначение: журнал и lamigrate меняются атомарно.
+// journal writes a journal entry inside the history-changing transaction.
+// Input: ctx for cancellation, transaction, action, migration key, stage, checksum, duration.
+// Output: error on insert failure (rolls back the whole transaction).
+// Purpose: the journal and lamigrate change atomically.
+func (m *Migrator) journal(ctx context.Context, tx *sql.Tx, action JournalAction, migration string, stage int, checksum string, duration time.Duration) error {
+	if err := m.driver.InsertJournal(ctx, tx, JournalEntry{
+		Action:    action,
+		Migration: migration,
+		Stage:     stage,
+		Checksum:  checksum,
+		Duration:  duration,
+		OSUser:    m.osUser,
+		Hostname:  m.hostname,
+		Version:   Version,
+	}); err != nil {
+		return fmt.Errorf("journal %s %s: %w", action, migration, err)
+	}
+	return nil
+}
+
+// appliedChecksums возвращает сохранённые checksum up-файлов применённых миграций.
+// Вход: ctx для отмены.
+// Выход: checksum по ключу миграции или error.
+// Назначение: строки down в журнале хранят checksum up-файла, как и строки up,
+// а не checksum down-файла, который выполнялся.
+// appliedChecksums returns the stored up file checksums of applied migrations.
+// Input: ctx for cancellation.
+// Output: checksum by migration key or error.
+// Purpose: down journal rows store the up file checksum like up rows do,
+// not the checksum of the down file that ran.
+func (m *Migrator) appliedChecksums(ctx context.Context) (map[string]string, error) {
+	applied, err := m.driver.AppliedMigrations(ctx, m.db)
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	checksums := make(map[string]string, len(applied))
+	for _, item := range applied {
+		checksums[item.Migration] = item.Checksum
+	}
+	return checksums, nil
+}
+
+// currentOSUser возвращает имя пользователя ОС процесса.
+// Вход: нет.
+// Выход: имя пользователя, $USER/$USERNAME или "unknown".
+// Назначение: кто выполнил миграцию (в контейнере user.Current может не знать имя).
+// currentOSUser returns the OS user name of the process.
+// Input: none.
+// Output: user name, $USER/$USERNAME or "unknown".
+// Purpose: who ran the migration (user.Current may not know the name in a container).
+func currentOSUser() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	for _, name := range []string{"USER", "USERNAME"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return "unknown"
+}
+
+// currentHostname возвращает имя хоста или "unknown".
+// currentHostname returns the hostname or "unknown".
+func currentHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "unknown"
+	}
+	return hostname
+}
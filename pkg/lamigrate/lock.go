@@ -0,0 +1,51 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// LockTableSuffix — суффикс таблицы блокировки к имени таблицы истории для драйверов
+// без advisory-блокировок (SQLite: lamigrate_lock).
+// LockTableSuffix is the lock table suffix to the history table name for drivers
+// without advisory locks (SQLite: lamigrate_lock).
+const LockTableSuffix = "_lock"
+
+// ErrLocked возвращается драйвером, если блокировку миграций держит другая сессия.
+// Назначение: отличать конкурентный запуск от прочих ошибок БД.
+// ErrLocked is returned by drivers when another session holds the migration lock.
+// Purpose: distinguish a concurrent run from other database errors.
+var ErrLocked = errors.New("migration lock is held by another session")
+
+// ErrSingleConnection возвращается драйверами с сессионной блокировкой (Postgres, MySQL),
+// если пул ограничен одним соединением.
+// Назначение: блокировку держит выделенное соединение, а миграции идут через пул,
+// поэтому с SetMaxOpenConns(1) они ждали бы друг друга вечно. Драйверу, который держит
+// блокировку в таблице (SQLite), соединение не нужно, и один открытый коннект ему подходит.
+// ErrSingleConnection is returned by drivers with a session lock (Postgres, MySQL)
+// when the pool is limited to one connection.
+// Purpose: a dedicated connection holds the lock while migrations run on the pool,
+// so with SetMaxOpenConns(1) they would wait for each other forever. A driver keeping
+// the lock in a table (SQLite) needs no connection, so one open connection is fine for it.
+var ErrSingleConnection = errors.New("migration lock needs a pool with at least 2 open connections")
+
+// acquireLock берёт межпроцессную блокировку миграций.
+// Вход: ctx для отмены, driver, db соединение, cfg с таймаутом ожидания.
+// Выход: функция освобождения блокировки или error драйвера (ErrLocked, ErrSingleConnection).
+// Назначение: не дать двум процессам одновременно планировать и применять stage.
+// acquireLock takes the cross-process migration lock.
+// Input: ctx for cancellation, driver, db connection, cfg with wait timeout.
+// Output: release function or the driver's error (ErrLocked, ErrSingleConnection).
+// Purpose: prevent two processes from planning and applying a stage at once.
+func acquireLock(ctx context.Context, driver Driver, db *sql.DB, cfg Config) (func(), error) {
+	conn, err := driver.Lock(ctx, db, cfg.LockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("acquire migration lock: %w", err)
+	}
+
+	return func() {
+		_ = driver.Unlock(context.WithoutCancel(ctx), db, conn)
+	}, nil
+}
@@ -0,0 +1,29 @@
+package lamigrate
+
+import (
+	"context"
+)
+
+// Logger принимает события раннера в стиле log/slog; *slog.Logger подходит как есть.
+// Назначение: не печатать из библиотеки в stdout и вписаться в логи сервиса.
+// Аргументы — пары ключ/значение, как в slog.
+// Logger receives runner events in log/slog style; *slog.Logger fits as is.
+// Purpose: never print to stdout from the library and fit into service logs.
+// Arguments are key/value pairs, as in slog.
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+// nopLogger отбрасывает все события.
+// Назначение: логгер по умолчанию, библиотека молчит без явной настройки.
+// nopLogger discards all events.
+// Purpose: default logger, the library is silent unless configured.
+type nopLogger struct{}
+
+func (nopLogger) DebugContext(context.Context, string, ...any) {}
+func (nopLogger) InfoContext(context.Context, string, ...any)  {}
+func (nopLogger) WarnContext(context.Context, string, ...any)  {}
+func (nopLogger) ErrorContext(context.Context, string, ...any) {}
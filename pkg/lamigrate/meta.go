@@ -0,0 +1,279 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MetaTableSuffix — суффикс таблицы версий служебной схемы к имени таблицы истории (lamigrate_meta).
+// MetaTableSuffix is the metadata version table suffix to the history table name (lamigrate_meta).
+const MetaTableSuffix = "_meta"
+
+// ErrMetaTooNew возвращается, если служебные таблицы обновлены более новой версией lamigrate.
+// Назначение: не писать в таблицы, формат которых эта версия не знает.
+// ErrMetaTooNew is returned when the bookkeeping tables were upgraded by a newer lamigrate.
+// Purpose: never write to tables whose format this version does not know.
+var ErrMetaTooNew = errors.New("lamigrate metadata was upgraded by a newer lamigrate")
+
+// MetaUpgrade — шаг обновления служебных таблиц драйвера (история, журнал, версии).
+// Version идёт подряд с 1; Apply должен быть идемпотентным, потому что базы старых
+// версий lamigrate без таблицы версий проходят все шаги с начала.
+// Script — те же изменения в виде SQL-команд для базы ровно версии Version-1;
+// script вставляет их в начало офлайн-скрипта.
+// MetaUpgrade is a driver's upgrade step for the bookkeeping tables (history, journal, versions).
+// Version runs consecutively from 1; Apply must be idempotent because databases of older
+// lamigrate versions without the version table go through every step from the start.
+// Script holds the same changes as SQL statements for a database exactly at Version-1;
+// script puts them at the top of an offline script.
+type MetaUpgrade struct {
+	Version     int
+	Description string
+	Apply       func(ctx context.Context, tx *sql.Tx) error
+	Script      []string
+}
+
+// MetaRecord — строка таблицы <table>_meta о применённом шаге обновления.
+// LamigrateVersion — версия lamigrate, которая применила шаг.
+// MetaRecord is a <table>_meta row about an applied upgrade step.
+// LamigrateVersion is the lamigrate version that applied the step.
+type MetaRecord struct {
+	Version          int       `json:"version"`
+	Description      string    `json:"description"`
+	LamigrateVersion string    `json:"lamigrate_version"`
+	AppliedAt        time.Time `json:"applied_at"`
+}
+
+// MetaStatus — состояние служебной схемы в БД относительно этой версии lamigrate.
+// Version — текущая версия в БД (0 — таблиц версий нет), Supported — последняя версия,
+// которую знает драйвер; Pending — шаги, которые применит следующая команда.
+// MetaStatus is the state of the bookkeeping schema relative to this lamigrate version.
+// Version is the current version in the database (0 means no version table), Supported
+// is the latest version the driver knows; Pending are the steps the next command applies.
+type MetaStatus struct {
+	Version   int
+	Supported int
+	Applied   []MetaRecord
+	Pending   []MetaUpgrade
+}
+
+// TooNew сообщает, что БД обновлена более новой версией lamigrate.
+// TooNew reports that the database was upgraded by a newer lamigrate.
+func (s MetaStatus) TooNew() bool {
+	return s.Version > s.Supported
+}
+
+// MetaStatus читает версию служебной схемы без её обновления.
+// Вход: ctx для отмены.
+// Выход: MetaStatus или error.
+// Назначение: команда meta status и диагностика перед обновлением lamigrate.
+// MetaStatus reads the bookkeeping schema version without upgrading it.
+// Input: ctx for cancellation.
+// Output: MetaStatus or error.
+// Purpose: the meta status command and diagnostics before upgrading lamigrate.
+func (m *Migrator) MetaStatus(ctx context.Context) (MetaStatus, error) {
+	upgrades, err := metaUpgrades(m.driver)
+	if err != nil {
+		return MetaStatus{}, err
+	}
+	records, err := m.driver.MetaRecords(ctx, m.db)
+	if err != nil {
+		return MetaStatus{}, fmt.Errorf("read lamigrate metadata version: %w", err)
+	}
+
+	version, err := metaVersion(records)
+	if err != nil {
+		return MetaStatus{}, err
+	}
+
+	status := MetaStatus{Version: version, Supported: len(upgrades), Applied: records}
+	for _, upgrade := range upgrades {
+		if upgrade.Version > status.Version {
+			status.Pending = append(status.Pending, upgrade)
+		}
+	}
+	return status, nil
+}
+
+// ReadMetaStatus открывает БД по cfg.DSN и читает версию служебной схемы.
+// Вход: ctx для отмены, cfg с DSN, реализация driver.
+// Выход: MetaStatus или error.
+// Назначение: тонкая обёртка над Migrator.MetaStatus для CLI.
+// ReadMetaStatus opens the database from cfg.DSN and reads the bookkeeping schema version.
+// Input: ctx for cancellation, cfg with DSN, driver implementation.
+// Output: MetaStatus or error.
+// Purpose: a thin wrapper over Migrator.MetaStatus for the CLI.
+func ReadMetaStatus(ctx context.Context, cfg Config, driver Driver) (MetaStatus, error) {
+	if cfg.DSN == "" {
+		return MetaStatus{}, fmt.Errorf("dsn is empty")
+	}
+
+	db, err := driver.Open(cfg.DSN)
+	if err != nil {
+		return MetaStatus{}, fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	m, err := NewMigrator(db, driver, withConfig(cfg))
+	if err != nil {
+		return MetaStatus{}, err
+	}
+	return m.MetaStatus(ctx)
+}
+
+// UpgradeMeta применяет недостающие шаги обновления служебных таблиц под блокировкой.
+// Вход: ctx для отмены.
+// Выход: применённые шаги (пусто, если схема актуальна) или error.
+// Назначение: команда meta upgrade — обновить схему lamigrate отдельно от миграций,
+// не дожидаясь первого up.
+// UpgradeMeta applies missing bookkeeping table upgrade steps under the lock.
+// Input: ctx for cancellation.
+// Output: applied steps (empty if the schema is current) or error.
+// Purpose: the meta upgrade command — upgrade the lamigrate schema separately from migrations,
+// without waiting for the first up.
+func (m *Migrator) UpgradeMeta(ctx context.Context) ([]MetaUpgrade, error) {
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	status, err := m.MetaStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+	return status.Pending, nil
+}
+
+// UpgradeMeta открывает БД по cfg.DSN и обновляет служебные таблицы.
+// Вход: ctx для отмены, cfg с DSN, реализация driver.
+// Выход: применённые шаги или error.
+// Назначение: тонкая обёртка над Migrator.UpgradeMeta для CLI.
+// UpgradeMeta opens the database from cfg.DSN and upgrades the bookkeeping tables.
+// Input: ctx for cancellation, cfg with DSN, driver implementation.
+// Output: applied steps or error.
+// Purpose: a thin wrapper over Migrator.UpgradeMeta for the CLI.
+func UpgradeMeta(ctx context.Context, cfg Config, driver Driver) ([]MetaUpgrade, error) {
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("dsn is empty")
+	}
+
+	db, err := driver.Open(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	m, err := NewMigrator(db, driver, withConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return m.UpgradeMeta(ctx)
+}
+
+// ensureSchema доводит служебные таблицы до последней версии драйвера.
+// Вызывающий обязан держать блокировку миграций: пути только на чтение используют existingSchema.
+// Вход: ctx для отмены, db соединение, driver.
+// Выход: error при ошибке шага или ErrMetaTooNew, если БД новее этой версии lamigrate.
+// Назначение: актуальная схема стоит одного запроса; иначе шаги применяются по одному,
+// каждый в транзакции вместе со строкой <table>_meta.
+// ensureSchema brings the bookkeeping tables to the driver's latest version.
+// The caller must hold the migration lock: read-only paths use existingSchema.
+// Input: ctx for cancellation, db connection, driver.
+// Output: error on step failure or ErrMetaTooNew when the database is newer than this lamigrate.
+// Purpose: an up-to-date schema costs one query; otherwise steps run one by one,
+// each in a transaction together with its <table>_meta row.
+func ensureSchema(ctx context.Context, db *sql.DB, driver Driver) error {
+	upgrades, err := metaUpgrades(driver)
+	if err != nil {
+		return err
+	}
+	version, err := checkMetaVersion(ctx, db, driver, len(upgrades))
+	if err != nil || version == len(upgrades) {
+		return err
+	}
+
+	for _, upgrade := range upgrades[version:] {
+		err := driver.WithTransaction(ctx, db, func(tx *sql.Tx) error {
+			if err := upgrade.Apply(ctx, tx); err != nil {
+				return err
+			}
+			return driver.InsertMetaRecord(ctx, tx, MetaRecord{
+				Version:          upgrade.Version,
+				Description:      upgrade.Description,
+				LamigrateVersion: Version,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("lamigrate metadata upgrade %d (%s): %w", upgrade.Version, upgrade.Description, err)
+		}
+	}
+	return nil
+}
+
+// checkMetaVersion читает версию служебной схемы и сравнивает её с поддерживаемой.
+// Вход: ctx для отмены, db соединение, driver, последняя известная версия.
+// Выход: текущая версия, ErrMetaTooNew с версией lamigrate, обновившей БД, или error для пропуска шагов.
+// Назначение: общая проверка для обновления схемы и путей только на чтение.
+// checkMetaVersion reads the bookkeeping schema version and compares it with the supported one.
+// Input: ctx for cancellation, db connection, driver, latest known version.
+// Output: current version, ErrMetaTooNew naming the lamigrate version that upgraded the database,
+// or error for missing steps.
+// Purpose: a shared check for schema upgrades and read-only paths.
+func checkMetaVersion(ctx context.Context, db *sql.DB, driver Driver, supported int) (int, error) {
+	records, err := driver.MetaRecords(ctx, db)
+	if err != nil {
+		return 0, fmt.Errorf("read lamigrate metadata version: %w", err)
+	}
+	version, err := metaVersion(records)
+	if err != nil {
+		return 0, err
+	}
+	if version > supported {
+		return 0, fmt.Errorf("%w: database metadata version %d (lamigrate %s), this lamigrate %s supports up to %d; upgrade lamigrate",
+			ErrMetaTooNew, version, records[version-1].LamigrateVersion, Version, supported)
+	}
+	return version, nil
+}
+
+// metaUpgrades возвращает шаги драйвера, проверив нумерацию подряд с 1.
+// Вход: driver.
+// Выход: шаги или error для ошибки в драйвере.
+// Назначение: индекс шага в срезе совпадает с версией до него.
+// metaUpgrades returns the driver's steps after checking they are numbered from 1.
+// Input: driver.
+// Output: steps or error for a driver bug.
+// Purpose: a step's slice index equals the version before it.
+func metaUpgrades(driver Driver) ([]MetaUpgrade, error) {
+	upgrades := driver.MetaUpgrades()
+	for i, upgrade := range upgrades {
+		if upgrade.Version != i+1 {
+			return nil, fmt.Errorf("driver %s: metadata upgrade %d is out of order (want %d)", driver.Name(), upgrade.Version, i+1)
+		}
+	}
+	return upgrades, nil
+}
+
+// metaVersion возвращает последнюю применённую версию (0 — записей нет).
+// Вход: записи <table>_meta в порядке версий.
+// Выход: версия или error, если шаги идут не подряд с 1.
+// Назначение: пропущенный шаг значит, что служебные таблицы правили руками, и
+// ни обновлять, ни читать их по старшей версии нельзя.
+// metaVersion returns the latest applied version (0 means no records).
+// Input: <table>_meta records in version order.
+// Output: version or error when the steps do not run consecutively from 1.
+// Purpose: a missing step means the bookkeeping tables were edited by hand, so
+// neither upgrading nor reading them by the highest version is safe.
+func metaVersion(records []MetaRecord) (int, error) {
+	for i, record := range records {
+		if record.Version != i+1 {
+			return 0, fmt.Errorf("lamigrate metadata is inconsistent: found version %d where %d was expected", record.Version, i+1)
+		}
+	}
+	return len(records), nil
+}
@@ -2,16 +2,26 @@ package lamigrate
 
 // Migration описывает файл миграции и распарсенные метаданные.
 // Назначение: хранить информацию о файле и SQL для выполнения.
+// NoTransaction выставляется директивой "-- lamigrate:no-transaction" в заголовке файла.
+// Go — миграция зарегистрирована через RegisterGoMigration; Func — её код (nil — пустой шаг).
+// leading — пробельные символы, обрезанные перед SQL, чтобы считать строки ошибок по файлу.
 // Migration describes a migration file and parsed metadata.
 // Purpose: hold file info and SQL for execution.
+// NoTransaction is set by the "-- lamigrate:no-transaction" header directive.
+// Go marks a migration registered with RegisterGoMigration; Func is its code (nil is an empty step).
+// leading is the whitespace trimmed before SQL, so error lines are counted within the file.
 type Migration struct {
-	Version   string
-	Name      string
-	Direction Direction
-	Filename  string
-	Path      string
-	SQL       string
-	Checksum  string
+	Version       string
+	Name          string
+	Direction     Direction
+	Filename      string
+	Path          string
+	SQL           string
+	Checksum      string
+	NoTransaction bool
+	Go            bool
+	Func          GoMigrationFunc
+	leading       string
 }
 
 // Direction это направление миграции.
@@ -38,3 +48,15 @@ const (
 func (m Migration) Key() string {
 	return m.Version + "_" + m.Name
 }
+
+// Empty сообщает, что миграция ничего не выполняет.
+// Вход: структура миграции.
+// Выход: true для пустого SQL-файла или Go-миграции без функции.
+// Назначение: пропускать пустые шаги и отмечать их как skipped.
+// Empty reports whether the migration does nothing.
+// Input: migration struct.
+// Output: true for an empty SQL file or a Go migration without a function.
+// Purpose: skip empty steps and report them as skipped.
+func (m Migration) Empty() bool {
+	return m.SQL == "" && m.Func == nil
+}
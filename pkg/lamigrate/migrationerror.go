@@ -0,0 +1,159 @@
+package lamigrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetContext — сколько строк файла показывать до и после строки с ошибкой.
+// snippetContext is how many file lines to show before and after the failing line.
+const snippetContext = 2
+
+// DBError — сведения об ошибке СУБД, которые драйвер извлёк из ошибки своего клиента.
+// Position — номер символа (с 1) в выполненном SQL, 0 — неизвестен.
+// DBError is database error information a driver extracted from its client's error.
+// Position is the character number (from 1) in the executed SQL, 0 means unknown.
+type DBError struct {
+	Code       string
+	Message    string
+	Detail     string
+	Hint       string
+	Constraint string
+	Position   int
+}
+
+// MigrationError — ошибка выполнения SQL миграции с местом в файле.
+// Line и Column считаются с 1 по файлу миграции (0 — драйвер не сообщил позицию);
+// Snippet — соседние строки файла с кареткой под местом ошибки.
+// Code — SQLSTATE; Detail, Hint и Constraint пустые, если СУБД их не прислала.
+// Получить из обёрнутой ошибки: errors.As(err, &migrationErr).
+// MigrationError is a migration SQL execution error with its place in the file.
+// Line and Column count from 1 within the migration file (0 means the driver gave no position);
+// Snippet holds the neighbouring file lines with a caret under the error.
+// Code is the SQLSTATE; Detail, Hint and Constraint are empty unless the database sent them.
+// Extract it from a wrapped error with errors.As(err, &migrationErr).
+type MigrationError struct {
+	Migration  string
+	Line       int
+	Column     int
+	Snippet    string
+	Code       string
+	Detail     string
+	Hint       string
+	Constraint string
+	Err        error
+}
+
+// Error возвращает однострочное описание: файл:строка:колонка, текст СУБД и SQLSTATE.
+// Error returns a one-line description: file:line:column, the database text and SQLSTATE.
+func (e *MigrationError) Error() string {
+	location := e.Migration
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d:%d", e.Migration, e.Line, e.Column)
+	}
+	message := fmt.Sprintf("exec migration %s: %v", location, e.Err)
+	if e.Code != "" {
+		message += " (SQLSTATE " + e.Code + ")"
+	}
+	return message
+}
+
+// Unwrap возвращает исходную ошибку клиента БД.
+// Unwrap returns the original database client error.
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// newMigrationError оборачивает ошибку выполнения SQL миграции.
+// Вход: driver для разбора ошибки клиента, миграция, ошибка ExecContext.
+// Выход: *MigrationError; без позиции от драйвера — только с именем файла.
+// Назначение: указать строку и колонку файла, а не смещение в отправленном SQL.
+// newMigrationError wraps a migration SQL execution error.
+// Input: driver to parse the client error, migration, ExecContext error.
+// Output: *MigrationError; without a position from the driver, only the file name is set.
+// Purpose: point at a file line and column instead of an offset in the sent SQL.
+func newMigrationError(driver Driver, migration Migration, err error) *MigrationError {
+	migrationErr := &MigrationError{Migration: migration.Filename, Err: err}
+	info, ok := driver.DescribeError(err)
+	if !ok {
+		return migrationErr
+	}
+
+	migrationErr.Code = info.Code
+	migrationErr.Detail = info.Detail
+	migrationErr.Hint = info.Hint
+	migrationErr.Constraint = info.Constraint
+	if info.Position > 0 {
+		text := migration.leading + migration.SQL
+		offset := len(migration.leading) + runeOffset(migration.SQL, info.Position-1)
+		migrationErr.Line, migrationErr.Column = lineColumn(text, offset)
+		migrationErr.Snippet = sqlSnippet(text, migrationErr.Line, migrationErr.Column)
+	}
+	return migrationErr
+}
+
+// runeOffset переводит номер символа в байтовое смещение строки.
+// Вход: строка, номер символа с 0.
+// Выход: байтовое смещение (не больше длины строки).
+// Назначение: СУБД считают позицию в символах, а Go режет строки по байтам.
+// runeOffset converts a character number into a byte offset of a string.
+// Input: string, character number from 0.
+// Output: byte offset (at most the string length).
+// Purpose: databases count positions in characters while Go slices strings by bytes.
+func runeOffset(text string, chars int) int {
+	offset := 0
+	for i := 0; i < chars && offset < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// lineColumn возвращает строку и колонку (с 1, в символах) байтового смещения.
+// lineColumn returns the line and column (from 1, in characters) of a byte offset.
+func lineColumn(text string, offset int) (int, int) {
+	before := text[:offset]
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return strings.Count(before, "\n") + 1, utf8.RuneCountInString(before[lineStart:]) + 1
+}
+
+// sqlSnippet печатает строки файла вокруг ошибки с номерами и кареткой.
+// Вход: текст файла, строка и колонка ошибки (с 1).
+// Выход: многострочный фрагмент без завершающего перевода строки.
+// Назначение: показать место ошибки как компилятор. Табуляции до колонки сохраняются
+// в строке с кареткой, поэтому она совпадает с отступами файла.
+// sqlSnippet prints the file lines around an error with numbers and a caret.
+// Input: file text, error line and column (from 1).
+// Output: multi-line fragment without a trailing newline.
+// Purpose: show the error place like a compiler. Tabs before the column are kept
+// in the caret line, so it lines up with the file indentation.
+func sqlSnippet(text string, line, column int) string {
+	lines := strings.Split(text, "\n")
+	first := max(line-snippetContext, 1)
+	last := min(line+snippetContext, len(lines))
+	width := len(strconv.Itoa(last))
+
+	var b strings.Builder
+	for number := first; number <= last; number++ {
+		content := strings.TrimRight(lines[number-1], "\r")
+		fmt.Fprintf(&b, "%*d | %s\n", width, number, content)
+		if number != line {
+			continue
+		}
+		var caret strings.Builder
+		for i, r := range []rune(content) {
+			if i >= column-1 {
+				break
+			}
+			if r == '\t' {
+				caret.WriteRune('\t')
+			} else {
+				caret.WriteRune(' ')
+			}
+		}
+		fmt.Fprintf(&b, "%*s | %s^\n", width, "", caret.String())
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,220 @@
+package lamigrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+// describeDriver подменяет DescribeError, остальные методы Driver не вызываются.
+// describeDriver stubs DescribeError; other Driver methods are never called.
+type describeDriver struct {
+	Driver
+	info DBError
+	ok   bool
+}
+
+func (d describeDriver) DescribeError(error) (DBError, bool) {
+	return d.info, d.ok
+}
+
+func TestRuneOffset(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		chars int
+		want  int
+	}{
+		{name: "empty", text: "", chars: 0, want: 0},
+		{name: "start", text: "abc", chars: 0, want: 0},
+		{name: "ascii", text: "abc", chars: 2, want: 2},
+		{name: "past end", text: "abc", chars: 10, want: 3},
+		{name: "cyrillic", text: "привет", chars: 2, want: 4},
+		{name: "emoji", text: "a😀b", chars: 2, want: 5},
+		{name: "crlf counts as two", text: "a\r\nb", chars: 3, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runeOffset(tt.text, tt.chars); got != tt.want {
+				t.Fatalf("runeOffset(%q, %d) = %d, want %d", tt.text, tt.chars, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineColumn(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		offset    int
+		line, col int
+	}{
+		{name: "start", text: "SELECT 1", offset: 0, line: 1, col: 1},
+		{name: "same line", text: "SELECT 1", offset: 7, line: 1, col: 8},
+		{name: "second line", text: "a\nbc", offset: 3, line: 2, col: 2},
+		{name: "crlf", text: "a\r\nbc", offset: 4, line: 2, col: 2},
+		{name: "utf8 columns in characters", text: "щи\nборщ", offset: len("щи\nбо"), line: 2, col: 3},
+		{name: "end after newline", text: "ab\n", offset: 3, line: 2, col: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, col := lineColumn(tt.text, tt.offset)
+			if line != tt.line || col != tt.col {
+				t.Fatalf("lineColumn(%q, %d) = %d:%d, want %d:%d", tt.text, tt.offset, line, col, tt.line, tt.col)
+			}
+		})
+	}
+}
+
+func TestSQLSnippet(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		line, col int
+		want      string
+	}{
+		{
+			name: "context is clipped at file edges",
+			text: "SELECT\nFROM t",
+			line: 1,
+			col:  1,
+			want: "1 | SELECT\n  | ^\n2 | FROM t",
+		},
+		{
+			name: "two lines around",
+			text: "one\ntwo\nthree\nfour\nfive\nsix",
+			line: 4,
+			col:  3,
+			want: "2 | two\n3 | three\n4 | four\n  |   ^\n5 | five\n6 | six",
+		},
+		{
+			name: "width of the widest number",
+			text: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10 x",
+			line: 9,
+			col:  1,
+			want: " 7 | 7\n 8 | 8\n 9 | 9\n   | ^\n10 | 10 x",
+		},
+		{
+			name: "tabs are kept before the caret",
+			text: "\tSELECT x",
+			line: 1,
+			col:  3,
+			want: "1 | \tSELECT x\n  | \t ^",
+		},
+		{
+			name: "crlf is not printed",
+			text: "a\r\nbb\r\n",
+			line: 2,
+			col:  2,
+			want: "1 | a\n2 | bb\n  |  ^\n3 | ",
+		},
+		{
+			name: "utf8 caret counts characters",
+			text: "SELECT 'ёж' x",
+			line: 1,
+			col:  13,
+			want: "1 | SELECT 'ёж' x\n  |             ^",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqlSnippet(tt.text, tt.line, tt.col); got != tt.want {
+				t.Fatalf("sqlSnippet() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMigrationError(t *testing.T) {
+	cause := errors.New(`syntax error at or near "foo"`)
+	tests := []struct {
+		name      string
+		migration Migration
+		info      DBError
+		ok        bool
+		line, col int
+		message   string
+	}{
+		{
+			name:      "driver does not know the error",
+			migration: Migration{Filename: "1_a.up.sql", SQL: "SELECT foo"},
+			message:   `exec migration 1_a.up.sql: syntax error at or near "foo"`,
+		},
+		{
+			name:      "position 0 keeps only the code",
+			migration: Migration{Filename: "1_a.up.sql", SQL: "SELECT foo"},
+			info:      DBError{Code: "42601"},
+			ok:        true,
+			message:   `exec migration 1_a.up.sql: syntax error at or near "foo" (SQLSTATE 42601)`,
+		},
+		{
+			name:      "leading whitespace counts in lines",
+			migration: Migration{Filename: "1_a.up.sql", SQL: "SELECT\n  foo", leading: "\n\n"},
+			info:      DBError{Code: "42601", Position: 10},
+			ok:        true,
+			line:      4,
+			col:       3,
+			message:   `exec migration 1_a.up.sql:4:3: syntax error at or near "foo" (SQLSTATE 42601)`,
+		},
+		{
+			name:      "crlf file",
+			migration: Migration{Filename: "1_a.up.sql", SQL: "SELECT 1;\r\nSELECT foo"},
+			info:      DBError{Position: 19},
+			ok:        true,
+			line:      2,
+			col:       8,
+			message:   `exec migration 1_a.up.sql:2:8: syntax error at or near "foo"`,
+		},
+		{
+			name:      "utf8 before the error",
+			migration: Migration{Filename: "1_a.up.sql", SQL: "SELECT 'ёж' foo"},
+			info:      DBError{Position: 13},
+			ok:        true,
+			line:      1,
+			col:       13,
+			message:   `exec migration 1_a.up.sql:1:13: syntax error at or near "foo"`,
+		},
+		{
+			name:      "position past the end points after the last character",
+			migration: Migration{Filename: "1_a.up.sql", SQL: "SELECT"},
+			info:      DBError{Position: 100},
+			ok:        true,
+			line:      1,
+			col:       7,
+			message:   `exec migration 1_a.up.sql:1:7: syntax error at or near "foo"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newMigrationError(describeDriver{info: tt.info, ok: tt.ok}, tt.migration, cause)
+			if got.Line != tt.line || got.Column != tt.col {
+				t.Fatalf("position = %d:%d, want %d:%d", got.Line, got.Column, tt.line, tt.col)
+			}
+			if (got.Snippet != "") != (tt.line > 0) {
+				t.Fatalf("snippet = %q, want one only with a position", got.Snippet)
+			}
+			if got.Error() != tt.message {
+				t.Fatalf("Error() = %q, want %q", got.Error(), tt.message)
+			}
+			if !errors.Is(got, cause) {
+				t.Fatalf("errors.Is(err, cause) = false")
+			}
+		})
+	}
+}
+
+func TestNewMigrationErrorScannedFile(t *testing.T) {
+	migrations, err := ScanMigrationsFS(fstest.MapFS{
+		"20240101000000_a.up.sql": {Data: []byte("\n\n-- comment\nCREATE TABLE t (\n\tid INT,\n\tname TEXT NOT\n);\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlText := migrations[0].SQL
+	position := len([]rune(sqlText[:len(sqlText)-len("\n);")]))
+
+	got := newMigrationError(describeDriver{info: DBError{Position: position}, ok: true}, migrations[0], errors.New("boom"))
+	if got.Line != 6 || got.Column != 14 {
+		t.Fatalf("position = %d:%d, want 6:14", got.Line, got.Column)
+	}
+}
@@ -0,0 +1,1170 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Migrator выполняет миграции на пуле соединений вызывающего кода.
+// Назначение: встроить lamigrate в приложение без второго пула и повторного
+// сканирования файлов. Migrator не закрывает db; методы безопасны для
+// конкурентного вызова, межпроцессная блокировка берётся в Up/Down/Repair.
+// В Postgres и MySQL блокировку держит отдельное соединение пула, пока миграции
+// выполняются на других, поэтому пулу нужно не меньше двух соединений
+// (SetMaxOpenConns(1) — ErrSingleConnection); SQLite работает и с одним.
+// Migrator runs migrations on the caller's connection pool.
+// Purpose: embed lamigrate into an application without a second pool and
+// without rescanning files. Migrator never closes db; methods are safe for
+// concurrent use, the cross-process lock is taken in Up/Down/Repair.
+// In Postgres and MySQL a separate pool connection holds the lock while migrations
+// run on others, so the pool needs at least two connections (SetMaxOpenConns(1)
+// gives ErrSingleConnection); SQLite works with one as well.
+type Migrator struct {
+	db     *sql.DB
+	driver Driver
+	cfg    Config
+	txMode TxMode
+	logger Logger
+
+	osUser   string
+	hostname string
+
+	mu         sync.Mutex
+	migrations []Migration
+}
+
+// Option настраивает Migrator.
+// Option configures a Migrator.
+type Option func(*Migrator)
+
+// WithMigrationsDir задаёт директорию с миграциями (или поддиректорию в WithMigrationsFS).
+// WithMigrationsDir sets the migrations directory (or a subdirectory of WithMigrationsFS).
+func WithMigrationsDir(dir string) Option {
+	return func(m *Migrator) {
+		m.cfg.MigrationsDir = dir
+	}
+}
+
+// WithMigrationsFS задаёт источник файлов миграций, например embed.FS.
+// WithMigrationsFS sets the migration file source, e.g. an embed.FS.
+func WithMigrationsFS(fsys fs.FS) Option {
+	return func(m *Migrator) {
+		m.cfg.Migrations = fsys
+	}
+}
+
+// WithLockTimeout задаёт, сколько ждать блокировку миграций (0 — пока не отменён ctx).
+// WithLockTimeout sets how long to wait for the migration lock (0 waits until ctx is done).
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) {
+		m.cfg.LockTimeout = timeout
+	}
+}
+
+// WithTxMode задаёт режим транзакций.
+// WithTxMode sets the transaction mode.
+func WithTxMode(mode TxMode) Option {
+	return func(m *Migrator) {
+		m.cfg.TxMode = mode
+	}
+}
+
+// WithLogger задаёт логгер событий (например, *slog.Logger); по умолчанию события отбрасываются.
+// WithLogger sets the event logger (e.g. *slog.Logger); events are discarded by default.
+func WithLogger(logger Logger) Option {
+	return func(m *Migrator) {
+		m.cfg.Logger = logger
+	}
+}
+
+// WithHistoryTable задаёт таблицу истории и её схему (пустые значения — по умолчанию).
+// WithHistoryTable sets the history table and its schema (empty values mean defaults).
+func WithHistoryTable(schema, table string) Option {
+	return func(m *Migrator) {
+		m.cfg.Schema = schema
+		m.cfg.Table = table
+	}
+}
+
+// WithDropExtensions разрешает Fresh удалить расширения Postgres.
+// WithDropExtensions allows Fresh to drop Postgres extensions.
+func WithDropExtensions(drop bool) Option {
+	return func(m *Migrator) {
+		m.cfg.DropExtensions = drop
+	}
+}
+
+// withConfig переносит настройки Config в Migrator.
+// withConfig copies Config settings into a Migrator.
+func withConfig(cfg Config) Option {
+	return func(m *Migrator) {
+		m.cfg = cfg
+	}
+}
+
+// NewMigrator создаёт Migrator поверх открытого пула соединений.
+// Вход: db (остаётся во владении вызывающего), driver, опции.
+// Выход: *Migrator или error при пустых аргументах, неверной таблице истории и неизвестном режиме транзакций.
+// Назначение: точка входа для встраивания в приложение. Если драйвер не поддерживает
+// режим all, Migrator работает в per-migration и пишет предупреждение в Logger.
+// NewMigrator creates a Migrator on top of an open connection pool.
+// Input: db (stays owned by the caller), driver, options.
+// Output: *Migrator or error on empty arguments, an invalid history table and an unknown transaction mode.
+// Purpose: entry point for embedding into an application. When the driver cannot run
+// all mode, the Migrator uses per-migration and logs a warning to the Logger.
+func NewMigrator(db *sql.DB, driver Driver, opts ...Option) (*Migrator, error) {
+	if db == nil {
+		return nil, fmt.Errorf("db is nil")
+	}
+	if driver == nil {
+		return nil, fmt.Errorf("driver is nil")
+	}
+
+	m := &Migrator{db: db, driver: driver}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	driver, err := historyDriver(driver, m.cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.driver = driver
+
+	txMode, err := ResolveTxMode(driver, m.cfg.TxMode)
+	if err != nil {
+		return nil, err
+	}
+	m.txMode = txMode
+
+	m.logger = m.cfg.Logger
+	if m.logger == nil {
+		m.logger = nopLogger{}
+	}
+	if requested, _ := ParseTxMode(string(m.cfg.TxMode)); requested != txMode {
+		m.logger.WarnContext(context.Background(), "transaction mode downgraded: the driver commits DDL implicitly, a failed stage is not rolled back",
+			"driver", driver.Name(), "requested", requested, "tx_mode", txMode)
+	}
+	m.osUser = currentOSUser()
+	m.hostname = currentHostname()
+	return m, nil
+}
+
+// historyDriver настраивает driver на таблицу истории из cfg.
+// Вход: driver и cfg.
+// Выход: driver как есть, если Table и Schema пусты, иначе его копия; error для неверного имени.
+// Назначение: общая точка для NewMigrator и ReadHistory.
+// historyDriver configures driver for the history table from cfg.
+// Input: driver and cfg.
+// Output: driver as is when Table and Schema are empty, otherwise its copy; error for an invalid name.
+// Purpose: a shared point for NewMigrator and ReadHistory.
+func historyDriver(driver Driver, cfg Config) (Driver, error) {
+	if cfg.Table == "" && cfg.Schema == "" {
+		return driver, nil
+	}
+	configured, err := driver.WithHistoryTable(cfg.Schema, cfg.Table)
+	if err != nil {
+		return nil, fmt.Errorf("history table: %w", err)
+	}
+	return configured, nil
+}
+
+// openMigrator сканирует миграции, открывает БД по cfg.DSN и создаёт Migrator.
+// Вход: cfg и driver.
+// Выход: Migrator с заполненным кэшем, пул (закрывает вызывающий) или error.
+// Назначение: общая подготовка свободных функций ApplyUp/ApplyDown/Plan*.
+// openMigrator scans migrations, opens the database from cfg.DSN and creates a Migrator.
+// Input: cfg and driver.
+// Output: Migrator with a filled cache, pool (closed by the caller) or error.
+// Purpose: shared setup of the ApplyUp/ApplyDown/Plan* free functions.
+func openMigrator(cfg Config, driver Driver) (*Migrator, *sql.DB, error) {
+	if cfg.DSN == "" {
+		return nil, nil, fmt.Errorf("dsn is empty")
+	}
+	if _, err := ResolveTxMode(driver, cfg.TxMode); err != nil {
+		return nil, nil, err
+	}
+
+	migrations, err := scanConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, db, err := openHistoryMigrator(cfg, driver)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.setMigrations(migrations)
+	return m, db, nil
+}
+
+// openHistoryMigrator открывает БД по cfg.DSN и создаёт Migrator без сканирования миграций.
+// Вход: cfg и driver.
+// Выход: Migrator, пул (закрывает вызывающий) или error.
+// Назначение: команды, которым хватает истории в БД (unmark), работают и тогда, когда
+// файлов миграций уже нет.
+// openHistoryMigrator opens the database from cfg.DSN and creates a Migrator without scanning migrations.
+// Input: cfg and driver.
+// Output: Migrator, pool (closed by the caller) or error.
+// Purpose: commands that need only the history in the database (unmark) keep working
+// when the migration files are gone.
+func openHistoryMigrator(cfg Config, driver Driver) (*Migrator, *sql.DB, error) {
+	if cfg.DSN == "" {
+		return nil, nil, fmt.Errorf("dsn is empty")
+	}
+
+	db, err := driver.Open(cfg.DSN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open database: %w", err)
+	}
+
+	m, err := NewMigrator(db, driver, withConfig(cfg))
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+	return m, db, nil
+}
+
+// Migrations возвращает просканированные миграции (SQL и Go).
+// Вход: нет.
+// Выход: упорядоченный список или error при IO/валидации.
+// Назначение: сканировать источник один раз за жизнь Migrator; для новых файлов
+// создайте новый Migrator.
+// Migrations returns scanned migrations (SQL and Go).
+// Input: none.
+// Output: ordered list or error on IO/validation.
+// Purpose: scan the source once per Migrator lifetime; create a new Migrator
+// to pick up new files.
+func (m *Migrator) Migrations() ([]Migration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.migrations != nil {
+		return m.migrations, nil
+	}
+
+	migrations, err := scanConfig(m.cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.setMigrations(migrations)
+	return migrations, nil
+}
+
+// setMigrations сохраняет результат сканирования в кэш.
+// Вход: просканированные миграции.
+// Выход: нет.
+// Назначение: единое место кэширования и события scan.
+// setMigrations stores scan results in the cache.
+// Input: scanned migrations.
+// Output: none.
+// Purpose: single place for caching and the scan event.
+func (m *Migrator) setMigrations(migrations []Migration) {
+	m.migrations = migrations
+	m.logger.DebugContext(context.Background(), "migrations scanned", "files", len(migrations))
+}
+
+// logPlan пишет событие построенного плана.
+// Вход: ctx для отмены, направление, число миграций, stage (0 для down).
+// Выход: нет.
+// Назначение: одинаковое событие plan для Up/Down/Plan.
+// logPlan logs a built plan event.
+// Input: ctx for cancellation, direction, number of migrations, stage (0 for down).
+// Output: none.
+// Purpose: the same plan event for Up/Down/Plan.
+func (m *Migrator) logPlan(ctx context.Context, direction Direction, migrations int, stage int) {
+	args := []any{"direction", direction, "migrations", migrations, "tx_mode", m.txMode}
+	if stage > 0 {
+		args = append(args, "stage", stage)
+	}
+	m.logger.InfoContext(ctx, "plan built", args...)
+}
+
+// lock берёт блокировку миграций и пишет события взятия/освобождения.
+// Вход: ctx для отмены.
+// Выход: функция освобождения или error.
+// Назначение: обёртка acquireLock с логированием.
+// lock takes the migration lock and logs acquire/release events.
+// Input: ctx for cancellation.
+// Output: release function or error.
+// Purpose: acquireLock wrapper with logging.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	start := time.Now()
+	release, err := acquireLock(ctx, m.driver, m.db, m.cfg)
+	if err != nil {
+		m.logger.ErrorContext(ctx, "migration lock not acquired", "waited", time.Since(start), "error", err)
+		return nil, err
+	}
+	m.logger.DebugContext(ctx, "migration lock acquired", "waited", time.Since(start))
+
+	return func() {
+		release()
+		m.logger.DebugContext(ctx, "migration lock released")
+	}, nil
+}
+
+// Up применяет все новые up-миграции одним stage; семантика как у ApplyUp.
+// Вход: ctx для отмены.
+// Выход: выполненные файлы (при ошибке — уже зафиксированные) или error.
+// Назначение: применение миграций при старте приложения.
+// Up applies all pending up migrations as one stage; same semantics as ApplyUp.
+// Input: ctx for cancellation.
+// Output: executed files (on error, those already committed) or error.
+// Purpose: apply migrations at application startup.
+func (m *Migrator) Up(ctx context.Context) ([]string, error) {
+	return m.UpTo(ctx, "")
+}
+
+// UpTo применяет новые up-миграции до версии включительно одним stage.
+// Вход: ctx для отмены, версия (14 цифр; пустая — все миграции).
+// Выход: выполненные файлы (при ошибке — уже зафиксированные) или error.
+// Назначение: up -to для hotfix-деплоя.
+// UpTo applies pending up migrations up to a version inclusive as one stage.
+// Input: ctx for cancellation, version (14 digits; empty means all migrations).
+// Output: executed files (on error, those already committed) or error.
+// Purpose: up -to for hotfix deployments.
+func (m *Migrator) UpTo(ctx context.Context, version string) ([]string, error) {
+	if err := validateUpTarget(version); err != nil {
+		return nil, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return m.upLocked(ctx, migrations, version)
+}
+
+// upLocked применяет новые up-миграции до версии одним stage; блокировка уже взята.
+// Вход: ctx для отмены, просканированные миграции, версия (пустая — все).
+// Выход: выполненные файлы (при ошибке — уже зафиксированные) или error.
+// Назначение: общее тело UpTo и Fresh.
+// upLocked applies pending up migrations up to a version as one stage; the lock is already held.
+// Input: ctx for cancellation, scanned migrations, version (empty means all).
+// Output: executed files (on error, those already committed) or error.
+// Purpose: shared body of UpTo and Fresh.
+func (m *Migrator) upLocked(ctx context.Context, migrations []Migration, version string) ([]string, error) {
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+
+	pending, stage, err := planUp(ctx, m.db, m.driver, migrations)
+	if err != nil {
+		return nil, err
+	}
+	pending = upToVersion(pending, version)
+	m.logPlan(ctx, DirectionUp, len(pending), stage)
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	appliedFiles := make([]string, 0, len(pending))
+	if err := runUnits(ctx, m.db, m.driver, m.logger, splitUnits(pending, m.txMode),
+		func(tx *sql.Tx, migration Migration, duration time.Duration) error {
+			if err := m.driver.InsertMigration(ctx, tx, MigrationRecord{
+				Migration: migration.Key(),
+				Stage:     stage,
+				Checksum:  migration.Checksum,
+				Origin:    OriginApply,
+				Duration:  duration,
+				Hostname:  m.hostname,
+				Version:   Version,
+			}); err != nil {
+				return fmt.Errorf("record migration %s: %w", migration.Filename, err)
+			}
+			return m.journal(ctx, tx, JournalUp, migration.Key(), stage, migration.Checksum, duration)
+		},
+		func(migration Migration) {
+			appliedFiles = append(appliedFiles, migration.Filename)
+		},
+	); err != nil {
+		return appliedFiles, err
+	}
+
+	return appliedFiles, nil
+}
+
+// Fresh удаляет и пересоздаёт целевую схему, затем применяет все миграции одним stage.
+// Вход: ctx для отмены.
+// Выход: выполненные файлы (при ошибке — уже зафиксированные) или error.
+// Назначение: чистая dev-база за одну команду. Разрушительно: со схемой истории
+// (WithHistoryTable) удаляется вся эта схема, без неё — объекты текущей схемы, кроме
+// служебных таблиц других сервисов (см. Driver.DropSchema); защиту от запуска на
+// чужом окружении обеспечивает вызывающий код.
+// Fresh drops and recreates the target schema, then applies all migrations as one stage.
+// Input: ctx for cancellation.
+// Output: executed files (on error, those already committed) or error.
+// Purpose: a clean dev database in one command. Destructive: with a history schema
+// (WithHistoryTable) that whole schema is dropped, without one the objects of the current
+// schema except other services' bookkeeping tables (see Driver.DropSchema); guarding
+// against running it on the wrong environment is up to the caller.
+func (m *Migrator) Fresh(ctx context.Context) ([]string, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := m.driver.DropSchema(ctx, m.db, DropOptions{Extensions: m.cfg.DropExtensions}); err != nil {
+		m.logger.ErrorContext(ctx, "schema not dropped", "error", err)
+		return nil, fmt.Errorf("drop schema: %w", err)
+	}
+	m.logger.WarnContext(ctx, "schema dropped", "driver", m.driver.Name())
+
+	return m.upLocked(ctx, migrations, "")
+}
+
+// Down откатывает последние стадии; семантика как у ApplyDown.
+// Вход: ctx для отмены, stagesToRollback — количество стадий (1+).
+// Выход: результат отката (при ошибке — уже зафиксированная часть) или error.
+// Назначение: откат из кода приложения.
+// Down rolls back the latest stages; same semantics as ApplyDown.
+// Input: ctx for cancellation, stagesToRollback number of stages (1+).
+// Output: rollback result (on error, the part already committed) or error.
+// Purpose: rollback from application code.
+func (m *Migrator) Down(ctx context.Context, stagesToRollback int) (DownResult, error) {
+	return m.DownTo(ctx, LastStages(stagesToRollback))
+}
+
+// DownTo откатывает миграции до цели (LastStages, ToStage, ToVersion).
+// Вход: ctx для отмены, цель отката.
+// Выход: результат отката (при ошибке — уже зафиксированная часть) или error.
+// Назначение: down -to и -to-stage.
+// DownTo rolls migrations back to a target (LastStages, ToStage, ToVersion).
+// Input: ctx for cancellation, rollback target.
+// Output: rollback result (on error, the part already committed) or error.
+// Purpose: down -to and -to-stage.
+func (m *Migrator) DownTo(ctx context.Context, target DownTarget) (DownResult, error) {
+	if err := target.validate(); err != nil {
+		return DownResult{}, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return DownResult{}, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return DownResult{}, err
+	}
+	defer release()
+
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return DownResult{}, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+
+	items, err := planDown(ctx, m.db, m.driver, migrations, target)
+	if err != nil {
+		return DownResult{}, err
+	}
+	m.logPlan(ctx, DirectionDown, len(items), 0)
+	if len(items) == 0 {
+		return DownResult{}, nil
+	}
+
+	checksums, err := m.appliedChecksums(ctx)
+	if err != nil {
+		return DownResult{}, err
+	}
+
+	rollback := make([]Migration, 0, len(items))
+	stageByName := make(map[string]int, len(items))
+	for _, item := range items {
+		rollback = append(rollback, item.Migration)
+		stageByName[item.Migration.Key()] = item.Stage
+	}
+
+	executed := make([]string, 0, len(rollback))
+	skipped := make([]string, 0)
+	if err := runUnits(ctx, m.db, m.driver, m.logger, splitUnits(rollback, m.txMode),
+		func(tx *sql.Tx, migration Migration, duration time.Duration) error {
+			if err := m.driver.DeleteMigration(ctx, tx, migration.Key()); err != nil {
+				return fmt.Errorf("delete migration %s: %w", migration.Filename, err)
+			}
+			return m.journal(ctx, tx, JournalDown, migration.Key(), stageByName[migration.Key()], checksums[migration.Key()], duration)
+		},
+		func(migration Migration) {
+			if migration.Empty() {
+				skipped = append(skipped, migration.Filename)
+				return
+			}
+			executed = append(executed, migration.Filename)
+		},
+	); err != nil {
+		return DownResult{Executed: executed, Skipped: skipped}, err
+	}
+
+	return DownResult{
+		Executed: executed,
+		Skipped:  skipped,
+	}, nil
+}
+
+// RedoResult содержит результат redo.
+// Назначение: вернуть откаченные и заново применённые файлы.
+// RedoResult holds redo results.
+// Purpose: return rolled back and re-applied files.
+type RedoResult struct {
+	Down DownResult
+	Up   []string
+}
+
+// Reset откатывает все применённые стадии от последней к первой.
+// Вход: ctx для отмены.
+// Выход: результат отката (при ошибке — уже зафиксированная часть) или error.
+// Назначение: вернуть dev-базу к пустой схеме через down-миграции.
+// Reset rolls back every applied stage from the latest to the first.
+// Input: ctx for cancellation.
+// Output: rollback result (on error, the part already committed) or error.
+// Purpose: return a dev database to an empty schema through down migrations.
+func (m *Migrator) Reset(ctx context.Context) (DownResult, error) {
+	return m.DownTo(ctx, ToStage(0))
+}
+
+// Redo откатывает миграции цели и сразу применяет их up-файлы заново с прежними stage.
+// Вход: ctx для отмены, цель отката (например, SingleMigration или LastStages(1)).
+// Выход: результат redo (при ошибке — уже зафиксированная часть) или error.
+// Назначение: исправить сломанную миграцию в dev-базе, не выбрасывая всю стадию.
+// Откат и повторное применение идут одним списком: в режиме all — одной транзакцией.
+// Redo rolls back the target migrations and immediately re-applies their up files with the same stages.
+// Input: ctx for cancellation, rollback target (e.g. SingleMigration or LastStages(1)).
+// Output: redo result (on error, the part already committed) or error.
+// Purpose: fix a broken migration in a dev database without throwing away the whole stage.
+// Rollback and re-apply run as one list: in all mode within a single transaction.
+func (m *Migrator) Redo(ctx context.Context, target DownTarget) (RedoResult, error) {
+	if err := target.validate(); err != nil {
+		return RedoResult{}, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return RedoResult{}, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return RedoResult{}, err
+	}
+	defer release()
+
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return RedoResult{}, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+
+	items, err := planDown(ctx, m.db, m.driver, migrations, target)
+	if err != nil {
+		return RedoResult{}, err
+	}
+	if len(items) == 0 {
+		return RedoResult{}, nil
+	}
+
+	checksums, err := m.appliedChecksums(ctx)
+	if err != nil {
+		return RedoResult{}, err
+	}
+
+	upByName := make(map[string]Migration, len(migrations))
+	for _, migration := range migrations {
+		if migration.Direction == DirectionUp {
+			upByName[migration.Key()] = migration
+		}
+	}
+
+	stageByName := make(map[string]int, len(items))
+	steps := make([]Migration, 0, 2*len(items))
+	for _, item := range items {
+		stageByName[item.Migration.Key()] = item.Stage
+		steps = append(steps, item.Migration)
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		migration, ok := upByName[items[i].Migration.Key()]
+		if !ok {
+			return RedoResult{}, fmt.Errorf("missing up migration for %s", items[i].Migration.Key())
+		}
+		steps = append(steps, migration)
+	}
+	m.logger.InfoContext(ctx, "plan built", "direction", "redo", "target", target.String(), "migrations", len(items), "tx_mode", m.txMode)
+
+	var result RedoResult
+	err = runUnits(ctx, m.db, m.driver, m.logger, splitUnits(steps, m.txMode),
+		func(tx *sql.Tx, migration Migration, duration time.Duration) error {
+			stage := stageByName[migration.Key()]
+			if migration.Direction == DirectionDown {
+				if err := m.driver.DeleteMigration(ctx, tx, migration.Key()); err != nil {
+					return fmt.Errorf("delete migration %s: %w", migration.Filename, err)
+				}
+				return m.journal(ctx, tx, JournalDown, migration.Key(), stage, checksums[migration.Key()], duration)
+			}
+			if err := m.driver.InsertMigration(ctx, tx, MigrationRecord{
+				Migration: migration.Key(),
+				Stage:     stage,
+				Checksum:  migration.Checksum,
+				Origin:    OriginApply,
+				Duration:  duration,
+				Hostname:  m.hostname,
+				Version:   Version,
+			}); err != nil {
+				return fmt.Errorf("record migration %s: %w", migration.Filename, err)
+			}
+			return m.journal(ctx, tx, JournalUp, migration.Key(), stage, migration.Checksum, duration)
+		},
+		func(migration Migration) {
+			switch {
+			case migration.Direction == DirectionUp:
+				result.Up = append(result.Up, migration.Filename)
+			case migration.Empty():
+				result.Down.Skipped = append(result.Down.Skipped, migration.Filename)
+			default:
+				result.Down.Executed = append(result.Down.Executed, migration.Filename)
+			}
+		},
+	)
+	return result, err
+}
+
+// Baseline записывает все миграции до версии включительно как применённые, не выполняя их SQL.
+// Вход: ctx для отмены, версия (14 цифр, обязательна).
+// Выход: записанные ключи миграций или error.
+// Назначение: внедрить lamigrate в базу, схема которой уже существует.
+// Записи получают отдельный новый stage и origin=baseline; всё пишется одной транзакцией.
+// Baseline records every migration up to a version inclusive as applied without running its SQL.
+// Input: ctx for cancellation, version (14 digits, required).
+// Output: recorded migration keys or error.
+// Purpose: adopt lamigrate on a database whose schema already exists.
+// Records get a dedicated new stage and origin=baseline; everything is written in one transaction.
+func (m *Migrator) Baseline(ctx context.Context, version string) ([]string, error) {
+	if version == "" {
+		return nil, fmt.Errorf("baseline version is required")
+	}
+	if err := validateUpTarget(version); err != nil {
+		return nil, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+
+	pending, stage, err := planUp(ctx, m.db, m.driver, migrations)
+	if err != nil {
+		return nil, err
+	}
+	pending = upToVersion(pending, version)
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	return m.record(ctx, pending, stage, OriginBaseline)
+}
+
+// MarkApplied записывает отдельные миграции как применённые, не выполняя их SQL.
+// Вход: ctx для отмены, ключи "version_name" или версии.
+// Выход: записанные ключи или error, если миграция не найдена или уже применена.
+// Назначение: отметить миграцию, которую DBA выполнил вручную. Записи получают
+// новый stage и origin=manual.
+// MarkApplied records individual migrations as applied without running their SQL.
+// Input: ctx for cancellation, "version_name" keys or versions.
+// Output: recorded keys or error if a migration is not found or already applied.
+// Purpose: mark a migration a DBA ran by hand. Records get a new stage and
+// origin=manual.
+func (m *Migrator) MarkApplied(ctx context.Context, keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no migrations to mark")
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	upByName := map[string]Migration{}
+	names := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		if migration.Direction == DirectionUp {
+			upByName[migration.Key()] = migration
+			names = append(names, migration.Key())
+		}
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+
+	applied, err := m.driver.AppliedMigrations(ctx, m.db)
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	appliedSet := make(map[string]struct{}, len(applied))
+	for _, item := range applied {
+		appliedSet[item.Migration] = struct{}{}
+	}
+
+	selected := make([]Migration, 0, len(keys))
+	seen := map[string]struct{}{}
+	for _, key := range keys {
+		name, ok, err := matchKey(names, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("migration %s not found", key)
+		}
+		if _, done := appliedSet[name]; done {
+			return nil, fmt.Errorf("migration %s is already applied", name)
+		}
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		selected = append(selected, upByName[name])
+	}
+
+	stage, err := m.driver.MaxStage(ctx, m.db)
+	if err != nil {
+		return nil, fmt.Errorf("read max stage: %w", err)
+	}
+
+	return m.record(ctx, selected, stage+1, OriginManual)
+}
+
+// Unmark удаляет записи о миграциях из lamigrate, не выполняя их down-SQL.
+// Вход: ctx для отмены, ключи "version_name" или версии применённых миграций.
+// Выход: удалённые ключи или error, если миграция не применена.
+// Назначение: исправить историю после ручного отката или ошибочного mark-applied;
+// работает и для миграций, файлов которых уже нет.
+// Unmark removes migration records from lamigrate without running their down SQL.
+// Input: ctx for cancellation, "version_name" keys or versions of applied migrations.
+// Output: removed keys or error if a migration is not applied.
+// Purpose: fix history after a manual rollback or a mistaken mark-applied;
+// also works for migrations whose files are gone.
+func (m *Migrator) Unmark(ctx context.Context, keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no migrations to unmark")
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+
+	applied, err := m.driver.AppliedMigrations(ctx, m.db)
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	names := make([]string, 0, len(applied))
+	appliedByName := make(map[string]AppliedMigration, len(applied))
+	for _, item := range applied {
+		names = append(names, item.Migration)
+		appliedByName[item.Migration] = item
+	}
+
+	selected := make([]string, 0, len(keys))
+	seen := map[string]struct{}{}
+	for _, key := range keys {
+		name, ok, err := matchKey(names, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("migration %s is not applied", key)
+		}
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		selected = append(selected, name)
+	}
+
+	if err := m.driver.WithTransaction(ctx, m.db, func(tx *sql.Tx) error {
+		for _, name := range selected {
+			if err := m.driver.DeleteMigration(ctx, tx, name); err != nil {
+				return fmt.Errorf("delete migration %s: %w", name, err)
+			}
+			item := appliedByName[name]
+			if err := m.journal(ctx, tx, JournalUnmark, name, item.Stage, item.Checksum, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, name := range selected {
+		m.logger.WarnContext(ctx, "migration unmarked", "migration", name)
+	}
+	return selected, nil
+}
+
+// record записывает миграции в lamigrate одной транзакцией без выполнения SQL.
+// Вход: ctx для отмены, миграции, stage, происхождение записи.
+// Выход: записанные ключи или error.
+// Назначение: общее тело Baseline и MarkApplied.
+// record writes migrations to lamigrate in one transaction without running SQL.
+// Input: ctx for cancellation, migrations, stage, record origin.
+// Output: recorded keys or error.
+// Purpose: shared body of Baseline and MarkApplied.
+func (m *Migrator) record(ctx context.Context, migrations []Migration, stage int, origin Origin) ([]string, error) {
+	action := JournalBaseline
+	if origin == OriginManual {
+		action = JournalMark
+	}
+
+	keys := make([]string, 0, len(migrations))
+	if err := m.driver.WithTransaction(ctx, m.db, func(tx *sql.Tx) error {
+		for _, migration := range migrations {
+			if err := m.driver.InsertMigration(ctx, tx, MigrationRecord{
+				Migration: migration.Key(),
+				Stage:     stage,
+				Checksum:  migration.Checksum,
+				Origin:    origin,
+				Hostname:  m.hostname,
+				Version:   Version,
+			}); err != nil {
+				return fmt.Errorf("record migration %s: %w", migration.Filename, err)
+			}
+			if err := m.journal(ctx, tx, action, migration.Key(), stage, migration.Checksum, 0); err != nil {
+				return err
+			}
+			keys = append(keys, migration.Key())
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		m.logger.InfoContext(ctx, "migration recorded without running", "migration", key, "stage", stage, "origin", origin)
+	}
+	return keys, nil
+}
+
+// Applied возвращает записи lamigrate только на чтение (пусто, если таблицы нет).
+// Вход: ctx для отмены.
+// Выход: применённые миграции, ErrMetaTooNew для служебных таблиц более новой версии или error.
+// Назначение: то же, что ListApplied, на пуле вызывающего; блокировку не берёт и схему не обновляет.
+// Applied returns lamigrate records read-only (empty if the table is missing).
+// Input: ctx for cancellation.
+// Output: applied migrations, ErrMetaTooNew for bookkeeping tables of a newer version, or error.
+// Purpose: same as ListApplied on the caller's pool; takes no lock and never upgrades the schema.
+func (m *Migrator) Applied(ctx context.Context) ([]AppliedMigration, error) {
+	reader, exists, err := existingSchema(ctx, m.db, m.driver)
+	if err != nil {
+		return nil, fmt.Errorf("check lamigrate schema: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	applied, err := reader.AppliedMigrations(ctx, m.db)
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// StatusReport — состояние миграций относительно файлов.
+// Назначение: одна структура для status в CLI и для проверок в приложении.
+// Pending — неприменённые up-миграции, Missing — применённые записи без up-файла,
+// Drift — применённые файлы, изменённые после применения.
+// StatusReport is the state of migrations relative to files.
+// Purpose: one structure for the CLI status and application checks.
+// Pending are unapplied up migrations, Missing are applied records without an up file,
+// Drift are applied files edited after they were applied.
+type StatusReport struct {
+	Applied []AppliedMigration
+	Pending []Migration
+	Missing []AppliedMigration
+	Drift   []ChecksumMismatch
+}
+
+// Status сравнивает историю lamigrate с миграциями без изменения БД.
+// Вход: ctx для отмены.
+// Выход: StatusReport (если таблицы нет, все up-миграции в Pending) или error.
+// Назначение: команда status и health-check при старте.
+// Status compares lamigrate history with migrations without changing the database.
+// Input: ctx for cancellation.
+// Output: StatusReport (all up migrations are Pending when the table is missing) or error.
+// Purpose: the status command and startup health checks.
+func (m *Migrator) Status(ctx context.Context) (StatusReport, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return StatusReport{}, err
+	}
+
+	reader, exists, err := existingSchema(ctx, m.db, m.driver)
+	if err != nil {
+		return StatusReport{}, fmt.Errorf("check lamigrate schema: %w", err)
+	}
+
+	var applied []AppliedMigration
+	if exists {
+		applied, err = reader.AppliedMigrations(ctx, m.db)
+		if err != nil {
+			return StatusReport{}, fmt.Errorf("read applied migrations: %w", err)
+		}
+	}
+
+	knownUp := make(map[string]struct{})
+	for _, migration := range migrations {
+		if migration.Direction == DirectionUp {
+			knownUp[migration.Key()] = struct{}{}
+		}
+	}
+
+	report := StatusReport{
+		Applied: applied,
+		Pending: pendingUp(migrations, applied),
+		Drift:   FindChecksumMismatches(migrations, applied),
+	}
+	for _, item := range applied {
+		if _, ok := knownUp[item.Migration]; !ok {
+			report.Missing = append(report.Missing, item)
+		}
+	}
+	return report, nil
+}
+
+// Plan строит план up или down без записи в БД и без блокировки.
+// Вход: ctx для отмены, направление, stagesToRollback (только для down, 1+).
+// Выход: план (пустой, если делать нечего) или error.
+// Назначение: dry-run из кода приложения.
+// Plan builds an up or down plan without writes and without the lock.
+// Input: ctx for cancellation, direction, stagesToRollback (down only, 1+).
+// Output: plan (empty when there is nothing to do) or error.
+// Purpose: dry-run from application code.
+func (m *Migrator) Plan(ctx context.Context, direction Direction, stagesToRollback int) (Plan, error) {
+	switch direction {
+	case DirectionUp:
+		return m.PlanUpTo(ctx, "")
+	case DirectionDown:
+		return m.PlanDownTo(ctx, LastStages(stagesToRollback))
+	default:
+		return Plan{}, fmt.Errorf("unknown direction: %s", direction)
+	}
+}
+
+// PlanUpTo строит план применения новых up-миграций до версии включительно.
+// Вход: ctx для отмены, версия (пустая — все миграции).
+// Выход: план или error.
+// Назначение: dry-run для up и up -to.
+// PlanUpTo builds a plan for applying pending up migrations up to a version inclusive.
+// Input: ctx for cancellation, version (empty means all migrations).
+// Output: plan or error.
+// Purpose: dry-run for up and up -to.
+func (m *Migrator) PlanUpTo(ctx context.Context, version string) (Plan, error) {
+	if err := validateUpTarget(version); err != nil {
+		return Plan{}, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{Direction: DirectionUp, TxMode: m.txMode}
+
+	reader, exists, err := existingSchema(ctx, m.db, m.driver)
+	if err != nil {
+		return Plan{}, fmt.Errorf("check lamigrate schema: %w", err)
+	}
+
+	var pending []Migration
+	stage := 1
+	if exists {
+		pending, stage, err = planUp(ctx, m.db, reader, migrations)
+		if err != nil {
+			return Plan{}, err
+		}
+	} else {
+		pending = pendingUp(migrations, nil)
+	}
+	pending = upToVersion(pending, version)
+
+	stages := make([]int, len(pending))
+	for i := range stages {
+		stages[i] = stage
+	}
+	plan.Items = planItems(pending, stages, m.txMode)
+	m.logPlan(ctx, DirectionUp, len(plan.Items), stage)
+	return plan, nil
+}
+
+// PlanDownTo строит план отката до цели.
+// Вход: ctx для отмены, цель отката.
+// Выход: план или error.
+// Назначение: dry-run для down, down -to и -to-stage.
+// PlanDownTo builds a plan for rolling back to a target.
+// Input: ctx for cancellation, rollback target.
+// Output: plan or error.
+// Purpose: dry-run for down, down -to and -to-stage.
+func (m *Migrator) PlanDownTo(ctx context.Context, target DownTarget) (Plan, error) {
+	if err := target.validate(); err != nil {
+		return Plan{}, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{Direction: DirectionDown, TxMode: m.txMode}
+
+	reader, exists, err := existingSchema(ctx, m.db, m.driver)
+	if err != nil {
+		return Plan{}, fmt.Errorf("check lamigrate schema: %w", err)
+	}
+	if !exists {
+		return plan, nil
+	}
+
+	items, err := planDown(ctx, m.db, reader, migrations, target)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	rollback := make([]Migration, 0, len(items))
+	stages := make([]int, 0, len(items))
+	for _, item := range items {
+		rollback = append(rollback, item.Migration)
+		stages = append(stages, item.Stage)
+	}
+	plan.Items = planItems(rollback, stages, m.txMode)
+	m.logPlan(ctx, DirectionDown, len(plan.Items), 0)
+	return plan, nil
+}
+
+// Verify сравнивает применённые миграции с checksum их up-файлов; как свободная Verify.
+// Вход: ctx для отмены.
+// Выход: список расхождений или error.
+// Назначение: проверка дрейфа из кода приложения.
+// Verify compares applied migrations with checksums of their up files; like the free Verify.
+// Input: ctx for cancellation.
+// Output: list of mismatches or error.
+// Purpose: drift checks from application code.
+func (m *Migrator) Verify(ctx context.Context) ([]ChecksumMismatch, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindChecksumMismatches(migrations, applied), nil
+}
+
+// Repair перезаписывает сохранённые checksum по текущим up-файлам; как свободная Repair.
+// Вход: ctx для отмены.
+// Выход: список исправленных записей или error.
+// Назначение: принять правки применённых файлов.
+// Repair rewrites stored checksums from the current up files; like the free Repair.
+// Input: ctx for cancellation.
+// Output: list of repaired records or error.
+// Purpose: accept edits of applied files.
+func (m *Migrator) Repair(ctx context.Context) ([]ChecksumMismatch, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := ensureSchema(ctx, m.db, m.driver); err != nil {
+		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
+	}
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	upByName := make(map[string]Migration, len(migrations))
+	for _, migration := range migrations {
+		if migration.Direction != DirectionUp {
+			continue
+		}
+		upByName[migration.Key()] = migration
+	}
+
+	var repaired []ChecksumMismatch
+	stageByName := make(map[string]int, len(applied))
+	for _, item := range applied {
+		stageByName[item.Migration] = item.Stage
+		migration, ok := upByName[item.Migration]
+		if !ok || migration.Checksum == item.Checksum {
+			continue
+		}
+		repaired = append(repaired, ChecksumMismatch{
+			Migration: item.Migration,
+			Filename:  migration.Filename,
+			Applied:   item.Checksum,
+			Current:   migration.Checksum,
+		})
+	}
+
+	if len(repaired) == 0 {
+		return nil, nil
+	}
+
+	if err := m.driver.WithTransaction(ctx, m.db, func(tx *sql.Tx) error {
+		for _, item := range repaired {
+			if err := m.driver.UpdateChecksum(ctx, tx, item.Migration, item.Current); err != nil {
+				return fmt.Errorf("update checksum %s: %w", item.Filename, err)
+			}
+			if err := m.journal(ctx, tx, JournalRepair, item.Migration, stageByName[item.Migration], item.Current, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return repaired, nil
+}
@@ -0,0 +1,56 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+// nonTransactionalDriver коммитит DDL неявно, остальные методы Driver не вызываются.
+// nonTransactionalDriver commits DDL implicitly; other Driver methods are never called.
+type nonTransactionalDriver struct {
+	Driver
+}
+
+func (nonTransactionalDriver) Name() string           { return "stub" }
+func (nonTransactionalDriver) TransactionalDDL() bool { return false }
+
+// warnLogger запоминает предупреждения, остальные события отбрасывает.
+// warnLogger records warnings and discards other events.
+type warnLogger struct {
+	nopLogger
+	warnings []string
+}
+
+func (l *warnLogger) WarnContext(_ context.Context, msg string, _ ...any) {
+	l.warnings = append(l.warnings, msg)
+}
+
+func TestNewMigratorWarnsOnDowngrade(t *testing.T) {
+	tests := []struct {
+		mode     TxMode
+		wantMode TxMode
+		wantWarn bool
+	}{
+		{mode: "", wantMode: TxModePerMigration, wantWarn: true},
+		{mode: TxModeAll, wantMode: TxModePerMigration, wantWarn: true},
+		{mode: TxModePerMigration, wantMode: TxModePerMigration},
+		{mode: TxModeNone, wantMode: TxModeNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			logger := &warnLogger{}
+			m, err := NewMigrator(&sql.DB{}, nonTransactionalDriver{}, WithTxMode(tt.mode), WithLogger(logger))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.txMode != tt.wantMode {
+				t.Fatalf("tx mode = %s, want %s", m.txMode, tt.wantMode)
+			}
+			if (len(logger.warnings) > 0) != tt.wantWarn {
+				t.Fatalf("warnings = %v, want a warning %v", logger.warnings, tt.wantWarn)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Plan описывает, что выполнит up или down, без изменения БД.
+// Назначение: показать ревьюерам упорядоченный список файлов и стадий.
+// Plan describes what up or down would execute without changing the database.
+// Purpose: show reviewers the ordered list of files and stages.
+type Plan struct {
+	Direction Direction
+	TxMode    TxMode
+	Items     []PlanItem
+}
+
+// PlanItem — одна миграция в плане.
+// Назначение: хранить файл, stage и номер транзакционной группы.
+// Stage — назначаемый stage для up и откатываемый stage для down.
+// TxGroup — номер транзакции (с 1), 0 — выполнение без транзакции.
+// PlanItem is a single migration in a plan.
+// Purpose: hold the file, stage and transaction group number.
+// Stage is the stage to assign for up and the stage being rolled back for down.
+// TxGroup is the transaction number (from 1), 0 means no transaction.
+type PlanItem struct {
+	Migration Migration
+	Stage     int
+	TxGroup   int
+}
+
+// PlanUp строит план применения новых up-миграций.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver.
+// Выход: план (пустой, если применять нечего) или error.
+// Назначение: dry-run для up без записи в БД и без блокировки.
+// PlanUp builds a plan for applying pending up migrations.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation.
+// Output: plan (empty when nothing is pending) or error.
+// Purpose: up dry-run without writes and without the lock.
+func PlanUp(ctx context.Context, cfg Config, driver Driver) (Plan, error) {
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer db.Close()
+
+	return m.Plan(ctx, DirectionUp, 0)
+}
+
+// PlanUpTo строит план применения новых up-миграций до версии включительно.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver, версия.
+// Выход: план или error.
+// Назначение: dry-run для up -to.
+// PlanUpTo builds a plan for applying pending up migrations up to a version inclusive.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation, version.
+// Output: plan or error.
+// Purpose: up -to dry-run.
+func PlanUpTo(ctx context.Context, cfg Config, driver Driver, version string) (Plan, error) {
+	if err := validateUpTarget(version); err != nil {
+		return Plan{}, err
+	}
+
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer db.Close()
+
+	return m.PlanUpTo(ctx, version)
+}
+
+// PlanDown строит план отката последних стадий.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver,
+// stagesToRollback — количество стадий (1+).
+// Выход: план (пустой, если откатывать нечего) или error.
+// Назначение: dry-run для down без записи в БД и без блокировки.
+// PlanDown builds a plan for rolling back the latest stages.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation,
+// stagesToRollback number of stages (1+).
+// Output: plan (empty when there is nothing to roll back) or error.
+// Purpose: down dry-run without writes and without the lock.
+func PlanDown(ctx context.Context, cfg Config, driver Driver, stagesToRollback int) (Plan, error) {
+	if stagesToRollback <= 0 {
+		return Plan{}, fmt.Errorf("stages to rollback must be positive")
+	}
+
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer db.Close()
+
+	return m.Plan(ctx, DirectionDown, stagesToRollback)
+}
+
+// PlanDownTo строит план отката до цели (LastStages, ToStage, ToVersion).
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver, цель.
+// Выход: план или error.
+// Назначение: dry-run для down -to и -to-stage.
+// PlanDownTo builds a plan for rolling back to a target (LastStages, ToStage, ToVersion).
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation, target.
+// Output: plan or error.
+// Purpose: down -to and -to-stage dry-run.
+func PlanDownTo(ctx context.Context, cfg Config, driver Driver, target DownTarget) (Plan, error) {
+	if err := target.validate(); err != nil {
+		return Plan{}, err
+	}
+
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer db.Close()
+
+	return m.PlanDownTo(ctx, target)
+}
+
+// planItems раскладывает миграции по транзакционным группам.
+// Вход: миграции, stage для каждой и режим транзакций.
+// Выход: элементы плана с номерами групп.
+// Назначение: показать в плане те же границы транзакций, что и при выполнении.
+// planItems lays migrations out into transaction groups.
+// Input: migrations, stage of each and transaction mode.
+// Output: plan items with group numbers.
+// Purpose: show the same transaction boundaries in a plan as during execution.
+func planItems(migrations []Migration, stages []int, txMode TxMode) []PlanItem {
+	items := make([]PlanItem, 0, len(migrations))
+	group := 0
+	for _, unit := range splitUnits(migrations, txMode) {
+		txGroup := 0
+		if unit.transactional {
+			group++
+			txGroup = group
+		}
+		for _, migration := range unit.migrations {
+			items = append(items, PlanItem{
+				Migration: migration,
+				Stage:     stages[len(items)],
+				TxGroup:   txGroup,
+			})
+		}
+	}
+	return items
+}
+
+// planUp вычисляет новые up-миграции и следующий stage.
+// Вход: ctx для отмены, db соединение, driver, просканированные миграции.
+// Выход: миграции к применению, новый stage или error (в т.ч. *ChecksumError).
+// Назначение: общая логика ApplyUp и PlanUp.
+// planUp computes pending up migrations and the next stage.
+// Input: ctx for cancellation, db connection, driver, scanned migrations.
+// Output: migrations to apply, new stage or error (including *ChecksumError).
+// Purpose: shared logic of ApplyUp and PlanUp.
+func planUp(ctx context.Context, db *sql.DB, driver Driver, migrations []Migration) ([]Migration, int, error) {
+	appliedList, err := driver.AppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read applied migrations: %w", err)
+	}
+
+	if err := VerifyChecksums(migrations, appliedList); err != nil {
+		return nil, 0, err
+	}
+
+	pending := pendingUp(migrations, appliedList)
+	if len(pending) == 0 {
+		return nil, 0, nil
+	}
+
+	stage, err := driver.MaxStage(ctx, db)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read max stage: %w", err)
+	}
+
+	return pending, stage + 1, nil
+}
+
+// planDown вычисляет down-миграции для отката до цели.
+// Вход: ctx для отмены, db соединение, driver, просканированные миграции, цель отката.
+// Выход: элементы плана в порядке отката (без TxGroup) или error.
+// Назначение: общая логика ApplyDown и PlanDown.
+// Стадии обходятся от последней; для ToVersion стадия может откатиться частично.
+// planDown computes down migrations for rolling back to a target.
+// Input: ctx for cancellation, db connection, driver, scanned migrations, rollback target.
+// Output: plan items in rollback order (without TxGroup) or error.
+// Purpose: shared logic of ApplyDown and PlanDown.
+// Stages are walked from the latest; with ToVersion a stage may be rolled back partially.
+func planDown(ctx context.Context, db *sql.DB, driver Driver, migrations []Migration, target DownTarget) ([]PlanItem, error) {
+	downByName := map[string]Migration{}
+	for _, migration := range migrations {
+		if migration.Direction != DirectionDown {
+			continue
+		}
+		downByName[migration.Key()] = migration
+	}
+
+	if target.kind == downMigration {
+		return planDownSingle(ctx, db, driver, downByName, target)
+	}
+
+	stages, err := driver.StagesDesc(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("read stages: %w", err)
+	}
+
+	var items []PlanItem
+	for taken, stage := range stages {
+		if target.kind == downLastStages && taken == target.stages {
+			break
+		}
+		if target.kind == downToStage && stage <= target.stage {
+			break
+		}
+
+		names, err := driver.MigrationsByStage(ctx, db, stage)
+		if err != nil {
+			return nil, fmt.Errorf("read migrations for stage %d: %w", stage, err)
+		}
+		for _, name := range names {
+			if target.kind == downToVersion && keyVersion(name) <= target.version {
+				continue
+			}
+			migration, ok := downByName[name]
+			if !ok {
+				return nil, fmt.Errorf("missing down migration for %s", name)
+			}
+			items = append(items, PlanItem{Migration: migration, Stage: stage})
+		}
+	}
+
+	return items, nil
+}
+
+// planDownSingle вычисляет откат одной миграции вне порядка стадий.
+// Вход: ctx для отмены, db соединение, driver, down-миграции по ключу, цель SingleMigration.
+// Выход: один элемент плана или error, если миграция не применена или после
+// неё применены другие (без force).
+// Назначение: down -migration и redo -migration. Проверка только по порядку применения:
+// зависимости между миграциями lamigrate не знает, поэтому отказывает и тогда, когда
+// более поздние миграции её не используют, и не спасает, если зависимость есть при force.
+// planDownSingle computes the rollback of one migration out of stage order.
+// Input: ctx for cancellation, db connection, driver, down migrations by key, SingleMigration target.
+// Output: one plan item or error when the migration is not applied or others
+// were applied after it (without force).
+// Purpose: down -migration and redo -migration. The check is by application order only:
+// lamigrate does not know dependencies between migrations, so it refuses even when later
+// migrations do not use this one, and offers no protection under force when they do.
+func planDownSingle(ctx context.Context, db *sql.DB, driver Driver, downByName map[string]Migration, target DownTarget) ([]PlanItem, error) {
+	applied, err := driver.AppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+
+	names := make([]string, len(applied))
+	for i, item := range applied {
+		names[i] = item.Migration
+	}
+	name, ok, err := matchKey(names, target.key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("migration %s is not applied", target.key)
+	}
+	var found AppliedMigration
+	for _, item := range applied {
+		if item.Migration == name {
+			found = item
+		}
+	}
+
+	if !target.force {
+		var later []string
+		for _, item := range applied {
+			if item.Stage > found.Stage || (item.Stage == found.Stage && keyAfter(item.Migration, found.Migration)) {
+				later = append(later, item.Migration)
+			}
+		}
+		if len(later) > 0 {
+			return nil, fmt.Errorf("cannot roll back %s alone: migrations applied after it: %s (lamigrate checks only the order of application, not whether they depend on it; use force if they do not)",
+				found.Migration, strings.Join(later, ", "))
+		}
+	}
+
+	migration, ok := downByName[found.Migration]
+	if !ok {
+		return nil, fmt.Errorf("missing down migration for %s", found.Migration)
+	}
+	return []PlanItem{{Migration: migration, Stage: found.Stage}}, nil
+}
+
+// existingSchema проверяет таблицу lamigrate и версию служебной схемы, ничего не меняя.
+// Вход: ctx для отмены, db соединение, driver.
+// Выход: драйвер для чтения (для необновлённой базы — driver.WithMetaVersion её версии),
+// true, если таблица есть; ErrMetaTooNew, если базу обновила более новая версия lamigrate;
+// error при ошибке проверки.
+// Назначение: status, plan, script и history только читают БД и не берут блокировку,
+// поэтому работают и со служебными таблицами старой версии, не обновляя их.
+// existingSchema checks the lamigrate table and the bookkeeping schema version without changes.
+// Input: ctx for cancellation, db connection, driver.
+// Output: the driver to read with (driver.WithMetaVersion of its version for a database not
+// upgraded yet), true if the table exists; ErrMetaTooNew if a newer lamigrate upgraded the
+// database; error on check failure.
+// Purpose: status, plan, script and history only read the database and take no lock,
+// so they also work with bookkeeping tables of an older version without upgrading them.
+func existingSchema(ctx context.Context, db *sql.DB, driver Driver) (Driver, bool, error) {
+	exists, err := driver.SchemaExists(ctx, db)
+	if err != nil || !exists {
+		return driver, exists, err
+	}
+	upgrades, err := metaUpgrades(driver)
+	if err != nil {
+		return nil, false, err
+	}
+	version, err := checkMetaVersion(ctx, db, driver, len(upgrades))
+	if err != nil {
+		return nil, false, err
+	}
+	if version < len(upgrades) {
+		driver = driver.WithMetaVersion(version)
+	}
+	return driver, true, nil
+}
+
+// pendingUp возвращает up-миграции, которых нет в истории.
+// Вход: просканированные миграции и применённые записи.
+// Выход: упорядоченный список неприменённых up-миграций.
+// Назначение: общий расчёт для плана, apply и генерации скрипта.
+// pendingUp returns up migrations missing from history.
+// Input: scanned migrations and applied records.
+// Output: ordered list of pending up migrations.
+// Purpose: shared computation for planning, apply and script generation.
+func pendingUp(migrations []Migration, appliedList []AppliedMigration) []Migration {
+	applied := make(map[string]struct{}, len(appliedList))
+	for _, item := range appliedList {
+		applied[item.Migration] = struct{}{}
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Direction != DirectionUp {
+			continue
+		}
+
+		if _, exists := applied[migration.Key()]; exists {
+			continue
+		}
+
+		pending = append(pending, migration)
+	}
+	return pending
+}
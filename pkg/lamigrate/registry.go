@@ -0,0 +1,135 @@
+package lamigrate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DriverFactory создаёт новый экземпляр драйвера.
+// Назначение: регистрировать драйверы без их создания при импорте.
+// DriverFactory creates a new driver instance.
+// Purpose: register drivers without constructing them on import.
+type DriverFactory func() Driver
+
+var (
+	registryMu sync.RWMutex
+	factories  = map[string]DriverFactory{}
+	schemes    = map[string]string{}
+)
+
+// Register регистрирует драйвер под именем.
+// Вход: имя драйвера и фабрика.
+// Выход: нет; паникует при пустом имени, nil-фабрике или повторной регистрации.
+// Назначение: драйверы регистрируют себя в init(), CLI и сторонние программы
+// подключают их пустым импортом. Имя также становится DSN-схемой ("name://").
+// Register registers a driver under a name.
+// Input: driver name and factory.
+// Output: none; panics on an empty name, nil factory or duplicate registration.
+// Purpose: drivers register themselves in init(), the CLI and third-party
+// programs enable them with a blank import. The name also becomes a DSN scheme ("name://").
+func Register(name string, factory DriverFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" {
+		panic("lamigrate: Register driver name is empty")
+	}
+	if factory == nil {
+		panic("lamigrate: Register driver factory is nil for " + name)
+	}
+	if _, dup := factories[name]; dup {
+		panic("lamigrate: Register called twice for driver " + name)
+	}
+	factories[name] = factory
+	if _, taken := schemes[name]; !taken {
+		schemes[name] = name
+	}
+}
+
+// RegisterScheme связывает дополнительную DSN-схему с драйвером.
+// Вход: схема без "://" и имя драйвера.
+// Выход: нет.
+// Назначение: автоопределение для синонимов (postgresql://, mariadb://).
+// RegisterScheme maps an extra DSN scheme to a driver.
+// Input: scheme without "://" and driver name.
+// Output: none.
+// Purpose: auto-detection for aliases (postgresql://, mariadb://).
+func RegisterScheme(scheme, name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	schemes[strings.ToLower(scheme)] = name
+}
+
+// Lookup создаёт зарегистрированный драйвер по имени.
+// Вход: имя драйвера.
+// Выход: новый экземпляр драйвера или error со списком доступных драйверов.
+// Назначение: выбрать драйвер по флагу -driver или конфигу.
+// Lookup creates a registered driver by name.
+// Input: driver name.
+// Output: new driver instance or error listing available drivers.
+// Purpose: pick a driver from the -driver flag or config.
+func Lookup(name string) (Driver, error) {
+	registryMu.RLock()
+	factory, ok := factories[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		available := Drivers()
+		if len(available) == 0 {
+			return nil, fmt.Errorf("unsupported driver: %s (no drivers registered)", name)
+		}
+		return nil, fmt.Errorf("unsupported driver: %s (available: %s)", name, strings.Join(available, ", "))
+	}
+	return factory(), nil
+}
+
+// Drivers возвращает имена зарегистрированных драйверов.
+// Вход: нет.
+// Выход: отсортированный список имён.
+// Назначение: показать доступные драйверы в help и ошибках.
+// Drivers returns names of registered drivers.
+// Input: none.
+// Output: sorted list of names.
+// Purpose: show available drivers in help and errors.
+func Drivers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DetectDriver определяет имя драйвера по схеме DSN.
+// Вход: DSN вида "scheme://...".
+// Выход: имя драйвера и true, если схема зарегистрирована.
+// Назначение: не требовать -driver, когда он очевиден из DSN.
+// DSN без "://" (например, "user:pass@tcp(host)/db" или путь к файлу) не определяются.
+// DetectDriver detects the driver name from the DSN scheme.
+// Input: DSN like "scheme://...".
+// Output: driver name and true if the scheme is registered.
+// Purpose: do not require -driver when it is obvious from the DSN.
+// DSNs without "://" (e.g. "user:pass@tcp(host)/db" or a file path) are not detected.
+func DetectDriver(dsn string) (string, bool) {
+	scheme, _, ok := strings.Cut(dsn, "://")
+	if !ok || scheme == "" {
+		return "", false
+	}
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	name, ok := schemes[strings.ToLower(scheme)]
+	if !ok {
+		return "", false
+	}
+	if _, registered := factories[name]; !registered {
+		return "", false
+	}
+	return name, true
+}
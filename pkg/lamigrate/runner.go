@@ -2,224 +2,206 @@ package lamigrate
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"os"
-	"strings"
 )
 
-// ApplyUp выполняет все новые up-миграции в одной транзакции.
+// ApplyUp выполняет все новые up-миграции в одной транзакции (cfg.TxMode = all).
+// Режимы per-migration и none фиксируют каждую миграцию отдельно; миграции с
+// директивой no-transaction выполняются вне транзакции, а соседние группы
+// миграций — каждая в своей транзакции. Все миграции запуска получают один stage.
 // Вход: ctx для отмены, cfg с DSN и директорией, реализация driver.
-// Выход: список выполненных файлов и error при ошибках валидации, IO, БД или выполнения.
+// Выход: список выполненных файлов и error при ошибках валидации, IO, БД или выполнения;
+// при ошибке список содержит уже зафиксированные файлы.
 // Назначение: атомарно применить новый stage и записать его в lamigrate.
-// ApplyUp executes all pending up migrations in a single transaction.
+// Весь цикл планирования и применения выполняется под блокировкой миграций.
+// Если применённые файлы изменились, возвращает *ChecksumError до выполнения SQL.
+// ApplyUp executes all pending up migrations in a single transaction (cfg.TxMode = all).
+// The per-migration and none modes commit each migration separately; migrations
+// with the no-transaction directive run outside a transaction and the surrounding
+// groups of migrations each run in their own transaction. All migrations of one
+// run share one stage.
 // Input: ctx for cancellation, cfg with DSN and directory, driver implementation.
-// Output: list of executed filenames and error on failures.
+// Output: list of executed filenames and error on failures;
+// on error the list holds the files already committed.
 // Purpose: atomically apply a new stage and store it in lamigrate.
+// The whole plan-and-apply cycle runs under the migration lock.
+// Returns *ChecksumError before running any SQL if applied files were edited.
 func ApplyUp(ctx context.Context, cfg Config, driver Driver) ([]string, error) {
-	if cfg.MigrationsDir == "" {
-		return nil, fmt.Errorf("migrations dir is empty")
-	}
-	if cfg.DSN == "" {
-		return nil, fmt.Errorf("dsn is empty")
-	}
-
-	migrations, err := ScanMigrations(cfg.MigrationsDir)
+	m, db, err := openMigrator(cfg, driver)
 	if err != nil {
 		return nil, err
 	}
-
-	db, err := driver.Open(cfg.DSN)
-	if err != nil {
-		return nil, fmt.Errorf("open database: %w", err)
-	}
 	defer db.Close()
 
-	if err := driver.EnsureSchema(ctx, db); err != nil {
-		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
-	}
-
-	appliedList, err := driver.AppliedMigrations(ctx, db)
-	if err != nil {
-		return nil, fmt.Errorf("read applied migrations: %w", err)
-	}
-
-	applied := make(map[string]struct{}, len(appliedList))
-	for _, item := range appliedList {
-		applied[item.Migration] = struct{}{}
-	}
-
-	var pending []Migration
-	for _, migration := range migrations {
-		if migration.Direction != DirectionUp {
-			continue
-		}
-
-		if _, exists := applied[migration.Key()]; exists {
-			continue
-		}
-
-		pending = append(pending, migration)
-	}
+	return m.Up(ctx)
+}
 
-	if len(pending) == 0 {
-		return nil, nil
+// ApplyUpTo применяет новые up-миграции до версии включительно одним stage.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver, версия (14 цифр).
+// Выход: список выполненных файлов и error, как у ApplyUp.
+// Назначение: остановиться на известной схеме при hotfix-деплое.
+// ApplyUpTo applies pending up migrations up to a version inclusive as one stage.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation, version (14 digits).
+// Output: list of executed filenames and error, as in ApplyUp.
+// Purpose: stop at a known schema during hotfix deployments.
+func ApplyUpTo(ctx context.Context, cfg Config, driver Driver, version string) ([]string, error) {
+	if err := validateUpTarget(version); err != nil {
+		return nil, err
 	}
 
-	stage, err := driver.MaxStage(ctx, db)
+	m, db, err := openMigrator(cfg, driver)
 	if err != nil {
-		return nil, fmt.Errorf("read max stage: %w", err)
-	}
-	stage++
-
-	for i := range pending {
-		content, err := os.ReadFile(pending[i].Path)
-		if err != nil {
-			return nil, fmt.Errorf("read migration %s: %w", pending[i].Filename, err)
-		}
-
-		sqlText := strings.TrimSpace(string(content))
-		pending[i].SQL = sqlText
-	}
-
-	if err := driver.WithTransaction(ctx, db, func(tx *sql.Tx) error {
-		for _, migration := range pending {
-			if migration.SQL != "" {
-				if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
-					return fmt.Errorf("exec migration %s: %w", migration.Filename, err)
-				}
-			}
-			if err := driver.InsertMigration(ctx, tx, migration.Key(), stage); err != nil {
-				return fmt.Errorf("record migration %s: %w", migration.Filename, err)
-			}
-		}
-		return nil
-	}); err != nil {
 		return nil, err
 	}
+	defer db.Close()
 
-	appliedFiles := make([]string, 0, len(pending))
-	for _, migration := range pending {
-		appliedFiles = append(appliedFiles, migration.Filename)
-	}
-
-	return appliedFiles, nil
+	return m.UpTo(ctx, version)
 }
 
 // ApplyDown откатывает одну или несколько стадий через down-миграции в одной транзакции.
+// cfg.TxMode и директива no-transaction учитываются так же, как в ApplyUp.
 // Вход: ctx для отмены, cfg с DSN и директорией, реализация driver,
 // stagesToRollback — количество стадий для отката (1+).
 // Выход: результат отката и error при ошибках валидации, IO, БД или выполнения.
 // Назначение: безопасно откатить последние стадии.
+// Весь цикл выполняется под блокировкой миграций.
 // ApplyDown rolls back one or more stages using down migrations in one transaction.
+// cfg.TxMode and the no-transaction directive are honoured the same way as in ApplyUp.
 // Input: ctx for cancellation, cfg with DSN and directory, driver implementation,
 // stagesToRollback number of stages to undo (1+).
 // Output: rollback result and error on failures.
 // Purpose: safely roll back the latest stages.
+// The whole cycle runs under the migration lock.
 func ApplyDown(ctx context.Context, cfg Config, driver Driver, stagesToRollback int) (DownResult, error) {
 	if stagesToRollback <= 0 {
 		return DownResult{}, fmt.Errorf("stages to rollback must be positive")
 	}
-	if cfg.MigrationsDir == "" {
-		return DownResult{}, fmt.Errorf("migrations dir is empty")
-	}
-	if cfg.DSN == "" {
-		return DownResult{}, fmt.Errorf("dsn is empty")
-	}
 
-	migrations, err := ScanMigrations(cfg.MigrationsDir)
+	m, db, err := openMigrator(cfg, driver)
 	if err != nil {
 		return DownResult{}, err
 	}
-
-	db, err := driver.Open(cfg.DSN)
-	if err != nil {
-		return DownResult{}, fmt.Errorf("open database: %w", err)
-	}
 	defer db.Close()
 
-	if err := driver.EnsureSchema(ctx, db); err != nil {
-		return DownResult{}, fmt.Errorf("ensure lamigrate schema: %w", err)
-	}
+	return m.Down(ctx, stagesToRollback)
+}
 
-	downByName := map[string]Migration{}
-	for _, migration := range migrations {
-		if migration.Direction != DirectionDown {
-			continue
-		}
-		downByName[migration.Key()] = migration
+// ApplyDownTo откатывает миграции до цели (LastStages, ToStage, ToVersion).
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver, цель.
+// Выход: результат отката и error, как у ApplyDown.
+// Назначение: down -to и -to-stage.
+// ApplyDownTo rolls migrations back to a target (LastStages, ToStage, ToVersion).
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation, target.
+// Output: rollback result and error, as in ApplyDown.
+// Purpose: down -to and -to-stage.
+func ApplyDownTo(ctx context.Context, cfg Config, driver Driver, target DownTarget) (DownResult, error) {
+	if err := target.validate(); err != nil {
+		return DownResult{}, err
 	}
 
-	stages, err := driver.StagesDesc(ctx, db)
+	m, db, err := openMigrator(cfg, driver)
 	if err != nil {
-		return DownResult{}, fmt.Errorf("read stages: %w", err)
-	}
-	if len(stages) == 0 {
-		return DownResult{}, nil
+		return DownResult{}, err
 	}
+	defer db.Close()
 
-	if stagesToRollback > len(stages) {
-		stagesToRollback = len(stages)
+	return m.DownTo(ctx, target)
+}
+
+// ApplyRedo откатывает миграции цели и применяет их заново с прежними stage.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver, цель отката.
+// Выход: результат redo и error.
+// Назначение: команда redo.
+// ApplyRedo rolls back the target migrations and re-applies them with the same stages.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation, rollback target.
+// Output: redo result and error.
+// Purpose: the redo command.
+func ApplyRedo(ctx context.Context, cfg Config, driver Driver, target DownTarget) (RedoResult, error) {
+	if err := target.validate(); err != nil {
+		return RedoResult{}, err
 	}
-	stages = stages[:stagesToRollback]
 
-	var ordered []string
-	for _, stage := range stages {
-		names, err := driver.MigrationsByStage(ctx, db, stage)
-		if err != nil {
-			return DownResult{}, fmt.Errorf("read migrations for stage %d: %w", stage, err)
-		}
-		ordered = append(ordered, names...)
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return RedoResult{}, err
 	}
+	defer db.Close()
 
-	if len(ordered) == 0 {
-		return DownResult{}, nil
+	return m.Redo(ctx, target)
+}
+
+// ApplyFresh удаляет и пересоздаёт целевую схему, затем применяет все миграции.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver.
+// Выход: список выполненных файлов и error, как у ApplyUp.
+// Назначение: команда fresh. Разрушительно, см. Migrator.Fresh.
+// ApplyFresh drops and recreates the target schema, then applies all migrations.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation.
+// Output: list of executed filenames and error, as in ApplyUp.
+// Purpose: the fresh command. Destructive, see Migrator.Fresh.
+func ApplyFresh(ctx context.Context, cfg Config, driver Driver) ([]string, error) {
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	executed := make([]string, 0, len(ordered))
-	skipped := make([]string, 0)
-	if err := driver.WithTransaction(ctx, db, func(tx *sql.Tx) error {
-		for _, name := range ordered {
-			migration, ok := downByName[name]
-			if !ok {
-				return fmt.Errorf("missing down migration for %s", name)
-			}
+	return m.Fresh(ctx)
+}
 
-			content, err := os.ReadFile(migration.Path)
-			if err != nil {
-				return fmt.Errorf("read migration %s: %w", migration.Filename, err)
-			}
+// ApplyBaseline записывает миграции до версии включительно как применённые без выполнения SQL.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver, версия (14 цифр).
+// Выход: записанные ключи и error.
+// Назначение: команда baseline, см. Migrator.Baseline.
+// ApplyBaseline records migrations up to a version inclusive as applied without running SQL.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation, version (14 digits).
+// Output: recorded keys and error.
+// Purpose: the baseline command, see Migrator.Baseline.
+func ApplyBaseline(ctx context.Context, cfg Config, driver Driver, version string) ([]string, error) {
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
 
-			sqlText := strings.TrimSpace(string(content))
-			if sqlText == "" {
-				if err := driver.DeleteMigration(ctx, tx, name); err != nil {
-					return fmt.Errorf("delete migration %s: %w", migration.Filename, err)
-				}
-				skipped = append(skipped, migration.Filename)
-				fmt.Printf("skipped empty migration: %s\n", migration.Filename)
-				continue
-			}
+	return m.Baseline(ctx, version)
+}
 
-			if _, err := tx.ExecContext(ctx, sqlText); err != nil {
-				return fmt.Errorf("exec migration %s: %w", migration.Filename, err)
-			}
+// MarkApplied записывает отдельные миграции как применённые без выполнения SQL.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver, ключи или версии.
+// Выход: записанные ключи и error.
+// Назначение: команда mark-applied, см. Migrator.MarkApplied.
+// MarkApplied records individual migrations as applied without running SQL.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation, keys or versions.
+// Output: recorded keys and error.
+// Purpose: the mark-applied command, see Migrator.MarkApplied.
+func MarkApplied(ctx context.Context, cfg Config, driver Driver, keys ...string) ([]string, error) {
+	m, db, err := openMigrator(cfg, driver)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
 
-			if err := driver.DeleteMigration(ctx, tx, name); err != nil {
-				return fmt.Errorf("delete migration %s: %w", migration.Filename, err)
-			}
+	return m.MarkApplied(ctx, keys...)
+}
 
-			executed = append(executed, migration.Filename)
-			fmt.Printf("rolled back migration: %s\n", migration.Filename)
-		}
-		return nil
-	}); err != nil {
-		return DownResult{}, err
+// Unmark удаляет записи о миграциях без выполнения down-SQL.
+// Вход: ctx для отмены, cfg с DSN, реализация driver, ключи или версии.
+// Выход: удалённые ключи и error.
+// Назначение: команда unmark, см. Migrator.Unmark. Каталог миграций не читается:
+// запись удалённого файла тоже можно снять.
+// Unmark removes migration records without running down SQL.
+// Input: ctx for cancellation, cfg with DSN, driver implementation, keys or versions.
+// Output: removed keys and error.
+// Purpose: the unmark command, see Migrator.Unmark. The migrations directory is not
+// read, so a record of a deleted file can be removed too.
+func Unmark(ctx context.Context, cfg Config, driver Driver, keys ...string) ([]string, error) {
+	m, db, err := openHistoryMigrator(cfg, driver)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	return DownResult{
-		Executed: executed,
-		Skipped:  skipped,
-	}, nil
+	return m.Unmark(ctx, keys...)
 }
 
 // DownResult содержит результат отката.
@@ -250,14 +232,27 @@ func ListApplied(ctx context.Context, cfg Config, driver Driver) ([]AppliedMigra
 	}
 	defer db.Close()
 
-	if err := driver.EnsureSchema(ctx, db); err != nil {
-		return nil, fmt.Errorf("ensure lamigrate schema: %w", err)
+	m, err := NewMigrator(db, driver, withConfig(cfg))
+	if err != nil {
+		return nil, err
 	}
+	return m.Applied(ctx)
+}
 
-	applied, err := driver.AppliedMigrations(ctx, db)
+// ReadStatus сравнивает историю lamigrate с файлами без изменения БД.
+// Вход: ctx для отмены, cfg с DSN и директорией, реализация driver.
+// Выход: StatusReport или error.
+// Назначение: команда status.
+// ReadStatus compares lamigrate history with files without changing the database.
+// Input: ctx for cancellation, cfg with DSN and directory, driver implementation.
+// Output: StatusReport or error.
+// Purpose: the status command.
+func ReadStatus(ctx context.Context, cfg Config, driver Driver) (StatusReport, error) {
+	m, db, err := openMigrator(cfg, driver)
 	if err != nil {
-		return nil, fmt.Errorf("read applied migrations: %w", err)
+		return StatusReport{}, err
 	}
+	defer db.Close()
 
-	return applied, nil
+	return m.Status(ctx)
 }
@@ -2,25 +2,52 @@ package lamigrate
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"unicode"
 )
 
 var migrationPattern = regexp.MustCompile(`^(\d{14})_(.+)\.(up|down)\.sql$`)
 
+// noTransactionDirective отключает транзакцию для файла миграции.
+// noTransactionDirective disables the transaction for a migration file.
+const noTransactionDirective = "lamigrate:no-transaction"
+
 // ScanMigrations читает директорию и парсит файлы в метаданные миграций.
 // Вход: путь к директории с миграциями.
-// Выход: упорядоченный список Migration или error при IO/валидации.
+// Выход: упорядоченный список Migration (с SQL и checksum) или error при IO/валидации.
 // Назначение: получить детерминированный список для apply/rollback.
 // ScanMigrations reads a directory and parses files into migration metadata.
 // Input: path to migrations directory.
-// Output: ordered list of Migration or error on IO/validation.
+// Output: ordered list of Migration (with SQL and checksum) or error on IO/validation.
 // Purpose: build a deterministic list for apply/rollback.
 func ScanMigrations(dir string) ([]Migration, error) {
-	entries, err := os.ReadDir(dir)
+	migrations, err := ScanMigrationsFS(os.DirFS(dir))
+	if err != nil {
+		return nil, err
+	}
+	for i := range migrations {
+		if !migrations[i].Go {
+			migrations[i].Path = filepath.Join(dir, migrations[i].Filename)
+		}
+	}
+	return migrations, nil
+}
+
+// ScanMigrationsFS читает корень файловой системы и парсит файлы миграций.
+// Вход: fs.FS с файлами миграций в корне (например, fs.Sub от embed.FS).
+// Выход: упорядоченный список Migration вместе с Go-миграциями; Path — путь внутри fsys.
+// Назначение: поддержать встроенные в бинарник миграции.
+// ScanMigrationsFS reads the root of a file system and parses migration files.
+// Input: fs.FS with migration files at its root (e.g. fs.Sub of an embed.FS).
+// Output: ordered list of Migration merged with Go migrations; Path is the path inside fsys.
+// Purpose: support migrations embedded into the binary.
+func ScanMigrationsFS(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, fmt.Errorf("read migrations dir: %w", err)
 	}
@@ -45,15 +72,37 @@ func ScanMigrations(dir string) ([]Migration, error) {
 			return nil, fmt.Errorf("invalid migration name in file: %s", name)
 		}
 
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", name, err)
+		}
+
+		text := string(content)
+		sqlText := strings.TrimSpace(text)
 		migrations = append(migrations, Migration{
-			Version:   version,
-			Name:      migrationName,
-			Direction: direction,
-			Filename:  name,
-			Path:      filepath.Join(dir, name),
+			Version:       version,
+			Name:          migrationName,
+			Direction:     direction,
+			Filename:      name,
+			Path:          name,
+			SQL:           sqlText,
+			Checksum:      Checksum(content),
+			NoTransaction: hasDirective(sqlText, noTransactionDirective),
+			leading:       text[:len(text)-len(strings.TrimLeftFunc(text, unicode.IsSpace))],
 		})
 	}
 
+	fileKeys := make(map[string]bool, len(migrations))
+	for _, migration := range migrations {
+		fileKeys[migration.Key()] = true
+	}
+	for _, migration := range registeredGoMigrations() {
+		if fileKeys[migration.Key()] {
+			return nil, fmt.Errorf("migration %s is defined both as a SQL file and a Go function", migration.Key())
+		}
+		migrations = append(migrations, migration)
+	}
+
 	sort.Slice(migrations, func(i, j int) bool {
 		if migrations[i].Version != migrations[j].Version {
 			return migrations[i].Version < migrations[j].Version
@@ -66,3 +115,47 @@ func ScanMigrations(dir string) ([]Migration, error) {
 
 	return migrations, nil
 }
+
+// scanConfig читает миграции из источника, заданного в cfg.
+// Вход: cfg с Migrations и/или MigrationsDir.
+// Выход: упорядоченный список Migration или error.
+// Назначение: общий шаг ApplyUp/ApplyDown/Plan/Verify/Repair.
+// scanConfig reads migrations from the source set in cfg.
+// Input: cfg with Migrations and/or MigrationsDir.
+// Output: ordered list of Migration or error.
+// Purpose: shared step of ApplyUp/ApplyDown/Plan/Verify/Repair.
+func scanConfig(cfg Config) ([]Migration, error) {
+	if cfg.Migrations == nil && cfg.MigrationsDir != "" {
+		return ScanMigrations(cfg.MigrationsDir)
+	}
+
+	fsys, err := cfg.MigrationsFS()
+	if err != nil {
+		return nil, err
+	}
+	return ScanMigrationsFS(fsys)
+}
+
+// hasDirective ищет директиву lamigrate в заголовке файла.
+// Вход: SQL файла и имя директивы.
+// Выход: true, если директива есть в комментариях до первой SQL-строки.
+// Назначение: читать настройки миграции из самого файла.
+// hasDirective looks for a lamigrate directive in the file header.
+// Input: file SQL and directive name.
+// Output: true if the directive is in the comments before the first SQL line.
+// Purpose: read per-migration settings from the file itself.
+func hasDirective(sqlText, directive string) bool {
+	for _, line := range strings.Split(sqlText, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			return false
+		}
+		if strings.TrimSpace(strings.TrimPrefix(line, "--")) == directive {
+			return true
+		}
+	}
+	return false
+}
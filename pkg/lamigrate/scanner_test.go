@@ -0,0 +1,49 @@
+package lamigrate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestHasDirective(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want bool
+	}{
+		{name: "first line", sql: "-- lamigrate:no-transaction\nCREATE INDEX CONCURRENTLY i ON t (c);", want: true},
+		{name: "after other comments", sql: "-- add index\n--   lamigrate:no-transaction  \n\nCREATE INDEX i ON t (c);", want: true},
+		{name: "after sql", sql: "CREATE INDEX i ON t (c);\n-- lamigrate:no-transaction", want: false},
+		{name: "inside a statement", sql: "CREATE TABLE t (\n-- lamigrate:no-transaction\nid INT);", want: false},
+		{name: "other directive", sql: "-- lamigrate:no-transactions\nSELECT 1;", want: false},
+		{name: "block comment", sql: "/* lamigrate:no-transaction */\nSELECT 1;", want: false},
+		{name: "only comments", sql: "-- lamigrate:no-transaction", want: true},
+		{name: "empty", sql: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasDirective(tt.sql, noTransactionDirective); got != tt.want {
+				t.Fatalf("hasDirective(%q) = %v, want %v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanMigrationsFSNoTransaction(t *testing.T) {
+	migrations, err := ScanMigrationsFS(fstest.MapFS{
+		"20240101000000_index.up.sql":   {Data: []byte("\n-- lamigrate:no-transaction\nCREATE INDEX CONCURRENTLY i ON t (c);\n")},
+		"20240101000000_index.down.sql": {Data: []byte("DROP INDEX i;\n-- lamigrate:no-transaction\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[Direction]bool{}
+	for _, migration := range migrations {
+		got[migration.Direction] = migration.NoTransaction
+	}
+	if !got[DirectionUp] || got[DirectionDown] {
+		t.Fatalf("NoTransaction = %v, want only the up file with the header directive", got)
+	}
+}
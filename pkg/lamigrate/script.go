@@ -0,0 +1,349 @@
+package lamigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ReadHistory читает историю lamigrate без изменения БД.
+// Вход: ctx для отмены, cfg с DSN, реализация driver.
+// Выход: применённые миграции (пусто, если таблицы нет) или error.
+// Назначение: генерация скрипта с доступом только на чтение.
+// ReadHistory reads the lamigrate history without changing the database.
+// Input: ctx for cancellation, cfg with DSN, driver implementation.
+// Output: applied migrations (empty if the table is missing) or error.
+// Purpose: script generation with read-only access.
+func ReadHistory(ctx context.Context, cfg Config, driver Driver) ([]AppliedMigration, error) {
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("dsn is empty")
+	}
+	driver, err := historyDriver(driver, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := driver.Open(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	reader, exists, err := existingSchema(ctx, db, driver)
+	if err != nil {
+		return nil, fmt.Errorf("check lamigrate schema: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	applied, err := reader.AppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// LoadHistoryFile читает экспортированную историю из JSON-файла.
+// Вход: путь к файлу с массивом {"migration", "stage", "checksum"}.
+// Выход: применённые миграции в порядке stage или error.
+// Назначение: генерация скрипта вообще без подключения к БД.
+// LoadHistoryFile reads exported history from a JSON file.
+// Input: path to a file with an array of {"migration", "stage", "checksum"}.
+// Output: applied migrations ordered by stage or error.
+// Purpose: script generation without any database connection.
+func LoadHistoryFile(path string) ([]AppliedMigration, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read history file: %w", err)
+	}
+
+	var applied []AppliedMigration
+	if err := json.Unmarshal(content, &applied); err != nil {
+		return nil, fmt.Errorf("parse history file %s: %w", path, err)
+	}
+
+	for _, item := range applied {
+		if item.Migration == "" || item.Stage <= 0 {
+			return nil, fmt.Errorf("parse history file %s: each row needs migration and a positive stage", path)
+		}
+	}
+
+	sort.SliceStable(applied, func(i, j int) bool {
+		return applied[i].Stage < applied[j].Stage
+	})
+	return applied, nil
+}
+
+// ScriptUp генерирует самодостаточный SQL-скрипт применения новых миграций.
+// Вход: просканированные миграции, текущая история, driver, режим транзакций,
+// metaVersion — версия служебных таблиц целевой БД (0 — таблиц нет).
+// Выход: текст скрипта (пустой, если применять нечего) или error
+// (в т.ч. *ChecksumError и ErrMetaTooNew).
+// Назначение: деплой руками DBA без прав DDL у бинарника. Недостающие шаги служебных
+// таблиц идут в начале скрипта, а каждая миграция пишет строку в lamigrate и в журнал.
+// ScriptUp renders a self-contained SQL script applying pending migrations.
+// Input: scanned migrations, current history, driver, transaction mode,
+// metaVersion is the bookkeeping table version of the target database (0 means no tables).
+// Output: script text (empty when nothing is pending) or error
+// (including *ChecksumError and ErrMetaTooNew).
+// Purpose: DBA-run deployments without DDL rights for the binary. Missing bookkeeping
+// steps go at the top of the script, and each migration writes a lamigrate and a journal row.
+func ScriptUp(migrations []Migration, applied []AppliedMigration, driver Driver, txMode TxMode, metaVersion int) (string, error) {
+	txMode, err := ResolveTxMode(driver, txMode)
+	if err != nil {
+		return "", err
+	}
+	if err := VerifyChecksums(migrations, applied); err != nil {
+		return "", err
+	}
+
+	pending := pendingUp(migrations, applied)
+	if len(pending) == 0 {
+		return "", nil
+	}
+	if err := checkScriptable(pending); err != nil {
+		return "", err
+	}
+	upgrades, err := metaUpgrades(driver)
+	if err != nil {
+		return "", err
+	}
+	if metaVersion > len(upgrades) {
+		return "", fmt.Errorf("%w: database metadata version %d, this lamigrate %s supports up to %d; upgrade lamigrate",
+			ErrMetaTooNew, metaVersion, Version, len(upgrades))
+	}
+
+	stage := 1
+	for _, item := range applied {
+		if item.Stage >= stage {
+			stage = item.Stage + 1
+		}
+	}
+
+	stages := make([]int, len(pending))
+	for i := range stages {
+		stages[i] = stage
+	}
+
+	hostname := currentHostname()
+	osUser := currentOSUser()
+	prelude := renderMetaUpgrades(driver, upgrades[metaVersion:])
+	return renderScript(Plan{
+		Direction: DirectionUp,
+		TxMode:    txMode,
+		Items:     planItems(pending, stages, txMode),
+	}, prelude, func(item PlanItem) []string {
+		return []string{
+			driver.ScriptInsertMigration(MigrationRecord{
+				Migration: item.Migration.Key(),
+				Stage:     item.Stage,
+				Checksum:  item.Migration.Checksum,
+				Origin:    OriginApply,
+				Hostname:  hostname,
+				Version:   Version,
+			}),
+			driver.ScriptInsertJournal(JournalEntry{
+				Action:    JournalUp,
+				Migration: item.Migration.Key(),
+				Stage:     item.Stage,
+				Checksum:  item.Migration.Checksum,
+				OSUser:    osUser,
+				Hostname:  hostname,
+				Version:   Version,
+			}),
+		}
+	}), nil
+}
+
+// ScriptDown генерирует SQL-скрипт отката последних стадий.
+// Вход: просканированные миграции, текущая история, driver,
+// stagesToRollback — количество стадий, режим транзакций.
+// Выход: текст скрипта (пустой, если откатывать нечего) или error.
+// Назначение: откат руками DBA без прав DDL у бинарника.
+// ScriptDown renders a SQL script rolling back the latest stages.
+// Input: scanned migrations, current history, driver,
+// stagesToRollback number of stages, transaction mode.
+// Output: script text (empty when there is nothing to roll back) or error.
+// Purpose: DBA-run rollbacks without DDL rights for the binary.
+func ScriptDown(migrations []Migration, applied []AppliedMigration, driver Driver, stagesToRollback int, txMode TxMode) (string, error) {
+	if stagesToRollback <= 0 {
+		return "", fmt.Errorf("stages to rollback must be positive")
+	}
+	txMode, err := ResolveTxMode(driver, txMode)
+	if err != nil {
+		return "", err
+	}
+
+	downByName := map[string]Migration{}
+	for _, migration := range migrations {
+		if migration.Direction == DirectionDown {
+			downByName[migration.Key()] = migration
+		}
+	}
+
+	var rollback []Migration
+	var stages []int
+	checksums := map[string]string{}
+	taken := 0
+	for i := len(applied) - 1; i >= 0; i-- {
+		item := applied[i]
+		if len(stages) == 0 || stages[len(stages)-1] != item.Stage {
+			if taken == stagesToRollback {
+				break
+			}
+			taken++
+		}
+		migration, ok := downByName[item.Migration]
+		if !ok {
+			return "", fmt.Errorf("missing down migration for %s", item.Migration)
+		}
+		rollback = append(rollback, migration)
+		stages = append(stages, item.Stage)
+		checksums[item.Migration] = item.Checksum
+	}
+
+	if len(rollback) == 0 {
+		return "", nil
+	}
+	if err := checkScriptable(rollback); err != nil {
+		return "", err
+	}
+
+	hostname := currentHostname()
+	osUser := currentOSUser()
+	return renderScript(Plan{
+		Direction: DirectionDown,
+		TxMode:    txMode,
+		Items:     planItems(rollback, stages, txMode),
+	}, "", func(item PlanItem) []string {
+		return []string{
+			driver.ScriptDeleteMigration(item.Migration.Key()),
+			driver.ScriptInsertJournal(JournalEntry{
+				Action:    JournalDown,
+				Migration: item.Migration.Key(),
+				Stage:     item.Stage,
+				Checksum:  checksums[item.Migration.Key()],
+				OSUser:    osUser,
+				Hostname:  hostname,
+				Version:   Version,
+			}),
+		}
+	}), nil
+}
+
+// checkScriptable проверяет, что миграции можно выразить в SQL.
+// Вход: миграции для скрипта.
+// Выход: error для первой Go-миграции с кодом.
+// Назначение: не выпустить скрипт, молча пропускающий Go-миграции.
+// checkScriptable checks that migrations can be expressed in SQL.
+// Input: migrations for the script.
+// Output: error for the first Go migration with code.
+// Purpose: never emit a script that silently skips Go migrations.
+func checkScriptable(migrations []Migration) error {
+	for _, migration := range migrations {
+		if migration.Func != nil {
+			return fmt.Errorf("migration %s is a Go function and cannot be rendered as SQL; run it with lamigrate up/down", migration.Filename)
+		}
+	}
+	return nil
+}
+
+// renderMetaUpgrades печатает недостающие шаги служебных таблиц для начала скрипта.
+// Вход: driver, шаги после текущей версии БД.
+// Выход: SQL шагов вместе со строками <table>_meta (пусто, если шагов нет); каждый шаг
+// в своей транзакции, если DDL драйвера транзакционный.
+// Назначение: скрипт для пустой БД создаёт историю, журнал и <table>_meta, как сделал бы up.
+// renderMetaUpgrades prints the missing bookkeeping steps for the top of a script.
+// Input: driver, steps after the current database version.
+// Output: step SQL with their <table>_meta rows (empty when there are no steps); each step
+// in its own transaction when the driver's DDL is transactional.
+// Purpose: a script for an empty database creates the history, journal and <table>_meta like up would.
+func renderMetaUpgrades(driver Driver, upgrades []MetaUpgrade) string {
+	var b strings.Builder
+	for _, upgrade := range upgrades {
+		fmt.Fprintf(&b, "\n-- lamigrate metadata %d: %s\n", upgrade.Version, upgrade.Description)
+		if driver.TransactionalDDL() {
+			b.WriteString("BEGIN;\n")
+		}
+		for _, statement := range upgrade.Script {
+			b.WriteString(terminateStatement(strings.TrimSpace(statement)))
+			b.WriteString("\n")
+		}
+		b.WriteString(driver.ScriptInsertMetaRecord(MetaRecord{
+			Version:          upgrade.Version,
+			Description:      upgrade.Description,
+			LamigrateVersion: Version,
+		}))
+		b.WriteString("\n")
+		if driver.TransactionalDDL() {
+			b.WriteString("COMMIT;\n")
+		}
+	}
+	return b.String()
+}
+
+// renderScript собирает текст скрипта по плану.
+// Вход: план, prelude — SQL до миграций (шаги служебных таблиц), функция, возвращающая
+// SQL записи в lamigrate и журнал для элемента.
+// Выход: текст скрипта с BEGIN/COMMIT вокруг транзакционных групп.
+// Назначение: общий рендер для ScriptUp/ScriptDown.
+// renderScript assembles script text from a plan.
+// Input: plan, prelude SQL before the migrations (bookkeeping steps), a function returning
+// lamigrate and journal bookkeeping SQL for an item.
+// Output: script text with BEGIN/COMMIT around transactional groups.
+// Purpose: shared renderer for ScriptUp/ScriptDown.
+func renderScript(plan Plan, prelude string, bookkeeping func(item PlanItem) []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- lamigrate script: %s, %d migrations, tx-mode %s\n", plan.Direction, len(plan.Items), plan.TxMode)
+	b.WriteString("-- Stop on the first error (psql: -v ON_ERROR_STOP=1).\n")
+	b.WriteString("-- hostname and os_user in lamigrate rows are those of the machine that generated the script.\n")
+	b.WriteString(prelude)
+
+	for i, item := range plan.Items {
+		opensGroup := item.TxGroup > 0 && (i == 0 || plan.Items[i-1].TxGroup != item.TxGroup)
+		closesGroup := item.TxGroup > 0 && (i == len(plan.Items)-1 || plan.Items[i+1].TxGroup != item.TxGroup)
+
+		b.WriteString("\n")
+		if opensGroup {
+			b.WriteString("BEGIN;\n\n")
+		}
+
+		fmt.Fprintf(&b, "-- %s (stage %d)\n", item.Migration.Filename, item.Stage)
+		if item.Migration.SQL != "" {
+			b.WriteString(terminateStatement(item.Migration.SQL))
+			b.WriteString("\n")
+		}
+		for _, statement := range bookkeeping(item) {
+			b.WriteString(statement)
+			b.WriteString("\n")
+		}
+
+		if closesGroup {
+			b.WriteString("\nCOMMIT;\n")
+		}
+	}
+	return b.String()
+}
+
+// terminateStatement гарантирует, что SQL заканчивается точкой с запятой.
+// Вход: SQL файла миграции.
+// Выход: SQL с завершающим ";" (на новой строке, если файл кончается комментарием).
+// Назначение: не склеить миграцию со следующей командой скрипта.
+// terminateStatement ensures SQL ends with a semicolon.
+// Input: migration file SQL.
+// Output: SQL with a trailing ";" (on a new line if the file ends with a comment).
+// Purpose: avoid merging a migration with the next script statement.
+func terminateStatement(sqlText string) string {
+	if strings.HasSuffix(sqlText, ";") {
+		return sqlText
+	}
+	lines := strings.Split(sqlText, "\n")
+	if strings.HasPrefix(strings.TrimSpace(lines[len(lines)-1]), "--") {
+		return sqlText + "\n;"
+	}
+	return sqlText + ";"
+}
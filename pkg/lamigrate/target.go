@@ -0,0 +1,193 @@
+package lamigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// downKind — способ выбора миграций для отката.
+// downKind is the way of selecting migrations to roll back.
+type downKind int
+
+const (
+	downLastStages downKind = iota + 1
+	downToStage
+	downToVersion
+	downMigration
+)
+
+// DownTarget задаёт, докуда откатывать миграции.
+// Назначение: один тип для down -stages, -to-stage, -to и -migration.
+// Создаётся через LastStages, ToStage, ToVersion или SingleMigration.
+// DownTarget defines how far to roll migrations back.
+// Purpose: one type for down -stages, -to-stage, -to and -migration.
+// Created with LastStages, ToStage, ToVersion or SingleMigration.
+type DownTarget struct {
+	kind    downKind
+	stages  int
+	stage   int
+	version string
+	key     string
+	force   bool
+}
+
+// LastStages откатывает n последних стадий.
+// LastStages rolls back the latest n stages.
+func LastStages(n int) DownTarget {
+	return DownTarget{kind: downLastStages, stages: n}
+}
+
+// ToStage откатывает все стадии выше stage (stage остаётся применённым; 0 — откатить всё).
+// ToStage rolls back every stage above stage (stage stays applied; 0 rolls back everything).
+func ToStage(stage int) DownTarget {
+	return DownTarget{kind: downToStage, stage: stage}
+}
+
+// ToVersion откатывает все миграции с версией выше version (version остаётся применённой).
+// ToVersion rolls back every migration with a version above version (version stays applied).
+func ToVersion(version string) DownTarget {
+	return DownTarget{kind: downToVersion, version: version}
+}
+
+// SingleMigration откатывает одну применённую миграцию по ключу "version_name" или версии.
+// Если после неё применены другие миграции (в той же стадии с большей версией
+// или в более поздних стадиях), откат отклоняется, пока не задан force. Это проверка
+// порядка применения, а не зависимостей: что именно использует миграция, lamigrate не знает.
+// SingleMigration rolls back one applied migration by "version_name" key or version.
+// If other migrations were applied after it (in the same stage with a higher
+// version or in later stages), the rollback is refused unless force is set. This is an
+// application order check, not a dependency check: lamigrate does not know what a migration uses.
+func SingleMigration(key string, force bool) DownTarget {
+	return DownTarget{kind: downMigration, key: key, force: force}
+}
+
+// validate проверяет параметры цели отката.
+// Вход: нет.
+// Выход: error для пустой цели или неверных значений.
+// Назначение: ранняя ошибка до подключения к БД.
+// validate checks rollback target parameters.
+// Input: none.
+// Output: error for an empty target or invalid values.
+// Purpose: fail early before touching the database.
+func (t DownTarget) validate() error {
+	switch t.kind {
+	case downLastStages:
+		if t.stages <= 0 {
+			return fmt.Errorf("stages to rollback must be positive")
+		}
+	case downToStage:
+		if t.stage < 0 {
+			return fmt.Errorf("target stage must not be negative")
+		}
+	case downToVersion:
+		if !versionPattern.MatchString(t.version) {
+			return fmt.Errorf("target version must be 14 digits, got %q", t.version)
+		}
+	case downMigration:
+		if t.key == "" {
+			return fmt.Errorf("migration key is empty")
+		}
+	default:
+		return fmt.Errorf("rollback target is not set")
+	}
+	return nil
+}
+
+// String описывает цель отката для логов.
+// String describes the rollback target for logs.
+func (t DownTarget) String() string {
+	switch t.kind {
+	case downLastStages:
+		return fmt.Sprintf("last %d stages", t.stages)
+	case downToStage:
+		return fmt.Sprintf("to stage %d", t.stage)
+	case downToVersion:
+		return "to version " + t.version
+	case downMigration:
+		return "migration " + t.key
+	default:
+		return "unset"
+	}
+}
+
+// validateUpTarget проверяет версию для up -to.
+// Вход: версия (пустая — без ограничения).
+// Выход: error для версии не из 14 цифр.
+// Назначение: ранняя ошибка до подключения к БД.
+// validateUpTarget checks the version for up -to.
+// Input: version (empty means no limit).
+// Output: error for a version that is not 14 digits.
+// Purpose: fail early before touching the database.
+func validateUpTarget(version string) error {
+	if version != "" && !versionPattern.MatchString(version) {
+		return fmt.Errorf("target version must be 14 digits, got %q", version)
+	}
+	return nil
+}
+
+// upToVersion оставляет миграции с версией не выше version.
+// Вход: упорядоченные миграции и версия (пустая — без ограничения).
+// Выход: отфильтрованный список.
+// Назначение: up -to.
+// upToVersion keeps migrations with a version not above version.
+// Input: ordered migrations and version (empty means no limit).
+// Output: filtered list.
+// Purpose: up -to.
+func upToVersion(migrations []Migration, version string) []Migration {
+	if version == "" {
+		return migrations
+	}
+	var selected []Migration
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			selected = append(selected, migration)
+		}
+	}
+	return selected
+}
+
+// keyVersion возвращает версию из ключа миграции "version_name".
+// keyVersion returns the version from a "version_name" migration key.
+func keyVersion(key string) string {
+	version, _, _ := strings.Cut(key, "_")
+	return version
+}
+
+// keyAfter сообщает, что миграция с ключом a идёт после b в порядке применения.
+// Вход: два ключа "version_name".
+// Выход: true, если версия a больше или версии равны и имя a больше.
+// Назначение: тот же порядок, что у сканера файлов (версия, затем имя).
+// keyAfter reports whether the migration with key a comes after b in apply order.
+// Input: two "version_name" keys.
+// Output: true if a has a greater version, or equal versions and a greater name.
+// Purpose: the same order as the file scanner (version, then name).
+func keyAfter(a, b string) bool {
+	if versionA, versionB := keyVersion(a), keyVersion(b); versionA != versionB {
+		return versionA > versionB
+	}
+	return a > b
+}
+
+// matchKey находит ключ миграции по полному ключу "version_name" или версии.
+// Вход: ключи-кандидаты и искомое значение.
+// Выход: найденный ключ, true, если найден; error, если версии соответствуют несколько ключей.
+// Назначение: общий разбор ключей для mark-applied, unmark и down -migration.
+// matchKey finds a migration key by the full "version_name" key or version.
+// Input: candidate keys and the value to look for.
+// Output: found key, true if found; error if several keys match the version.
+// Purpose: shared key resolution for mark-applied, unmark and down -migration.
+func matchKey(keys []string, key string) (string, bool, error) {
+	found := ""
+	for _, candidate := range keys {
+		if candidate == key {
+			return candidate, true, nil
+		}
+		if keyVersion(candidate) == key {
+			if found != "" {
+				return "", false, fmt.Errorf("version %s matches several migrations, use the full key", key)
+			}
+			found = candidate
+		}
+	}
+	return found, found != "", nil
+}
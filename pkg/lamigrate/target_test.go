@@ -0,0 +1,222 @@
+package lamigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// historyStub отдаёт историю из памяти, остальные методы Driver не вызываются.
+// historyStub serves history from memory; other Driver methods are never called.
+type historyStub struct {
+	Driver
+	applied []AppliedMigration
+}
+
+func (d historyStub) AppliedMigrations(context.Context, *sql.DB) ([]AppliedMigration, error) {
+	return d.applied, nil
+}
+
+func (d historyStub) StagesDesc(context.Context, *sql.DB) ([]int, error) {
+	var stages []int
+	for i := len(d.applied) - 1; i >= 0; i-- {
+		if stage := d.applied[i].Stage; !slices.Contains(stages, stage) {
+			stages = append(stages, stage)
+		}
+	}
+	return stages, nil
+}
+
+func (d historyStub) MigrationsByStage(_ context.Context, _ *sql.DB, stage int) ([]string, error) {
+	var names []string
+	for i := len(d.applied) - 1; i >= 0; i-- {
+		if d.applied[i].Stage == stage {
+			names = append(names, d.applied[i].Migration)
+		}
+	}
+	return names, nil
+}
+
+// targetHistory — пять миграций в трёх стадиях: a, b в 1; c, d в 2; e в 3.
+// targetHistory is five migrations in three stages: a, b in 1; c, d in 2; e in 3.
+var targetHistory = historyStub{applied: []AppliedMigration{
+	{Migration: "20240101000000_a", Stage: 1},
+	{Migration: "20240102000000_b", Stage: 1},
+	{Migration: "20240103000000_c", Stage: 2},
+	{Migration: "20240104000000_d", Stage: 2},
+	{Migration: "20240105000000_e", Stage: 3},
+}}
+
+// targetMigrations возвращает up- и down-файлы для ключей; down-файлов нет для noDown.
+// targetMigrations returns up and down files for the keys; noDown keys get no down file.
+func targetMigrations(keys []string, noDown ...string) []Migration {
+	var migrations []Migration
+	for _, key := range keys {
+		version, name, _ := strings.Cut(key, "_")
+		migrations = append(migrations, Migration{Version: version, Name: name, Direction: DirectionUp, Filename: key + ".up.sql"})
+		if !slices.Contains(noDown, key) {
+			migrations = append(migrations, Migration{Version: version, Name: name, Direction: DirectionDown, Filename: key + ".down.sql"})
+		}
+	}
+	return migrations
+}
+
+// planKeys описывает план как "ключ@стадия".
+// planKeys describes a plan as "key@stage".
+func planKeys(items []PlanItem) []string {
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, fmt.Sprintf("%s@%d", item.Migration.Key(), item.Stage))
+	}
+	return keys
+}
+
+func TestPlanDownTargets(t *testing.T) {
+	keys := []string{"20240101000000_a", "20240102000000_b", "20240103000000_c", "20240104000000_d", "20240105000000_e"}
+
+	tests := []struct {
+		name    string
+		target  DownTarget
+		noDown  []string
+		want    []string
+		wantErr string
+	}{
+		{name: "last stage", target: LastStages(1), want: []string{"20240105000000_e@3"}},
+		{name: "last two stages", target: LastStages(2), want: []string{"20240105000000_e@3", "20240104000000_d@2", "20240103000000_c@2"}},
+		{name: "to stage 2", target: ToStage(2), want: []string{"20240105000000_e@3"}},
+		{
+			name:   "to stage 0 rolls back everything",
+			target: ToStage(0),
+			want:   []string{"20240105000000_e@3", "20240104000000_d@2", "20240103000000_c@2", "20240102000000_b@1", "20240101000000_a@1"},
+		},
+		{name: "to stage above the latest", target: ToStage(5), want: []string{}},
+		{name: "to version inside a stage", target: ToVersion("20240103000000"), want: []string{"20240105000000_e@3", "20240104000000_d@2"}},
+		{name: "to version at a stage end", target: ToVersion("20240102000000"), want: []string{"20240105000000_e@3", "20240104000000_d@2", "20240103000000_c@2"}},
+		{name: "to an unknown version between", target: ToVersion("20240103120000"), want: []string{"20240105000000_e@3", "20240104000000_d@2"}},
+		{name: "to a version after all", target: ToVersion("20990101000000"), want: []string{}},
+		{name: "to a version before all", target: ToVersion("20000101000000"), want: []string{"20240105000000_e@3", "20240104000000_d@2", "20240103000000_c@2", "20240102000000_b@1", "20240101000000_a@1"}},
+		{name: "missing down file", target: ToStage(1), noDown: []string{"20240104000000_d"}, wantErr: "missing down migration for 20240104000000_d"},
+		{name: "missing down file below the target", target: ToStage(2), noDown: []string{"20240104000000_d"}, want: []string{"20240105000000_e@3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.target.validate(); err != nil {
+				t.Fatal(err)
+			}
+			items, err := planDown(context.Background(), nil, targetHistory, targetMigrations(keys, tt.noDown...), tt.target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("planDown(%s) error = %v, want %q", tt.target, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := planKeys(items); !slices.Equal(got, tt.want) {
+				t.Fatalf("planDown(%s) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanDownSingle(t *testing.T) {
+	keys := []string{"20240101000000_a", "20240102000000_b", "20240103000000_c", "20240104000000_d", "20240105000000_e"}
+
+	tests := []struct {
+		name    string
+		target  DownTarget
+		want    []string
+		wantErr string
+	}{
+		{name: "latest without force", target: SingleMigration("20240105000000_e", false), want: []string{"20240105000000_e@3"}},
+		{name: "latest of a stage by version", target: SingleMigration("20240104000000", false), wantErr: "migrations applied after it: 20240105000000_e"},
+		{
+			name:    "earlier without force",
+			target:  SingleMigration("20240103000000_c", false),
+			wantErr: "migrations applied after it: 20240104000000_d, 20240105000000_e (lamigrate checks only the order of application",
+		},
+		{name: "earlier with force", target: SingleMigration("20240103000000_c", true), want: []string{"20240103000000_c@2"}},
+		{name: "first with force", target: SingleMigration("20240101000000", true), want: []string{"20240101000000_a@1"}},
+		{name: "not applied", target: SingleMigration("20240106000000", true), wantErr: "migration 20240106000000 is not applied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := planDown(context.Background(), nil, targetHistory, targetMigrations(keys), tt.target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("planDown(%s) error = %v, want %q", tt.target, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := planKeys(items); !slices.Equal(got, tt.want) {
+				t.Fatalf("planDown(%s) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownTargetValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  DownTarget
+		wantErr bool
+	}{
+		{name: "to stage 0", target: ToStage(0)},
+		{name: "negative stage", target: ToStage(-1), wantErr: true},
+		{name: "zero stages", target: LastStages(0), wantErr: true},
+		{name: "short version", target: ToVersion("2024"), wantErr: true},
+		{name: "empty key", target: SingleMigration("", false), wantErr: true},
+		{name: "unset", target: DownTarget{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.target.validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("validate(%s) = %v, want error %v", tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpToVersion(t *testing.T) {
+	pending := targetMigrations([]string{"20240101000000_a", "20240102000000_b", "20240103000000_c"})
+	var up []Migration
+	for _, migration := range pending {
+		if migration.Direction == DirectionUp {
+			up = append(up, migration)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		want    []string
+	}{
+		{name: "no limit", version: "", want: []string{"20240101000000_a", "20240102000000_b", "20240103000000_c"}},
+		{name: "inclusive", version: "20240102000000", want: []string{"20240101000000_a", "20240102000000_b"}},
+		{name: "unknown version between", version: "20240102120000", want: []string{"20240101000000_a", "20240102000000_b"}},
+		{name: "before all", version: "20000101000000", want: []string{}},
+		{name: "after all", version: "20990101000000", want: []string{"20240101000000_a", "20240102000000_b", "20240103000000_c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, migration := range upToVersion(up, tt.version) {
+				got = append(got, migration.Key())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("upToVersion(%q) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}